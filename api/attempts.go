@@ -0,0 +1,99 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/shadiestgoat/who/db"
+)
+
+// Questions can have a hint, which is revealed after HintAfter wrong attempts,
+// and an attempt cap, after which the viewer is shown the answer & moved on to the next question.
+// Wrong attempts are tracked per play session, so viewers without one never get hints.
+
+func (q *FullQuestion) sanitizeAttempts() error {
+	if err := cleanString(&q.Hint, -1, 65, "questions.hint"); err != nil {
+		return err
+	}
+	if err := validateTemplate(q.Hint); err != nil {
+		return err
+	}
+
+	if q.MaxAttempts < 0 || q.MaxAttempts > 20 {
+		return &HTTPError{
+			Msg:    "Max attempts has to be 0-20",
+			Status: 400,
+		}
+	}
+
+	if q.Hint == "" {
+		q.HintAfter = 0
+		return nil
+	}
+
+	if q.HintAfter < 1 || q.HintAfter > 20 || (q.MaxAttempts != 0 && q.HintAfter >= q.MaxAttempts) {
+		return &HTTPError{
+			Msg:    "Hint has to be revealed after 1-20 wrong attempts, before running out of attempts",
+			Status: 400,
+		}
+	}
+
+	return nil
+}
+
+// Records a wrong attempt, returns the amount of wrong attempts the play session made on this step.
+// Returns 0 if the play session is not of this quiz.
+func recordWrongAnswer(play, quizID string, step StepID) int {
+	if !isPlayOf(play, quizID) {
+		return 0
+	}
+
+	wrong := 0
+
+	err := db.QueryRow(
+		`INSERT INTO attempts (play, question, section, wrong) VALUES ($1, $2, $3, 1) `+
+			`ON CONFLICT (play, question, section) DO UPDATE SET wrong = attempts.wrong + 1 RETURNING wrong`,
+		[]any{play, step.Question, step.Section},
+		&wrong,
+	)
+
+	if err != nil {
+		return 0
+	}
+
+	return wrong
+}
+
+// The correct answer, in a form that can be shown to the viewer
+func (q *FullQuestion) correctAnswerText() string {
+	switch q.Type {
+	case QUESTION_TEXT:
+		if len(q.Answers) != 0 {
+			return q.Answers[0]
+		}
+	case QUESTION_CHOICE:
+		if q.CorrectAnswer < len(q.Answers) && q.CorrectAnswer >= 0 {
+			return q.Answers[q.CorrectAnswer]
+		}
+	case QUESTION_MULTI:
+		correct := []string{}
+
+		for _, c := range q.CorrectAnswers {
+			if c < len(q.Answers) && c >= 0 {
+				correct = append(correct, q.Answers[c])
+			}
+		}
+
+		return strings.Join(correct, ", ")
+	case QUESTION_NUMERIC:
+		return strconv.FormatFloat(q.NumericAnswer, 'f', -1, 64)
+	case QUESTION_YES_NO:
+		if q.CorrectAnswer == 0 {
+			return "Yes"
+		}
+
+		return "No"
+	}
+
+	return ""
+}
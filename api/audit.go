@@ -0,0 +1,205 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/snownode"
+	"github.com/shadiestgoat/who/vault"
+)
+
+// An append only log of changes to accounts & quizzes, so that users can check who changed what.
+// Entries belong to the account that was affected (ie. the quiz author), & are readable by that account only.
+
+type AuditAction string
+
+const (
+	AUDIT_LOGIN           AuditAction = "login"
+	AUDIT_LOGIN_FAILED    AuditAction = "login.failed"
+	AUDIT_PASSWORD_CHANGE AuditAction = "password.change"
+	AUDIT_TOKEN_ROTATE    AuditAction = "token.rotate"
+	AUDIT_QUIZ_CREATE     AuditAction = "quiz.create"
+	AUDIT_QUIZ_EDIT       AuditAction = "quiz.edit"
+	AUDIT_QUIZ_DELETE     AuditAction = "quiz.delete"
+	AUDIT_QUESTION_EDIT   AuditAction = "question.edit"
+	AUDIT_2FA_ENABLE      AuditAction = "2fa.enable"
+	AUDIT_2FA_DISABLE     AuditAction = "2fa.disable"
+	AUDIT_2FA_RECOVERY    AuditAction = "2fa.recovery.regenerate"
+	AUDIT_PASSKEY_ADD     AuditAction = "passkey.add"
+	AUDIT_PASSKEY_REMOVE  AuditAction = "passkey.remove"
+	AUDIT_IDENTITY_LINK   AuditAction = "identity.link"
+	AUDIT_IDENTITY_UNLINK AuditAction = "identity.unlink"
+)
+
+// Who made a change. A nil actor is the server itself (ie. background jobs).
+type Actor struct {
+	// Empty if unknown, ie. before logging in
+	User      string
+	IP        string
+	UserAgent string
+}
+
+type FieldDiff struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+type AuditEntry struct {
+	ID     string      `json:"id"`
+	Time   time.Time   `json:"time"`
+	Action AuditAction `json:"action"`
+	// The ID of the changed quiz/question, if any
+	Target    string                `json:"target,omitempty"`
+	Actor     string                `json:"actor,omitempty"`
+	IP        string                `json:"ip,omitempty"`
+	UserAgent string                `json:"userAgent,omitempty"`
+	Diff      map[string]*FieldDiff `json:"diff,omitempty"`
+}
+
+// JSON fields that are redacted in diffs. A change is still recorded, but not the values.
+var auditSensitiveFields = map[string]bool{
+	"deadNames":    true,
+	"deadLastName": true,
+}
+
+func auditFields(v any) map[string]any {
+	fields := map[string]any{}
+
+	if rv := reflect.ValueOf(v); v == nil || (rv.Kind() == reflect.Pointer && rv.IsNil()) {
+		return fields
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+
+	json.Unmarshal(b, &fields)
+
+	return fields
+}
+
+// A field level diff of the JSON representations of 2 values. Either of them can be nil.
+func auditDiff(old, new any) map[string]*FieldDiff {
+	o, n := auditFields(old), auditFields(new)
+	diff := map[string]*FieldDiff{}
+
+	keys := map[string]bool{}
+	for k := range o {
+		keys[k] = true
+	}
+	for k := range n {
+		keys[k] = true
+	}
+
+	for k := range keys {
+		if reflect.DeepEqual(o[k], n[k]) {
+			continue
+		}
+
+		d := &FieldDiff{
+			Old: o[k],
+			New: n[k],
+		}
+
+		if auditSensitiveFields[k] {
+			if d.Old != nil {
+				d.Old = vault.REDACTED
+			}
+			if d.New != nil {
+				d.New = vault.REDACTED
+			}
+		}
+
+		diff[k] = d
+	}
+
+	return diff
+}
+
+func auditQuizDiff(old, new *Quiz) map[string]*FieldDiff {
+	var o, n *RevealedQuiz
+
+	if old != nil {
+		o, _ = RevealQuiz(old, nil)
+	}
+	if new != nil {
+		n, _ = RevealQuiz(new, nil)
+	}
+
+	return auditDiff(o, n)
+}
+
+// Records an entry for the owner account. Errors are logged, not returned: the change itself already happened.
+func audit(owner string, by *Actor, action AuditAction, target string, diff map[string]*FieldDiff) {
+	if owner == "" {
+		return
+	}
+
+	if by == nil {
+		by = &Actor{}
+	}
+
+	if diff == nil {
+		diff = map[string]*FieldDiff{}
+	}
+
+	b, err := json.Marshal(diff)
+	if log.ErrorIfErr(err, "encoding audit diff") {
+		return
+	}
+
+	db.InsertOne(
+		`audit_log`,
+		[]string{`id`, `user_id`, `action`, `target`, `actor`, `ip`, `user_agent`, `diff`},
+		snownode.Generate(), owner, string(action), target, by.User, by.IP, by.UserAgent, string(b),
+	)
+}
+
+// Returns up to 100 entries of the user, newest first. before is an optional entry ID, for pagination.
+func GetAuditLog(userID, before string) ([]*AuditEntry, error) {
+	limit := 100
+	return getAuditLog(userID, before, &limit)
+}
+
+// A nil limit returns every entry
+func getAuditLog(userID, before string, limit *int) ([]*AuditEntry, error) {
+	if before != "" && !isDigits(before) {
+		return nil, &HTTPError{
+			Msg:    "Bad 'before' ID",
+			Status: 400,
+		}
+	}
+
+	rows, err := db.Query(
+		`SELECT id, action, target, actor, ip, user_agent, diff::TEXT FROM audit_log `+
+			`WHERE user_id = $1 AND ($2 = '' OR id::BIGINT < $2::BIGINT) ORDER BY id::BIGINT DESC LIMIT $3`,
+		userID, before, limit,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		e := &AuditEntry{}
+		diff := ""
+
+		if err := rows.Scan(&e.ID, &e.Action, &e.Target, &e.Actor, &e.IP, &e.UserAgent, &diff); err != nil {
+			return nil, ErrDBHandle(err)
+		}
+
+		e.Time = snownode.SnowToTime(e.ID)
+		json.Unmarshal([]byte(diff), &e.Diff)
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
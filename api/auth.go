@@ -43,7 +43,7 @@ func NewUser(uname, password string) (id, token string, err error) {
 	if err := cleanString(&uname, 7, 33, "username"); err != nil {
 		return "", "", err
 	}
-	if err := cleanString(&password, 7, 33, "password"); err != nil {
+	if err := checkNewPassword(password, uname); err != nil {
 		return "", "", err
 	}
 
@@ -57,6 +57,16 @@ func NewUser(uname, password string) (id, token string, err error) {
 		return "", "", ErrServerErr
 	}
 
+	id, token, err = insertUser(uname, passwordHash, true)
+
+	unameLock.Free(uname)
+
+	return id, token, err
+}
+
+// Inserts a user with a new token. The username has to be locked already (see unameLock).
+// hasPassword is false for users that signed up through an identity provider, whose password hash is of a random password.
+func insertUser(uname, passwordHash string, hasPassword bool) (id, token string, err error) {
 	id = snownode.Generate()
 
 	for {
@@ -66,34 +76,30 @@ func NewUser(uname, password string) (id, token string, err error) {
 		}
 	}
 
-	_, err = db.InsertOne(`ppl`, []string{`id`, `token`, `username`, `password`}, id, token, uname, passwordHash)
+	defer unameLock.Free(token)
+
+	_, err = db.InsertOne(`ppl`, []string{`id`, `token`, `username`, `password`, `has_password`}, id, token, uname, passwordHash, hasPassword)
 	if err != nil {
 		return "", "", ErrDBHandle(err)
 	}
 
-	unameLock.Free(uname)
-	unameLock.Free(token)
-
 	return id, token, nil
 }
 
-func EditPassword(id string, oldPassword string, newPassword string) (string, error) {
-	password := ""
-
-	err := db.QueryRowID(`SELECT password FROM ppl WHERE id = $1`, id, &password)
-
-	if err != nil {
-		return "", ErrDBHandle(err)
+func EditPassword(id string, oldPassword string, newPassword string, by *Actor) (string, error) {
+	if err := checkPassword(id, &Reauth{Password: oldPassword}); err != nil {
+		return "", err
 	}
 
-	match, err := comparePasswordAndHash(password, oldPassword)
+	uname := ""
 
+	err := db.QueryRowID(`SELECT username FROM ppl WHERE id = $1`, id, &uname)
 	if err != nil {
-		return "", ErrServerErr
+		return "", ErrDBHandle(err)
 	}
 
-	if !match {
-		return "", ErrNoAuth
+	if err := checkNewPassword(newPassword, uname); err != nil {
+		return "", err
 	}
 
 	hash, err := generateFromPassword(newPassword)
@@ -117,28 +123,56 @@ func EditPassword(id string, oldPassword string, newPassword string) (string, er
 		return "", ErrDBHandle(err)
 	}
 
+	audit(id, by, AUDIT_PASSWORD_CHANGE, "", nil)
+	audit(id, by, AUDIT_TOKEN_ROTATE, "", nil)
+
 	unameLock.Free(token)
 
 	return token, nil
 }
 
-func Exchange(uname, password string) (id, token string, err error) {
+// If the user has 2FA enabled, only the challenge is returned, see ExchangeChallenge
+func Exchange(uname, password string, by *Actor) (id, token, challenge string, err error) {
 	dbPass := ""
-
-	err = db.QueryRowID(`SELECT password, id, token FROM ppl WHERE username = $1`, uname, &dbPass, &id, &token)
+	hasPassword := false
+	totpEnabled := false
+
+	err = db.QueryRowID(
+		`SELECT password, has_password, id, token, totp_enabled FROM ppl WHERE username = $1`,
+		uname,
+		&dbPass, &hasPassword, &id, &token, &totpEnabled,
+	)
 	if err != nil {
-		return "", "", ErrDBHandle(err)
+		return "", "", "", ErrDBHandle(err)
+	}
+
+	// Users that signed up through an identity provider only have a placeholder hash, which isn't a credential
+	if !hasPassword {
+		audit(id, by, AUDIT_LOGIN_FAILED, "", nil)
+		return "", "", "", ErrNoAuth
 	}
 
 	match, err := comparePasswordAndHash(password, dbPass)
 	if log.ErrorIfErr(err, "comparePasswordAndHash") {
-		return "", "", ErrServerErr
+		return "", "", "", ErrServerErr
 	}
 	if !match {
-		return "", "", ErrNoAuth
+		audit(id, by, AUDIT_LOGIN_FAILED, "", nil)
+		return "", "", "", ErrNoAuth
+	}
+
+	if err := checkNotErasing(id); err != nil {
+		return "", "", "", err
 	}
 
-	return
+	if totpEnabled {
+		challenge, err = newChallenge(id)
+		return "", "", challenge, err
+	}
+
+	audit(id, by, AUDIT_LOGIN, "", nil)
+
+	return id, token, "", nil
 }
 
 func AuthTokenToID(token string) (string, error) {
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/snownode"
+)
+
+// Account erasure: the user asks for their account to be deleted, which logs them out immediately & blocks logging in again (see checkNotErasing).
+// The erasure job (see StartEraser) then hard deletes the user, their quizzes (& everything that cascades from them) and their media.
+// The erasure itself is kept, without any user data, so that its completion can be checked using its token.
+
+type Erasure struct {
+	Token       string    `json:"token"`
+	RequestedAt time.Time `json:"requestedAt"`
+	// nil until the job is done
+	CompletedAt *time.Time `json:"completedAt"`
+	// The amount of deleted quizzes
+	Quizzes int `json:"quizzes"`
+}
+
+// Asks for the erasure of the user's account. Requires re-authenticating (see Reauth), since this can't be undone.
+// If the user already asked, the pending erasure is returned instead.
+func RequestErasure(userID string, r *Reauth) (*Erasure, error) {
+	if err := checkPassword(userID, r); err != nil {
+		return nil, err
+	}
+
+	if e, err := pendingErasure(userID); err != nil || e != nil {
+		return e, err
+	}
+
+	b, err := generateRandomBytes(24)
+	if log.ErrorIfErr(err, "generating erasure token") {
+		return nil, ErrServerErr
+	}
+
+	id := snownode.Generate()
+
+	e := &Erasure{
+		Token:       base64.RawURLEncoding.EncodeToString(b),
+		RequestedAt: snownode.SnowToTime(id),
+		CompletedAt: nil,
+		Quizzes:     0,
+	}
+
+	_, err = db.InsertOne(`erasures`, []string{`id`, `token`, `user_id`}, id, e.Token, userID)
+	if err != nil {
+		// Lost a race against another request, see sql_INDEX_erasures_pending
+		if pending, pendingErr := pendingErasure(userID); pendingErr == nil && pending != nil {
+			return pending, nil
+		}
+
+		return nil, ErrDBHandle(err)
+	}
+
+	// Log out everywhere
+	db.Exec(`UPDATE ppl SET token = $1 WHERE id = $2`, randGoodString(128), userID)
+
+	return e, nil
+}
+
+// The erasure the user asked for that isn't done yet, or nil
+func pendingErasure(userID string) (*Erasure, error) {
+	id := ""
+	e := &Erasure{}
+
+	err := db.QueryRowID(`SELECT id, token, quizzes FROM erasures WHERE user_id = $1 AND completed_at IS NULL`, userID, &id, &e.Token, &e.Quizzes)
+	if err != nil {
+		if db.NoRows(err) {
+			return nil, nil
+		}
+
+		return nil, ErrDBHandle(err)
+	}
+
+	e.RequestedAt = snownode.SnowToTime(id)
+
+	return e, nil
+}
+
+// Users that asked for their erasure can't log in anymore
+func checkNotErasing(userID string) error {
+	e, err := pendingErasure(userID)
+	if err != nil {
+		return err
+	}
+
+	if e != nil {
+		return ErrErasurePending
+	}
+
+	return nil
+}
+
+func GetErasure(token string) (*Erasure, error) {
+	id := ""
+	e := &Erasure{
+		Token: token,
+	}
+
+	err := db.QueryRowID(`SELECT id, completed_at, quizzes FROM erasures WHERE token = $1`, token, &id, &e.CompletedAt, &e.Quizzes)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	e.RequestedAt = snownode.SnowToTime(id)
+
+	return e, nil
+}
+
+// Runs pending erasures
+func StartEraser(interval time.Duration) (stop func()) {
+	return runEvery(interval, runErasures)
+}
+
+func runErasures() {
+	rows, err := db.Query(`SELECT id, user_id FROM erasures WHERE completed_at IS NULL ORDER BY id`)
+	if err != nil {
+		return
+	}
+
+	pending := [][2]string{}
+
+	for rows.Next() {
+		e := [2]string{}
+
+		if err := rows.Scan(&e[0], &e[1]); err != nil {
+			rows.Close()
+			return
+		}
+
+		pending = append(pending, e)
+	}
+
+	rows.Close()
+
+	for _, e := range pending {
+		quizzes, err := eraseUser(e[1])
+		if log.ErrorIfErr(err, "erasing user for erasure '%s'", e[0]) {
+			// Retried on the next run
+			continue
+		}
+
+		_, err = db.Exec(`UPDATE erasures SET completed_at = now(), quizzes = $1, user_id = '' WHERE id = $2`, quizzes, e[0])
+		if err == nil {
+			log.Success("Completed erasure '%s' (%d quizzes)", e[0], quizzes)
+		}
+	}
+}
+
+// Hard deletes a user, their quizzes & media. Returns the amount of deleted quizzes.
+func eraseUser(userID string) (int, error) {
+	quizIDs, err := userQuizIDs(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range quizIDs {
+		if _, err := DeleteQuiz(id, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	// The audit log is append only, apart from erasures (see sql_FUNC_audit_log_append_only)
+	if _, err := db.ExecWithSetting(`who.erasing`, `on`, `DELETE FROM audit_log WHERE user_id = $1`, userID); err != nil {
+		return 0, err
+	}
+
+	if _, err := db.Exec(`DELETE FROM ppl WHERE id = $1`, userID); err != nil {
+		return 0, err
+	}
+
+	return len(quizIDs), nil
+}
@@ -10,7 +10,9 @@ type HTTPErrorI interface {
 
 type HTTPError struct {
 	Msg    string `json:"error"`
-	Status int `json:"-"`
+	Status int    `json:"-"`
+	// Optional, for errors the frontend has to tell apart
+	Code string `json:"code,omitempty"`
 }
 
 func (e HTTPError) Error() string {
@@ -93,3 +95,137 @@ var ErrBadName = &HTTPError{
 	Msg: "Your name is not acceptable",
 	Status: 400,
 }
+
+var ErrBadStepID = &HTTPError{
+	Msg:    "Bad step ID",
+	Status: 400,
+}
+
+var ErrPlayNotDone = &HTTPError{
+	Msg:    "You haven't finished this quiz yet",
+	Status: 403,
+}
+
+var ErrBadPronouns = &HTTPError{
+	Msg:    "Bad pronouns",
+	Status: 400,
+}
+
+var ErrBadRedirect = &HTTPError{
+	Msg:    "Redirect must be a http(s) link",
+	Status: 400,
+}
+
+var ErrMediaTooBig = &HTTPError{
+	Msg:    "This file is too big",
+	Status: 413,
+}
+
+var ErrBadMedia = &HTTPError{
+	Msg:    "This file is not a supported image",
+	Status: 415,
+}
+
+var ErrInviteRevoked = &HTTPError{
+	Msg:    "This invite has been revoked",
+	Status: 410,
+}
+
+var ErrBadMessage = &HTTPError{
+	Msg:    "This message is not acceptable",
+	Status: 400,
+}
+
+var ErrEmailNotConfigured = &HTTPError{
+	Msg:    "Email notifications are not available",
+	Status: 400,
+}
+
+var ErrHashOnlyNotConfigured = &HTTPError{
+	Msg:    "Hash only quizzes are not available",
+	Status: 400,
+}
+
+var ErrHashOnlyDuplicate = &HTTPError{
+	Msg:    "Hash only quizzes can't be duplicated, since their dead names aren't stored",
+	Status: 400,
+}
+
+var ErrTOTPEnabled = &HTTPError{
+	Msg:    "2FA is already enabled",
+	Status: 409,
+}
+
+var ErrTOTPNotEnabled = &HTTPError{
+	Msg:    "2FA is not enabled",
+	Status: 400,
+}
+
+var ErrBadCode = &HTTPError{
+	Msg:    "Bad code",
+	Status: 401,
+}
+
+var ErrSecondFactorLocked = &HTTPError{
+	Msg:    "Too many wrong codes, try again later",
+	Status: 429,
+}
+
+var ErrChallengeExpired = &HTTPError{
+	Msg:    "The login challenge expired, log in again",
+	Status: 401,
+}
+
+var ErrWebAuthnNotConfigured = &HTTPError{
+	Msg:    "Passkeys are not available",
+	Status: 400,
+}
+
+var ErrBadPasskey = &HTTPError{
+	Msg:    "Bad passkey",
+	Status: 401,
+}
+
+var ErrUnknownProvider = &HTTPError{
+	Msg:    "Unknown identity provider",
+	Status: 404,
+}
+
+var ErrOIDCFailed = &HTTPError{
+	Msg:    "Couldn't sign in with the identity provider",
+	Status: 401,
+}
+
+var ErrIdentityLinked = &HTTPError{
+	Msg:    "This identity is linked to another account",
+	Status: 409,
+}
+
+var ErrLastLogin = &HTTPError{
+	Msg:    "This is the only way to log in to this account",
+	Status: 400,
+}
+
+// For users without a password, who haven't signed in again with a passkey or an identity provider (see Reauth)
+var ErrReauthRequired = &HTTPError{
+	Msg:    "Sign in again to do this",
+	Status: 401,
+	Code:   "auth.reauth_required",
+}
+
+var ErrPasswordTooWeak = &HTTPError{
+	Msg:    "The password is too easy to guess",
+	Status: 400,
+	Code:   "password.too_weak",
+}
+
+var ErrPasswordBreached = &HTTPError{
+	Msg:    "The password showed up in a data breach, pick another one",
+	Status: 400,
+	Code:   "password.breached",
+}
+
+var ErrErasurePending = &HTTPError{
+	Msg:    "This account is being erased",
+	Status: 403,
+}
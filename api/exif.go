@@ -0,0 +1,296 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Strips metadata (EXIF, XMP, text chunks, GIF comments etc.) from uploaded images, since it can contain locations, device names & so on.
+// Note: this also drops the EXIF orientation, so some photos might show up rotated.
+
+var errBadImage = errors.New("malformed image")
+
+func stripMetadata(mime string, data []byte) ([]byte, error) {
+	switch mime {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	case "image/gif":
+		return stripGIF(data)
+	}
+
+	return data, nil
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errBadImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	i := 2
+
+	for i < len(data) {
+		if data[i] != 0xFF || i+1 >= len(data) {
+			return nil, errBadImage
+		}
+
+		marker := data[i+1]
+
+		// fill bytes
+		if marker == 0xFF {
+			i++
+			continue
+		}
+
+		// standalone markers
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+
+		// EOI
+		if marker == 0xD9 {
+			out.Write(data[i : i+2])
+			break
+		}
+
+		if i+4 > len(data) {
+			return nil, errBadImage
+		}
+
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return nil, errBadImage
+		}
+
+		// SOS, the rest is image data
+		if marker == 0xDA {
+			out.Write(data[i:])
+			break
+		}
+
+		// APP1 (EXIF, XMP), APP3-APP13, APP15 & comments are dropped
+		// APP0 (JFIF), APP2 (ICC profile) & APP14 (Adobe) are needed to display the image properly
+		drop := marker == 0xE1 || (marker >= 0xE3 && marker <= 0xED) || marker == 0xEF || marker == 0xFE
+
+		if !drop {
+			out.Write(data[i:end])
+		}
+
+		i = end
+	}
+
+	return out.Bytes(), nil
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+var pngDroppedChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errBadImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+
+	i := len(pngSignature)
+
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, errBadImage
+		}
+
+		l := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+
+		// length, type, data, crc
+		end := i + 12 + l
+		if l < 0 || end > len(data) || end < i {
+			return nil, errBadImage
+		}
+
+		if !pngDroppedChunks[chunkType] {
+			out.Write(data[i:end])
+		}
+
+		i = end
+
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+const (
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errBadImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+
+	i := 12
+
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, errBadImage
+		}
+
+		fourCC := string(data[i : i+4])
+		l := int(binary.LittleEndian.Uint32(data[i+4:]))
+
+		// chunks are padded to an even size
+		end := i + 8 + l + l%2
+		if l < 0 || end > len(data) || end < i {
+			return nil, errBadImage
+		}
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= webpFlagXMP | webpFlagEXIF
+			}
+
+			out.Write(chunk)
+		default:
+			out.Write(data[i:end])
+		}
+
+		i = end
+	}
+
+	b := out.Bytes()
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+
+	return b, nil
+}
+
+// Returns the end of the data sub-blocks that start at i
+func gifSubBlocksEnd(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, errBadImage
+		}
+
+		size := int(data[i])
+		i++
+
+		if size == 0 {
+			return i, nil
+		}
+
+		i += size
+	}
+}
+
+// Application extensions that are needed for animations to loop
+var gifKeptApplications = map[string]bool{
+	"NETSCAPE2.0": true,
+	"ANIMEXTS1.0": true,
+}
+
+// Drops comment extensions & application extensions (XMP etc.), other than the looping ones
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return nil, errBadImage
+	}
+
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	if i > len(data) {
+		return nil, errBadImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:i])
+
+	for {
+		if i >= len(data) {
+			return nil, errBadImage
+		}
+
+		switch data[i] {
+		case 0x3B:
+			out.WriteByte(0x3B)
+			return out.Bytes(), nil
+		case 0x21:
+			if i+2 > len(data) {
+				return nil, errBadImage
+			}
+
+			end, err := gifSubBlocksEnd(data, i+2)
+			if err != nil || end > len(data) {
+				return nil, errBadImage
+			}
+
+			label := data[i+1]
+			drop := label == 0xFE
+
+			if label == 0xFF {
+				id := ""
+				if i+3+11 <= end && data[i+2] == 11 {
+					id = string(data[i+3 : i+3+11])
+				}
+
+				drop = !gifKeptApplications[id]
+			}
+
+			if !drop {
+				out.Write(data[i:end])
+			}
+
+			i = end
+		case 0x2C:
+			// descriptor, local color table, LZW code size, image data
+			if i+10 > len(data) {
+				return nil, errBadImage
+			}
+
+			start := i
+			flags := data[i+9]
+			i += 10
+
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+
+			end, err := gifSubBlocksEnd(data, i+1)
+			if err != nil || end > len(data) {
+				return nil, errBadImage
+			}
+
+			out.Write(data[start:end])
+			i = end
+		default:
+			return nil, errBadImage
+		}
+	}
+}
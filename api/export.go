@@ -0,0 +1,256 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/snownode"
+)
+
+// Everything we store about a user, for them to take with them. Password hashes & tokens are not included.
+
+type ExportUser struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ExportAttempt struct {
+	Question string `json:"question"`
+	Section  int    `json:"section"`
+	Wrong    int    `json:"wrong"`
+}
+
+type ExportPlay struct {
+	ID        string           `json:"id"`
+	StartedAt time.Time        `json:"startedAt"`
+	Completed bool             `json:"completed"`
+	Preview   bool             `json:"preview"`
+	Invite    string           `json:"invite,omitempty"`
+	Attempts  []*ExportAttempt `json:"attempts"`
+}
+
+type ExportQuiz struct {
+	Quiz          *RevealedQuiz    `json:"quiz"`
+	Questions     [3]*FullQuestion `json:"questions"`
+	Notifications *NotifySettings  `json:"notifications"`
+	Invites       []*Invite        `json:"invites"`
+	Stats         *QuizStats       `json:"stats"`
+	Plays         []*ExportPlay    `json:"plays"`
+	Messages      []*Message       `json:"messages"`
+	Media         []*Media         `json:"media"`
+}
+
+type Export struct {
+	ExportedAt time.Time     `json:"exportedAt"`
+	User       *ExportUser   `json:"user"`
+	Quizzes    []*ExportQuiz `json:"quizzes"`
+	Passkeys   []*Passkey    `json:"passkeys"`
+	Identities []*Identity   `json:"identities"`
+	Audit      []*AuditEntry `json:"audit"`
+}
+
+// Only for the user themselves!
+func ExportUserData(userID string) (*Export, error) {
+	e := &Export{
+		ExportedAt: time.Now(),
+		User: &ExportUser{
+			ID:        userID,
+			CreatedAt: snownode.SnowToTime(userID),
+		},
+		Quizzes: []*ExportQuiz{},
+	}
+
+	err := db.QueryRowID(`SELECT username FROM ppl WHERE id = $1`, userID, &e.User.Username)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	quizIDs, err := userQuizIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range quizIDs {
+		q, err := exportQuiz(id)
+		if err != nil {
+			return nil, err
+		}
+
+		e.Quizzes = append(e.Quizzes, q)
+	}
+
+	e.Passkeys, err = GetPasskeys(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Identities, err = GetIdentities(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Audit, err = getAuditLog(userID, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func userQuizIDs(userID string) ([]string, error) {
+	rows, err := db.Query(`SELECT id FROM quiz WHERE author = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	defer rows.Close()
+
+	ids := []string{}
+
+	for rows.Next() {
+		id := ""
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, ErrDBHandle(err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func exportQuiz(quizID string) (*ExportQuiz, error) {
+	var err error
+
+	e := &ExportQuiz{}
+
+	if e.Quiz, err = RevealQuiz(GetQuiz(quizID)); err != nil {
+		return nil, err
+	}
+	if e.Questions, err = GetQuestions(quizID); err != nil {
+		return nil, err
+	}
+	if e.Notifications, err = GetNotifySettings(quizID); err != nil {
+		return nil, err
+	}
+	if e.Invites, err = GetInvites(quizID); err != nil {
+		return nil, err
+	}
+	if e.Stats, err = GetQuizStats(quizID); err != nil {
+		return nil, err
+	}
+	if e.Plays, err = exportPlays(quizID); err != nil {
+		return nil, err
+	}
+	if e.Messages, err = GetMessages(quizID); err != nil {
+		return nil, err
+	}
+	if e.Media, err = GetQuizMedia(quizID); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func exportPlays(quizID string) ([]*ExportPlay, error) {
+	rows, err := db.Query(
+		`SELECT plays.id, plays.completed, plays.is_preview, COALESCE(invites.name, '') FROM plays LEFT JOIN invites ON plays.invite = invites.id WHERE plays.quiz = $1 ORDER BY plays.id`,
+		quizID,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	plays := []*ExportPlay{}
+	byID := map[string]*ExportPlay{}
+
+	for rows.Next() {
+		p := &ExportPlay{
+			Attempts: []*ExportAttempt{},
+		}
+
+		if err := rows.Scan(&p.ID, &p.Completed, &p.Preview, &p.Invite); err != nil {
+			rows.Close()
+			return nil, ErrDBHandle(err)
+		}
+
+		p.StartedAt = snownode.SnowToTime(p.ID)
+
+		plays = append(plays, p)
+		byID[p.ID] = p
+	}
+
+	rows.Close()
+
+	rows, err = db.Query(`SELECT attempts.play, attempts.question, attempts.section, attempts.wrong FROM attempts JOIN plays ON attempts.play = plays.id WHERE plays.quiz = $1`, quizID)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		play := ""
+		a := &ExportAttempt{}
+
+		if err := rows.Scan(&play, &a.Question, &a.Section, &a.Wrong); err != nil {
+			return nil, ErrDBHandle(err)
+		}
+
+		if p := byID[play]; p != nil {
+			p.Attempts = append(p.Attempts, a)
+		}
+	}
+
+	return plays, nil
+}
+
+var mediaExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Writes the export as a ZIP: export.json, plus the files of all media under media/{quizID}/{mediaID}.{ext}
+func (e *Export) WriteZip(w io.Writer) error {
+	z := zip.NewWriter(w)
+
+	f, err := z.Create("export.json")
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+
+	if err := enc.Encode(e); err != nil {
+		return err
+	}
+
+	for _, q := range e.Quizzes {
+		for _, m := range q.Media {
+			_, data, err := GetMedia(m.Quiz, m.ID)
+			if err != nil {
+				// The file is gone, nothing to export
+				continue
+			}
+
+			f, err := z.Create("media/" + m.Quiz + "/" + m.ID + mediaExtensions[m.MIME])
+			if err != nil {
+				return err
+			}
+
+			if _, err := f.Write(data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return z.Close()
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/base64"
+	"os"
+	"strings"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/snownode"
+)
+
+// Invites are named links to a quiz, one per friend. Play sessions started through an invite are linked to it,
+// so that the author can see who opened & completed the quiz (see stats.go).
+// Revoked invites can't be used to start or continue playing.
+
+type Invite struct {
+	ID      string `json:"id"`
+	Quiz    string `json:"quiz"`
+	Name    string `json:"name"`
+	Token   string `json:"token"`
+	Revoked bool   `json:"revoked"`
+	// Only set if FRONTEND_URL is configured
+	URL string `json:"url,omitempty"`
+}
+
+// The share URL of an invite, {FRONTEND_URL}/{quizID}?invite={token}
+func inviteURL(quizID, token string) string {
+	base := strings.TrimSuffix(strings.TrimSpace(os.Getenv("FRONTEND_URL")), "/")
+	if base == "" {
+		return ""
+	}
+
+	return base + "/" + quizID + "?invite=" + token
+}
+
+func NewInvite(quizID, name string) (*Invite, error) {
+	if err := cleanString(&name, 1, 33, "invite name"); err != nil {
+		return nil, err
+	}
+
+	count := 0
+
+	err := db.QueryRowID(`SELECT COUNT(*) FROM invites WHERE quiz = $1`, quizID, &count)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	if count >= 100 {
+		return nil, &HTTPError{
+			Msg:    "Too many invites for this quiz",
+			Status: 400,
+		}
+	}
+
+	b, err := generateRandomBytes(24)
+	if log.ErrorIfErr(err, "generating invite token") {
+		return nil, ErrServerErr
+	}
+
+	inv := &Invite{
+		ID:      snownode.Generate(),
+		Quiz:    quizID,
+		Name:    name,
+		Token:   base64.RawURLEncoding.EncodeToString(b),
+		Revoked: false,
+	}
+	inv.URL = inviteURL(quizID, inv.Token)
+
+	_, err = db.InsertOne(`invites`, []string{`id`, `quiz`, `name`, `token`}, inv.ID, inv.Quiz, inv.Name, inv.Token)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	return inv, nil
+}
+
+// Admin only!
+func GetInvites(quizID string) ([]*Invite, error) {
+	rows, err := db.Query(`SELECT id, name, token, revoked FROM invites WHERE quiz = $1 ORDER BY id`, quizID)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	defer rows.Close()
+
+	invites := []*Invite{}
+
+	for rows.Next() {
+		inv := &Invite{
+			Quiz: quizID,
+		}
+
+		if err := rows.Scan(&inv.ID, &inv.Name, &inv.Token, &inv.Revoked); err != nil {
+			return nil, ErrDBHandle(err)
+		}
+
+		inv.URL = inviteURL(quizID, inv.Token)
+
+		invites = append(invites, inv)
+	}
+
+	return invites, nil
+}
+
+// Admin only!
+func RevokeInvite(quizID, inviteID string) (*Invite, error) {
+	inv := &Invite{
+		ID:      inviteID,
+		Quiz:    quizID,
+		Revoked: true,
+	}
+
+	err := db.QueryRow(
+		`UPDATE invites SET revoked = true WHERE id = $1 AND quiz = $2 RETURNING name, token`,
+		[]any{inviteID, quizID},
+		&inv.Name, &inv.Token,
+	)
+
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	inv.URL = inviteURL(quizID, inv.Token)
+
+	return inv, nil
+}
+
+// Returns the ID of a usable invite of the quiz, by its token
+func resolveInvite(quizID, token string) (string, error) {
+	id := ""
+	revoked := false
+
+	err := db.QueryRow(`SELECT id, revoked FROM invites WHERE token = $1 AND quiz = $2`, []any{token, quizID}, &id, &revoked)
+	if err != nil {
+		if db.NoRows(err) {
+			return "", ErrNotFound
+		}
+
+		return "", ErrServerErr
+	}
+
+	if revoked {
+		return "", ErrInviteRevoked
+	}
+
+	return id, nil
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"time"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/db"
+)
+
+// Background jobs. Each Start* function runs its job in a new goroutine, every interval, until the returned stop func is called.
+
+func runEvery(interval time.Duration, job func()) (stop func()) {
+	done := make(chan bool)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		job()
+
+		for {
+			select {
+			case <-ticker.C:
+				job()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// Archives published quizzes whose link has expired
+func StartArchiver(interval time.Duration) (stop func()) {
+	return runEvery(interval, archiveExpiredQuizzes)
+}
+
+func archiveExpiredQuizzes() {
+	tag, err := db.Exec(`UPDATE quiz SET status = $1 WHERE status = $2 AND expires_at <= now()`, QUIZ_ARCHIVED, QUIZ_PUBLISHED)
+	if err != nil {
+		return
+	}
+
+	if tag.RowsAffected() != 0 {
+		log.Debug("Archived %d expired quizzes", tag.RowsAffected())
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"testing"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/snownode"
+	"github.com/shadiestgoat/who/vault"
+)
+
+// Tests that use the database need a throwaway one (TEST_DB_URI), the tables are created like on startup. Without it they are skipped.
+
+var dbReady = false
+
+func TestMain(m *testing.M) {
+	log.Init(log.NewLoggerPrint())
+
+	if uri := os.Getenv("TEST_DB_URI"); uri != "" {
+		os.Setenv("DB_URI", uri)
+		os.Setenv("ENCRYPTION_KEYS", "test:"+randomKey())
+		os.Setenv("ENCRYPTION_KEY_ID", "")
+		os.Setenv("ANSWER_DIGEST_KEY", randomKey())
+
+		vault.Init()
+		db.Init()
+
+		dbReady = true
+	}
+
+	os.Exit(m.Run())
+}
+
+func randomKey() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func needDB(t *testing.T) {
+	t.Helper()
+
+	if !dbReady {
+		t.Skip("TEST_DB_URI is not set")
+	}
+}
+
+// Returns the ID & password of a new user
+func newTestUser(t *testing.T) (string, string) {
+	t.Helper()
+
+	password := randomKey()
+
+	id, _, err := NewUser("test_"+snownode.Generate(), password)
+	if err != nil {
+		t.Fatalf("creating a user: %v", err)
+	}
+
+	return id, password
+}
+
+func assertHTTPError(t *testing.T, what string, err error, expected *HTTPError) {
+	t.Helper()
+
+	if err != expected {
+		t.Errorf("%s: expected '%s', got %v", what, expected.Msg, err)
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// How submitted answers are compared to the stored ones.
+// Answers are always NFKC normalized, lower cased & have their whitespace collapsed.
+type MatchPolicy struct {
+	// "pízza" -> "pizza"
+	FoldAccents bool `json:"foldAccents"`
+	// "pizza!" -> "pizza"
+	StripPunctuation bool `json:"stripPunctuation"`
+	// "the pizza" -> "pizza"
+	IgnoreArticles bool `json:"ignoreArticles"`
+	// Max Levenshtein distance between the answers, 0-3.
+	// Short answers get less tolerance, so that ie. "yes" doesn't match "no"
+	Tolerance int `json:"tolerance"`
+}
+
+func defaultMatchPolicy() *MatchPolicy {
+	return &MatchPolicy{
+		FoldAccents:      true,
+		StripPunctuation: true,
+		IgnoreArticles:   false,
+		Tolerance:        0,
+	}
+}
+
+// Used for the special questions, where the answers are names
+var specialMatchPolicy = defaultMatchPolicy()
+
+func (p *MatchPolicy) Sanitize() error {
+	if p.Tolerance < 0 || p.Tolerance > 3 {
+		return &HTTPError{
+			Msg:    "Match tolerance has to be 0-3",
+			Status: 400,
+		}
+	}
+
+	return nil
+}
+
+var articles = []string{"the ", "a ", "an "}
+
+func (p *MatchPolicy) Normalize(s string) string {
+	s = norm.NFKC.String(s)
+
+	if p.FoldAccents {
+		folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), s)
+		if err == nil {
+			s = folded
+		}
+	}
+
+	if p.StripPunctuation {
+		s = strings.Map(func(r rune) rune {
+			if unicode.IsPunct(r) {
+				return -1
+			}
+
+			return r
+		}, s)
+	}
+
+	s = strings.Join(strings.Fields(strings.ToLower(s)), " ")
+
+	if p.IgnoreArticles {
+		for _, a := range articles {
+			if strings.HasPrefix(s, a) {
+				s = s[len(a):]
+				break
+			}
+		}
+	}
+
+	return s
+}
+
+// Both answers are assumed to be normalized
+func (p *MatchPolicy) Matches(stored, answer string) bool {
+	if stored == answer {
+		return true
+	}
+
+	if p.Tolerance == 0 {
+		return false
+	}
+
+	storedRunes, answerRunes := []rune(stored), []rune(answer)
+
+	tolerance := p.Tolerance
+	if maxTolerance := len(storedRunes) / 3; tolerance > maxTolerance {
+		tolerance = maxTolerance
+	}
+
+	return levenshtein(storedRunes, answerRunes) <= tolerance
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func minInt(v int, others ...int) int {
+	for _, o := range others {
+		if o < v {
+			v = o
+		}
+	}
+
+	return v
+}
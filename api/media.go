@@ -0,0 +1,226 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/blobs"
+	"github.com/shadiestgoat/who/config"
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/snownode"
+)
+
+// Media (images) that can be attached to questions. The files themselves are kept in blobs.Store, under the media ID.
+// Media belongs to a quiz, and is only served to its author & to play sessions of the quiz.
+
+type Media struct {
+	ID   string `json:"id"`
+	Quiz string `json:"quiz"`
+	MIME string `json:"mime"`
+	Size int    `json:"size"`
+}
+
+var allowedMediaTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+func UploadMedia(quizID string, data []byte) (*Media, error) {
+	if len(data) > config.MEDIA_MAX_SIZE {
+		return nil, ErrMediaTooBig
+	}
+	if len(data) == 0 {
+		return nil, ErrBadMedia
+	}
+
+	mime := strings.TrimSpace(strings.Split(http.DetectContentType(data), ";")[0])
+	if !allowedMediaTypes[mime] {
+		return nil, ErrBadMedia
+	}
+
+	count := 0
+
+	err := db.QueryRowID(`SELECT COUNT(*) FROM media WHERE quiz = $1`, quizID, &count)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	if count >= config.MEDIA_MAX_PER_QUIZ {
+		return nil, &HTTPError{
+			Msg:    "Too much media for this quiz",
+			Status: 400,
+		}
+	}
+
+	data, err = stripMetadata(mime, data)
+	if err != nil {
+		return nil, ErrBadMedia
+	}
+
+	m := &Media{
+		ID:   snownode.Generate(),
+		Quiz: quizID,
+		MIME: mime,
+		Size: len(data),
+	}
+
+	if log.ErrorIfErr(blobs.Store.Put(m.ID, data), "storing media '%s'", m.ID) {
+		return nil, ErrServerErr
+	}
+
+	_, err = db.InsertOne(`media`, []string{`id`, `quiz`, `mime`, `size`}, m.ID, m.Quiz, m.MIME, m.Size)
+	if err != nil {
+		blobs.Store.Delete(m.ID)
+		return nil, ErrDBHandle(err)
+	}
+
+	return m, nil
+}
+
+func getMediaInfo(quizID, mediaID string) (*Media, error) {
+	m := &Media{
+		ID:   mediaID,
+		Quiz: quizID,
+		MIME: "",
+		Size: 0,
+	}
+
+	err := db.QueryRow(`SELECT mime, size FROM media WHERE id = $1 AND quiz = $2`, []any{mediaID, quizID}, &m.MIME, &m.Size)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	return m, nil
+}
+
+// Admin only!
+func GetMedia(quizID, mediaID string) (*Media, []byte, error) {
+	m, err := getMediaInfo(quizID, mediaID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := blobs.Store.Get(m.ID)
+	if err != nil {
+		if err == blobs.ErrNotFound {
+			return nil, nil, ErrNotFound
+		}
+
+		log.Error("Couldn't fetch media '%s': %v", m.ID, err)
+		return nil, nil, ErrServerErr
+	}
+
+	return m, data, nil
+}
+
+// Get media as a viewer, only works for play sessions of the quiz
+func GetMediaForPlay(quizID, play, mediaID string) (*Media, []byte, error) {
+	if err := checkPlayable(quizID, play); err != nil {
+		return nil, nil, err
+	}
+
+	if !isPlayOf(play, quizID) {
+		return nil, nil, ErrNoAuth
+	}
+
+	return GetMedia(quizID, mediaID)
+}
+
+// Admin only!
+func GetQuizMedia(quizID string) ([]*Media, error) {
+	rows, err := db.Query(`SELECT id, mime, size FROM media WHERE quiz = $1`, quizID)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	defer rows.Close()
+
+	media := []*Media{}
+
+	for rows.Next() {
+		m := &Media{
+			Quiz: quizID,
+		}
+
+		if err := rows.Scan(&m.ID, &m.MIME, &m.Size); err != nil {
+			return nil, ErrDBHandle(err)
+		}
+
+		media = append(media, m)
+	}
+
+	return media, nil
+}
+
+// Admin only! Questions using this media lose it.
+func DeleteMedia(quizID, mediaID string) (*Media, error) {
+	m, err := getMediaInfo(quizID, mediaID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`UPDATE questions SET media = '' WHERE media = $1`, m.ID)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	_, err = db.Exec(`DELETE FROM media WHERE id = $1`, m.ID)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	log.ErrorIfErr(blobs.Store.Delete(m.ID), "deleting media '%s'", m.ID)
+
+	return m, nil
+}
+
+// Copies media to another quiz, under a new ID
+func copyMedia(m *Media, toQuiz, newID string) error {
+	data, err := blobs.Store.Get(m.ID)
+	if log.ErrorIfErr(err, "fetching media '%s'", m.ID) {
+		return ErrServerErr
+	}
+
+	if log.ErrorIfErr(blobs.Store.Put(newID, data), "storing media '%s'", newID) {
+		return ErrServerErr
+	}
+
+	_, err = db.InsertOne(`media`, []string{`id`, `quiz`, `mime`, `size`}, newID, toQuiz, m.MIME, m.Size)
+	if err != nil {
+		blobs.Store.Delete(newID)
+		return ErrDBHandle(err)
+	}
+
+	return nil
+}
+
+// Deletes the stored files of all media of a quiz. The rows themselves are deleted together with the quiz.
+func deleteQuizMediaBlobs(quizID string) {
+	media, err := GetQuizMedia(quizID)
+	if err != nil {
+		return
+	}
+
+	for _, m := range media {
+		log.ErrorIfErr(blobs.Store.Delete(m.ID), "deleting media '%s'", m.ID)
+	}
+}
+
+// Returns an error if the media can't be used in questions of the quiz
+func checkMedia(quizID, mediaID string) error {
+	if mediaID == "" {
+		return nil
+	}
+
+	if !db.Exists(`media`, `id = $1 AND quiz = $2`, mediaID, quizID) {
+		return &HTTPError{
+			Msg:    "Unknown media",
+			Status: 400,
+		}
+	}
+
+	return nil
+}
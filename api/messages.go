@@ -0,0 +1,149 @@
+package api
+
+import (
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/snownode"
+)
+
+// Once viewers complete a quiz, they can send a few messages back to the author.
+// Messages are stored with the play session, and only the author can read them.
+
+type Message struct {
+	ID      string    `json:"id"`
+	Play    string    `json:"-"`
+	Name    string    `json:"name,omitempty"`
+	Content string    `json:"content"`
+	SentAt  time.Time `json:"sentAt"`
+	// The name of the invite the play session was started with, if any
+	Invite string `json:"invite,omitempty"`
+}
+
+const (
+	messageMaxLen     = 1000
+	messageMaxLines   = 20
+	messageMaxPerPlay = 3
+)
+
+// Words that aren't allowed in messages, from MESSAGE_BLOCKLIST (comma separated)
+func messageBlocklist() []string {
+	words := []string{}
+
+	for _, w := range strings.Split(os.Getenv("MESSAGE_BLOCKLIST"), ",") {
+		w = specialMatchPolicy.Normalize(w)
+		if w != "" {
+			words = append(words, w)
+		}
+	}
+
+	return words
+}
+
+func (m *Message) Sanitize() error {
+	if err := cleanString(&m.Name, -1, 33, "message name"); err != nil {
+		return err
+	}
+	if err := cleanString(&m.Content, 1, messageMaxLen, "message"); err != nil {
+		return err
+	}
+
+	if strings.Count(m.Content, "\n") >= messageMaxLines {
+		return &HTTPError{
+			Msg:    "Too many lines in the message",
+			Status: 400,
+		}
+	}
+
+	for _, r := range m.Name + m.Content {
+		if unicode.IsControl(r) && r != '\n' {
+			return ErrBadMessage
+		}
+	}
+
+	lower := strings.ToLower(m.Content)
+	if strings.Contains(lower, "http://") || strings.Contains(lower, "https://") || strings.Contains(lower, "www.") {
+		return &HTTPError{
+			Msg:    "Links are not allowed in messages",
+			Status: 400,
+		}
+	}
+
+	normalized := " " + specialMatchPolicy.Normalize(m.Name+" "+m.Content) + " "
+	for _, w := range messageBlocklist() {
+		if strings.Contains(normalized, " "+w+" ") {
+			return ErrBadMessage
+		}
+	}
+
+	return nil
+}
+
+// Only for completed play sessions
+func NewMessage(quizID, play string, m *Message) (*Message, error) {
+	if err := checkPlayCompleted(play, quizID); err != nil {
+		return nil, err
+	}
+
+	if err := m.Sanitize(); err != nil {
+		return nil, err
+	}
+
+	m.ID = snownode.Generate()
+	m.Play = play
+	m.SentAt = snownode.SnowToTime(m.ID)
+	m.Invite = ""
+
+	// The play is locked, so concurrent messages of the same play can't all pass the count
+	tag, err := db.ExecWithLock(
+		`SELECT 1 FROM plays WHERE id = $1 FOR UPDATE`, play,
+		`INSERT INTO messages (id, quiz, play, name, content) SELECT $1, $2, $3, $4, $5 WHERE (SELECT COUNT(*) FROM messages WHERE play = $3) < $6`,
+		m.ID, quizID, m.Play, m.Name, m.Content, messageMaxPerPlay,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil, &HTTPError{
+			Msg:    "You already sent enough messages",
+			Status: 429,
+		}
+	}
+
+	return m, nil
+}
+
+// Admin only!
+func GetMessages(quizID string) ([]*Message, error) {
+	rows, err := db.Query(
+		`SELECT messages.id, COALESCE(messages.play, ''), messages.name, messages.content, COALESCE(invites.name, '') FROM messages `+
+			`LEFT JOIN plays ON messages.play = plays.id LEFT JOIN invites ON plays.invite = invites.id `+
+			`WHERE messages.quiz = $1 ORDER BY messages.id`,
+		quizID,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	defer rows.Close()
+
+	messages := []*Message{}
+
+	for rows.Next() {
+		m := &Message{}
+
+		if err := rows.Scan(&m.ID, &m.Play, &m.Name, &m.Content, &m.Invite); err != nil {
+			return nil, ErrDBHandle(err)
+		}
+
+		m.SentAt = snownode.SnowToTime(m.ID)
+
+		messages = append(messages, m)
+	}
+
+	return messages, nil
+}
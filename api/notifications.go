@@ -0,0 +1,262 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/notify"
+)
+
+// Authors can be notified when someone completes their quiz, through a webhook and/or an email.
+// Notifications are configured per quiz. Author previews don't trigger notifications.
+// New emails only get notifications once they're confirmed, through a link sent to them (see ConfirmNotifyEmail).
+
+type NotifySettings struct {
+	// Empty to disable
+	Webhook string `json:"webhook"`
+	// Read only. Generated when the webhook is changed, used to sign the payloads (see notify.Webhook)
+	WebhookSecret string `json:"webhookSecret"`
+	// Empty to disable. Only available if SMTP is configured.
+	// Setting a new email doesn't change this until it's confirmed, it's in EmailPending until then.
+	Email string `json:"email"`
+	// Read only. The email waiting to be confirmed, if any
+	EmailPending string `json:"emailPending"`
+}
+
+type EmailConfirmation struct {
+	Quiz  string `json:"quiz"`
+	Email string `json:"email"`
+}
+
+const emailConfirmationTTL = 24 * time.Hour
+
+// Admin only!
+func GetNotifySettings(quizID string) (*NotifySettings, error) {
+	s := &NotifySettings{}
+
+	err := db.QueryRowID(
+		`SELECT notify_webhook, notify_secret, notify_email, notify_email_pending FROM quiz WHERE id = $1`,
+		quizID,
+		&s.Webhook, &s.WebhookSecret, &s.Email, &s.EmailPending,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	return s, nil
+}
+
+// Admin only! Overrides everything, apart from the webhook secret
+func SetNotifySettings(quizID string, s *NotifySettings) (*NotifySettings, error) {
+	errCombo := []error{
+		cleanString(&s.Webhook, -1, 257, "webhook"),
+		cleanString(&s.Email, -1, 257, "email"),
+	}
+
+	if err := newHTTPErrorStack(errCombo); err != nil {
+		return nil, err
+	}
+
+	if err := verifyRedirect(&s.Webhook); err != nil || !webhookHostAllowed(s.Webhook) {
+		return nil, &HTTPError{
+			Msg:    "Bad webhook URL",
+			Status: 400,
+		}
+	}
+
+	if s.Email != "" {
+		if notify.SMTPConfig == nil {
+			return nil, ErrEmailNotConfigured
+		}
+
+		addr, err := mail.ParseAddress(s.Email)
+		if err != nil || addr.Name != "" || addr.Address != s.Email {
+			return nil, &HTTPError{
+				Msg:    "Bad email",
+				Status: 400,
+			}
+		}
+	}
+
+	old, err := GetNotifySettings(quizID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.WebhookSecret = old.WebhookSecret
+
+	if s.Webhook == "" {
+		s.WebhookSecret = ""
+	} else if s.Webhook != old.Webhook || s.WebhookSecret == "" {
+		b, err := generateRandomBytes(32)
+		if log.ErrorIfErr(err, "generating webhook secret") {
+			return nil, ErrServerErr
+		}
+
+		s.WebhookSecret = hex.EncodeToString(b)
+	}
+
+	s.EmailPending = ""
+	confirmToken := ""
+
+	if s.Email != "" && s.Email != old.Email {
+		s.EmailPending = s.Email
+		s.Email = old.Email
+
+		b, err := generateRandomBytes(32)
+		if log.ErrorIfErr(err, "generating email confirmation token") {
+			return nil, ErrServerErr
+		}
+
+		confirmToken = hex.EncodeToString(b)
+	}
+
+	_, err = db.Exec(
+		`UPDATE quiz SET notify_webhook = $1, notify_secret = $2, notify_email = $3, notify_email_pending = $4, notify_email_token = $5, notify_email_expires = $6 WHERE id = $7`,
+		s.Webhook, s.WebhookSecret, s.Email, s.EmailPending, confirmToken, time.Now().Add(emailConfirmationTTL), quizID,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	if confirmToken != "" {
+		go sendEmailConfirmation(s.EmailPending, confirmToken)
+	}
+
+	return s, nil
+}
+
+// The link in confirmation emails, {FRONTEND_URL}/confirm-email/{token}
+func emailConfirmationURL(token string) string {
+	base := strings.TrimSuffix(strings.TrimSpace(os.Getenv("FRONTEND_URL")), "/")
+	if base == "" {
+		return "Confirmation code: " + token
+	}
+
+	return base + "/confirm-email/" + token
+}
+
+func sendEmailConfirmation(email, token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err := notify.SMTPConfig.WithTo(email).Send(
+		ctx,
+		"Confirm your email",
+		"Someone asked to get notifications about their quiz at this email. If it was you, confirm it here:\r\n\r\n"+
+			emailConfirmationURL(token)+"\r\n\r\nIf it wasn't you, you can ignore this email.",
+	)
+
+	log.ErrorIfErr(err, "sending an email confirmation")
+}
+
+// Enables the pending notification email of a quiz. Anyone with the token can do this, since it's only sent to that email.
+func ConfirmNotifyEmail(token string) (*EmailConfirmation, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+
+	c := &EmailConfirmation{}
+
+	err := db.QueryRowID(
+		`UPDATE quiz SET notify_email = notify_email_pending, notify_email_pending = '', notify_email_token = '' `+
+			`WHERE notify_email_token = $1 AND notify_email_pending != '' AND notify_email_expires > now() RETURNING id, notify_email`,
+		token,
+		&c.Quiz, &c.Email,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	return c, nil
+}
+
+// Catches obviously internal webhooks early. Hostnames are only checked when the webhook is sent, see notify.IsPublicAddr
+func webhookHostAllowed(webhook string) bool {
+	if webhook == "" {
+		return true
+	}
+
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return notify.IsPublicAddr(ip)
+	}
+
+	return true
+}
+
+func (s *NotifySettings) notifier() notify.Notifier {
+	n := notify.Multi{}
+
+	if s.Webhook != "" {
+		n = append(n, &notify.Webhook{
+			URL:    s.Webhook,
+			Secret: s.WebhookSecret,
+		})
+	}
+
+	if s.Email != "" && notify.SMTPConfig != nil {
+		n = append(n, notify.SMTPConfig.WithTo(s.Email))
+	}
+
+	if len(n) == 0 {
+		return nil
+	}
+
+	return n
+}
+
+// Should be called in a new goroutine, the notifiers can take a while (retries)
+func notifyCompleted(play, quizID string) {
+	isPreview := false
+	invite := ""
+
+	err := db.QueryRowID(
+		`SELECT plays.is_preview, COALESCE(invites.name, '') FROM plays LEFT JOIN invites ON plays.invite = invites.id WHERE plays.id = $1`,
+		play,
+		&isPreview, &invite,
+	)
+	if err != nil || isPreview {
+		return
+	}
+
+	s, err := GetNotifySettings(quizID)
+	if err != nil {
+		return
+	}
+
+	n := s.notifier()
+	if n == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	err = n.Notify(ctx, &notify.Event{
+		Type:   notify.EVENT_COMPLETED,
+		Quiz:   quizID,
+		Play:   play,
+		Invite: invite,
+		Time:   time.Now(),
+	})
+
+	log.ErrorIfErr(err, "notifying the author of quiz '%s'", quizID)
+}
@@ -0,0 +1,393 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/oidc"
+)
+
+// Logging in through identity providers (OpenID Connect, see the oidc package).
+//
+// StartOIDC gives the URL to send the user to. The provider then sends them to its redirect URL (normally a frontend page),
+// which passes the code & state on to FinishOIDC.
+// Identities that aren't linked to anyone yet sign up a new user, unless a logged in user started the flow to link it to themselves.
+// Identities are never linked by email, since providers don't all verify them.
+// A logged in user can also sign in again with an identity they linked, to re-authenticate without a password (see Reauth).
+// The provider then has to make them sign in again, instead of reusing their session there (see oidc.Session.MaxAge).
+//
+// The flow is bound to the browser that started it: StartOIDC gives a binding, which the browser keeps (see router) & passes on to FinishOIDC.
+// Only its hash is stored with the state. Otherwise, someone could get a victim to finish a flow they started, logging the victim in as them.
+
+type Identity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type OIDCStart struct {
+	URL string `json:"url"`
+	// Has to be passed on to FinishOIDC by the same browser. Not part of the response, the router keeps it in a cookie.
+	Binding string `json:"-"`
+}
+
+// Either a login (see Exchange), or the identity that a logged in user linked
+type OIDCFinish struct {
+	ID        string
+	Token     string
+	Challenge string
+
+	// Only set when linking, in which case the rest is empty
+	Linked *Identity
+	// Only set when re-authenticating, in which case the rest is empty
+	Reauth *ReauthGrant
+}
+
+const (
+	oidcTimeout = 10 * time.Second
+	// How long the user has to finish signing in at the provider
+	OIDCStateTTL = challengeTTL
+)
+
+func getProvider(name string) (*oidc.Provider, error) {
+	p, err := oidc.Get(name)
+	if err != nil {
+		return nil, ErrUnknownProvider
+	}
+
+	return p, nil
+}
+
+// userID is the user linking the identity, or an empty string when logging in.
+// If reauth is true, userID is re-authenticating with an identity they already linked instead.
+func StartOIDC(provider, userID string, reauth bool) (*OIDCStart, error) {
+	if reauth && userID == "" {
+		return nil, ErrNoAuth
+	}
+
+	p, err := getProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := oidc.NewSession()
+	if log.ErrorIfErr(err, "creating oidc session") {
+		return nil, ErrServerErr
+	}
+
+	if reauth {
+		s.MaxAge = reauthMaxAge
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcTimeout)
+	defer cancel()
+
+	u, err := p.AuthURL(ctx, s)
+	if log.ErrorIfErr(err, "creating the auth URL of '%s'", provider) {
+		return nil, ErrServerErr
+	}
+
+	b, err := generateRandomBytes(32)
+	if log.ErrorIfErr(err, "generating oidc binding") {
+		return nil, ErrServerErr
+	}
+
+	binding := base64.RawURLEncoding.EncodeToString(b)
+
+	_, err = db.InsertOne(
+		`oidc_states`,
+		[]string{`state`, `provider`, `nonce`, `verifier`, `binding_hash`, `user_id`, `reauth`, `expires_at`},
+		s.State, provider, s.Nonce, s.Verifier, oidcBindingHash(binding), userID, reauth, time.Now().Add(OIDCStateTTL),
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	return &OIDCStart{
+		URL:     u,
+		Binding: binding,
+	}, nil
+}
+
+func oidcBindingHash(binding string) string {
+	h := sha256.Sum256([]byte(binding))
+	return hex.EncodeToString(h[:])
+}
+
+// providerErr is the error query param the provider sends back, if any. binding is what StartOIDC gave the browser.
+// The callback isn't authenticated, so linking only ever returns the identity, never a token.
+func FinishOIDC(provider, state, binding, code, providerErr string, by *Actor) (*OIDCFinish, error) {
+	p, err := getProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &oidc.Session{
+		State: state,
+	}
+	linkUser := ""
+	reauth := false
+
+	// A state from another browser is left alone, so that it can't be used up by someone else
+	err = db.QueryRow(
+		`DELETE FROM oidc_states WHERE state = $1 AND provider = $2 AND binding_hash = $3 AND expires_at > now() RETURNING nonce, verifier, user_id, reauth`,
+		[]any{state, provider, oidcBindingHash(binding)},
+		&s.Nonce, &s.Verifier, &linkUser, &reauth,
+	)
+	if err != nil {
+		if db.NoRows(err) {
+			return nil, ErrChallengeExpired
+		}
+
+		return nil, ErrServerErr
+	}
+
+	if reauth {
+		s.MaxAge = reauthMaxAge
+	}
+
+	if providerErr != "" || code == "" {
+		return nil, ErrOIDCFailed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcTimeout)
+	defer cancel()
+
+	claims, err := p.Exchange(ctx, code, s)
+	if err != nil {
+		log.Warn("Couldn't finish signing in with '%s': %v", provider, err)
+		return nil, ErrOIDCFailed
+	}
+
+	id := ""
+
+	err = db.QueryRow(`SELECT user_id FROM oidc_identities WHERE provider = $1 AND subject = $2`, []any{provider, claims.Subject}, &id)
+	if err != nil && !db.NoRows(err) {
+		return nil, ErrServerErr
+	}
+
+	if id != "" {
+		if err := checkNotErasing(id); err != nil {
+			return nil, err
+		}
+	}
+
+	if reauth {
+		// Only with an identity they already linked, signing in with any other one proves nothing
+		if id == "" || id != linkUser {
+			return nil, ErrNoAuth
+		}
+
+		grant, err := newReauthGrant(id)
+		if err != nil {
+			return nil, err
+		}
+
+		return &OIDCFinish{
+			Reauth: grant,
+		}, nil
+	}
+
+	var linked *Identity
+
+	switch {
+	case id != "" && linkUser != "" && id != linkUser:
+		return nil, ErrIdentityLinked
+	case id == "" && linkUser != "":
+		id = linkUser
+		linked, err = linkIdentity(id, provider, claims, by)
+	case id == "":
+		id, err = newOIDCUser(claims)
+		if err == nil {
+			_, err = linkIdentity(id, provider, claims, by)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Linking doesn't log anyone in, they are already logged in
+	if linkUser != "" {
+		if linked == nil {
+			// Already linked to them
+			linked = &Identity{}
+
+			err = db.QueryRow(
+				`SELECT provider, subject, email, created_at FROM oidc_identities WHERE provider = $1 AND subject = $2`,
+				[]any{provider, claims.Subject},
+				&linked.Provider, &linked.Subject, &linked.Email, &linked.CreatedAt,
+			)
+			if err != nil {
+				return nil, ErrDBHandle(err)
+			}
+		}
+
+		return &OIDCFinish{
+			Linked: linked,
+		}, nil
+	}
+
+	token := ""
+	totpEnabled := false
+
+	err = db.QueryRowID(`SELECT token, totp_enabled FROM ppl WHERE id = $1`, id, &token, &totpEnabled)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	if totpEnabled {
+		challenge, err := newChallenge(id)
+		if err != nil {
+			return nil, err
+		}
+
+		return &OIDCFinish{
+			Challenge: challenge,
+		}, nil
+	}
+
+	audit(id, by, AUDIT_LOGIN, "", nil)
+
+	return &OIDCFinish{
+		ID:    id,
+		Token: token,
+	}, nil
+}
+
+func linkIdentity(userID, provider string, claims *oidc.Claims, by *Actor) (*Identity, error) {
+	i := &Identity{
+		Provider:  provider,
+		Subject:   claims.Subject,
+		CreatedAt: time.Now(),
+	}
+	if claims.EmailVerified {
+		i.Email = claims.Email
+	}
+
+	_, err := db.InsertOne(
+		`oidc_identities`,
+		[]string{`provider`, `subject`, `user_id`, `email`, `created_at`},
+		i.Provider, i.Subject, userID, i.Email, i.CreatedAt,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	audit(userID, by, AUDIT_IDENTITY_LINK, provider, nil)
+
+	return i, nil
+}
+
+// Signs up a user for a new identity, with a username based on the one at the provider
+func newOIDCUser(claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if base == "" {
+		base = claims.Name
+	}
+
+	base = strings.Join(strings.Fields(base), "_")
+	if len(base) > 26 {
+		base = strings.ToValidUTF8(base[:26], "")
+	}
+
+	uname := base
+	for len(uname) < 7 || db.Exists(`ppl`, `username = $1`, uname) || !unameLock.Insert(uname) {
+		uname = base + "_" + randGoodString(6)
+	}
+
+	defer unameLock.Free(uname)
+
+	// Never used to log in (see Exchange), the column just can't be empty
+	placeholder, err := generateRandomBytes(32)
+	if log.ErrorIfErr(err, "generating placeholder password") {
+		return "", ErrServerErr
+	}
+
+	passwordHash, err := generateFromPassword(base64.RawURLEncoding.EncodeToString(placeholder))
+	if log.ErrorIfErr(err, "generating hash") {
+		return "", ErrServerErr
+	}
+
+	id, _, err := insertUser(uname, passwordHash, false)
+
+	return id, err
+}
+
+func GetIdentities(userID string) ([]*Identity, error) {
+	rows, err := db.Query(`SELECT provider, subject, email, created_at FROM oidc_identities WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	defer rows.Close()
+
+	identities := []*Identity{}
+
+	for rows.Next() {
+		i := &Identity{}
+
+		if err := rows.Scan(&i.Provider, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+			return nil, ErrDBHandle(err)
+		}
+
+		identities = append(identities, i)
+	}
+
+	return identities, nil
+}
+
+// Users without a password have to keep at least 1 identity or passkey
+func checkNotLastLogin(userID string) error {
+	hasPassword := false
+	logins := 0
+
+	err := db.QueryRowID(
+		`SELECT has_password, (SELECT COUNT(*) FROM oidc_identities WHERE user_id = ppl.id) + (SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = ppl.id) FROM ppl WHERE id = $1`,
+		userID,
+		&hasPassword, &logins,
+	)
+	if err != nil {
+		return ErrDBHandle(err)
+	}
+
+	if !hasPassword && logins <= 1 {
+		return ErrLastLogin
+	}
+
+	return nil
+}
+
+func UnlinkIdentity(userID, provider, subject string, by *Actor) (*Identity, error) {
+	if err := checkNotLastLogin(userID); err != nil {
+		return nil, err
+	}
+
+	i := &Identity{
+		Provider: provider,
+		Subject:  subject,
+	}
+
+	err := db.QueryRow(
+		`DELETE FROM oidc_identities WHERE provider = $1 AND subject = $2 AND user_id = $3 RETURNING email, created_at`,
+		[]any{provider, subject, userID},
+		&i.Email, &i.CreatedAt,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	audit(userID, by, AUDIT_IDENTITY_UNLINK, provider, nil)
+
+	return i, nil
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/shadiestgoat/who/oidc"
+	"github.com/shadiestgoat/who/oidc/oidctest"
+	"github.com/shadiestgoat/who/snownode"
+)
+
+const testProvider = "test"
+
+// Registers a fake provider as testProvider, signing in as a new subject
+func withOIDC(t *testing.T) *oidctest.Issuer {
+	t.Helper()
+
+	iss := oidctest.New("who")
+	iss.Subject = "test_" + snownode.Generate()
+	t.Cleanup(iss.Close)
+
+	old := oidc.Providers
+	oidc.Providers = map[string]*oidc.Provider{
+		testProvider: iss.Provider(testProvider, testOrigin+"/oidc/callback"),
+	}
+
+	t.Cleanup(func() { oidc.Providers = old })
+
+	return iss
+}
+
+// Starts a flow & signs in at the issuer. Returns what the browser passes on to FinishOIDC.
+func oidcSignIn(t *testing.T, iss *oidctest.Issuer, userID string, reauth bool) (state, binding, code string) {
+	t.Helper()
+
+	start, err := StartOIDC(testProvider, userID, reauth)
+	if err != nil {
+		t.Fatalf("starting the sign in: %v", err)
+	}
+
+	code, state, err = iss.Login(start.URL)
+	if err != nil {
+		t.Fatalf("signing in at the issuer: %v", err)
+	}
+
+	return state, start.Binding, code
+}
+
+func TestOIDCSignUp(t *testing.T) {
+	needDB(t)
+	iss := withOIDC(t)
+
+	state, binding, code := oidcSignIn(t, iss, "", false)
+
+	f, err := FinishOIDC(testProvider, state, binding, code, "", nil)
+	if err != nil {
+		t.Fatalf("signing up: %v", err)
+	}
+
+	if f.ID == "" || f.Token == "" || f.Linked != nil || f.Reauth != nil {
+		t.Fatalf("unexpected sign up: %+v", f)
+	}
+
+	// The same identity logs in to the same user
+	state, binding, code = oidcSignIn(t, iss, "", false)
+
+	again, err := FinishOIDC(testProvider, state, binding, code, "", nil)
+	if err != nil {
+		t.Fatalf("logging in: %v", err)
+	}
+
+	if again.ID != f.ID || again.Token != f.Token {
+		t.Errorf("logged in as '%s' instead of '%s'", again.ID, f.ID)
+	}
+}
+
+func TestOIDCState(t *testing.T) {
+	needDB(t)
+	iss := withOIDC(t)
+
+	state, binding, code := oidcSignIn(t, iss, "", false)
+
+	for name, c := range map[string][2]string{
+		"an unknown state":  {"not the state", binding},
+		"another browser":   {state, "not the binding"},
+		"no binding cookie": {state, ""},
+	} {
+		_, err := FinishOIDC(testProvider, c[0], c[1], code, "", nil)
+		assertHTTPError(t, name, err, ErrChallengeExpired)
+	}
+
+	// Another browser can't use up the state, the right one can still finish
+	if _, err := FinishOIDC(testProvider, state, binding, code, "", nil); err != nil {
+		t.Fatalf("finishing the sign in: %v", err)
+	}
+
+	_, err := FinishOIDC(testProvider, state, binding, code, "", nil)
+	assertHTTPError(t, "a used state", err, ErrChallengeExpired)
+
+	state, binding, _ = oidcSignIn(t, iss, "", false)
+
+	_, err = FinishOIDC(testProvider, state, binding, "", "access_denied", nil)
+	assertHTTPError(t, "an error from the provider", err, ErrOIDCFailed)
+}
+
+func TestOIDCLink(t *testing.T) {
+	needDB(t)
+	iss := withOIDC(t)
+
+	userID, _ := newTestUser(t)
+
+	// Twice, the 2nd time it's already linked to them
+	for i := 0; i < 2; i++ {
+		state, binding, code := oidcSignIn(t, iss, userID, false)
+
+		f, err := FinishOIDC(testProvider, state, binding, code, "", nil)
+		if err != nil {
+			t.Fatalf("linking: %v", err)
+		}
+
+		// The callback isn't authenticated, so it must not hand out the token
+		if f.ID != "" || f.Token != "" || f.Challenge != "" || f.Reauth != nil {
+			t.Errorf("linking returned more than the identity: %+v", f)
+		}
+
+		if f.Linked == nil || f.Linked.Provider != testProvider || f.Linked.Subject != iss.Subject {
+			t.Errorf("unexpected linked identity: %+v", f.Linked)
+		}
+	}
+
+	otherID, _ := newTestUser(t)
+	state, binding, code := oidcSignIn(t, iss, otherID, false)
+
+	_, err := FinishOIDC(testProvider, state, binding, code, "", nil)
+	assertHTTPError(t, "an identity linked to another user", err, ErrIdentityLinked)
+}
+
+func TestOIDCReauth(t *testing.T) {
+	needDB(t)
+	withWebAuthn(t)
+	iss := withOIDC(t)
+
+	state, binding, code := oidcSignIn(t, iss, "", false)
+
+	f, err := FinishOIDC(testProvider, state, binding, code, "", nil)
+	if err != nil {
+		t.Fatalf("signing up: %v", err)
+	}
+
+	userID := f.ID
+
+	state, binding, code = oidcSignIn(t, iss, userID, true)
+
+	f, err = FinishOIDC(testProvider, state, binding, code, "", nil)
+	if err != nil {
+		t.Fatalf("re-authenticating: %v", err)
+	}
+
+	if f.Reauth == nil || f.ID != "" || f.Token != "" || f.Linked != nil {
+		t.Fatalf("unexpected re-authentication: %+v", f)
+	}
+
+	if _, err := StartPasskeyRegistration(userID, &Reauth{Token: f.Reauth.Token}); err != nil {
+		t.Errorf("registering with the reauth token: %v", err)
+	}
+
+	// The provider reused an old sign in, instead of asking for a new one
+	iss.Tamper = func(header, claims map[string]any) { claims["auth_time"] = iss.SessionStart.Unix() }
+
+	state, binding, code = oidcSignIn(t, iss, userID, true)
+
+	_, err = FinishOIDC(testProvider, state, binding, code, "", nil)
+	assertHTTPError(t, "an old sign in", err, ErrOIDCFailed)
+
+	iss.Tamper = nil
+
+	// An identity that isn't linked to them doesn't count, & doesn't get linked
+	iss.Subject = "test_" + snownode.Generate()
+	state, binding, code = oidcSignIn(t, iss, userID, true)
+
+	_, err = FinishOIDC(testProvider, state, binding, code, "", nil)
+	assertHTTPError(t, "another identity", err, ErrNoAuth)
+
+	identities, err := GetIdentities(userID)
+	if err != nil {
+		t.Fatalf("getting the identities: %v", err)
+	}
+
+	if len(identities) != 1 {
+		t.Errorf("expected 1 identity, got %d", len(identities))
+	}
+}
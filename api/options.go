@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+
+	"github.com/shadiestgoat/who/vault"
+)
+
+// Multiple choice answers are shown to viewers as options.
+// Each play session sees its own (deterministic) order of the options, and options are identified by an opaque ID,
+// so neither the order nor the ID gives away the correct answer.
+// Both are derived from a MAC under a server key (see vault.MAC), so viewers can't recompute them to undo the shuffle.
+// The option ID is what gets submitted as the answer.
+
+type Option struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+}
+
+func optionHash(play, question string, i int) []byte {
+	return vault.MAC(fmt.Sprintf("option/%s-%s-%d", play, question, i))
+}
+
+func optionID(play, question string, i int) string {
+	return hex.EncodeToString(optionHash(play, question, i)[:6])
+}
+
+// answers are in their stored order
+func shuffleOptions(play, question string, answers []string) []Option {
+	opts := make([]Option, len(answers))
+
+	for i, a := range answers {
+		opts[i] = Option{
+			ID:      optionID(play, question, i),
+			Content: a,
+		}
+	}
+
+	seed := int64(binary.BigEndian.Uint64(optionHash(play, question, -1)))
+	rand.New(rand.NewSource(seed)).Shuffle(len(opts), func(i, j int) {
+		opts[i], opts[j] = opts[j], opts[i]
+	})
+
+	return opts
+}
+
+// Returns the stored index of the option, or -1 if its not an option of this question
+func optionIndex(play, question string, n int, id string) int {
+	for i := 0; i < n; i++ {
+		if optionID(play, question, i) == id {
+			return i
+		}
+	}
+
+	return -1
+}
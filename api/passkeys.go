@@ -0,0 +1,497 @@
+package api
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/webauthn"
+)
+
+// Passkey (WebAuthn) login. Passkeys are registered by a logged in user, then used to log in without a username or password.
+// Since a passkey is a new way in to the account, registering one requires re-authenticating (see StartPasskeyRegistration).
+// Users without a password re-authenticate with a passkey they already have (see StartPasskeyReauth).
+//
+// Both ceremonies are 2 steps: Start* creates a single use challenge & the options for the browser, Finish* verifies what the authenticator signed.
+// The options & credentials follow the WebAuthn JSON format (see PublicKeyCredential.parseCreationOptionsFromJSON & toJSON), binary values are base64url.
+// Passkeys verify the user themselves, so logging in with one skips TOTP.
+
+type Passkey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+type PasskeyRP struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type PasskeyUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type PasskeyParam struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type PasskeyDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type PasskeySelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+type PasskeyCreationOptions struct {
+	Challenge              string               `json:"challenge"`
+	RP                     *PasskeyRP           `json:"rp"`
+	User                   *PasskeyUser         `json:"user"`
+	PubKeyCredParams       []*PasskeyParam      `json:"pubKeyCredParams"`
+	Timeout                int64                `json:"timeout"`
+	ExcludeCredentials     []*PasskeyDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection *PasskeySelection    `json:"authenticatorSelection"`
+	Attestation            string               `json:"attestation"`
+}
+
+type PasskeyRequestOptions struct {
+	Challenge        string `json:"challenge"`
+	RPID             string `json:"rpId"`
+	Timeout          int64  `json:"timeout"`
+	UserVerification string `json:"userVerification"`
+	// Only when re-authenticating, the passkeys of the user
+	AllowCredentials []*PasskeyDescriptor `json:"allowCredentials,omitempty"`
+}
+
+type PasskeyResponse struct {
+	ClientDataJSON string `json:"clientDataJSON"`
+	// Registration only
+	AttestationObject string `json:"attestationObject"`
+	// Login only
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle"`
+}
+
+// What the browser returns from navigator.credentials.create/get
+type PasskeyCredential struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Response *PasskeyResponse `json:"response"`
+}
+
+const (
+	passkeyKindRegister = "register"
+	passkeyKindLogin    = "login"
+	passkeyKindReauth   = "reauth"
+)
+
+func newPasskeyChallenge(kind, userID string) (string, error) {
+	b, err := generateRandomBytes(32)
+	if log.ErrorIfErr(err, "generating passkey challenge") {
+		return "", ErrServerErr
+	}
+
+	challenge := base64.RawURLEncoding.EncodeToString(b)
+
+	_, err = db.InsertOne(
+		`webauthn_challenges`,
+		[]string{`challenge`, `kind`, `user_id`, `expires_at`},
+		challenge, kind, userID, time.Now().Add(challengeTTL),
+	)
+	if err != nil {
+		return "", ErrDBHandle(err)
+	}
+
+	return challenge, nil
+}
+
+// Uses up the challenge the client data was signed for. Returns the client data if the challenge was valid.
+func usePasskeyChallenge(kind, userID, clientDataJSON string) ([]byte, error) {
+	raw, err := webauthn.DecodeBase64URL(clientDataJSON)
+	if err != nil {
+		return nil, ErrBadPasskey
+	}
+
+	cd, err := webauthn.ParseClientData(raw)
+	if err != nil {
+		return nil, ErrBadPasskey
+	}
+
+	tag, err := db.Exec(
+		`DELETE FROM webauthn_challenges WHERE challenge = $1 AND kind = $2 AND user_id = $3 AND expires_at > now()`,
+		cd.Challenge, kind, userID,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrChallengeExpired
+	}
+
+	typ := "webauthn.get"
+	if kind == passkeyKindRegister {
+		typ = "webauthn.create"
+	}
+
+	if webauthn.Conf.VerifyClientData(cd, typ, cd.Challenge) != nil {
+		return nil, ErrBadPasskey
+	}
+
+	return raw, nil
+}
+
+// Requires re-authenticating (see Reauth), & a code if 2FA is on. The challenge then stands for the re-authentication in FinishPasskeyRegistration.
+func StartPasskeyRegistration(userID string, r *Reauth) (*PasskeyCreationOptions, error) {
+	if webauthn.Conf == nil {
+		return nil, ErrWebAuthnNotConfigured
+	}
+
+	if err := reauthenticate(userID, r); err != nil {
+		return nil, err
+	}
+
+	username := ""
+
+	err := db.QueryRowID(`SELECT username FROM ppl WHERE id = $1`, userID, &username)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	passkeys, err := GetPasskeys(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := newPasskeyChallenge(passkeyKindRegister, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude := []*PasskeyDescriptor{}
+	for _, p := range passkeys {
+		exclude = append(exclude, &PasskeyDescriptor{
+			Type: "public-key",
+			ID:   p.ID,
+		})
+	}
+
+	return &PasskeyCreationOptions{
+		Challenge: challenge,
+		RP: &PasskeyRP{
+			ID:   webauthn.Conf.RPID,
+			Name: webauthn.Conf.RPName,
+		},
+		User: &PasskeyUser{
+			ID:          base64.RawURLEncoding.EncodeToString([]byte(userID)),
+			Name:        username,
+			DisplayName: username,
+		},
+		PubKeyCredParams: []*PasskeyParam{
+			{Type: "public-key", Alg: webauthn.ALG_ES256},
+			{Type: "public-key", Alg: webauthn.ALG_RS256},
+		},
+		Timeout:            challengeTTL.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: &PasskeySelection{
+			ResidentKey:      "required",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}, nil
+}
+
+func FinishPasskeyRegistration(userID, name string, cred *PasskeyCredential, by *Actor) (*Passkey, error) {
+	if webauthn.Conf == nil {
+		return nil, ErrWebAuthnNotConfigured
+	}
+	if cred == nil || cred.Response == nil || cred.Type != "public-key" {
+		return nil, ErrBadPasskey
+	}
+
+	if err := cleanString(&name, 0, 64, "name"); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = "Passkey"
+	}
+
+	if _, err := usePasskeyChallenge(passkeyKindRegister, userID, cred.Response.ClientDataJSON); err != nil {
+		return nil, err
+	}
+
+	rawAtt, err := webauthn.DecodeBase64URL(cred.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrBadPasskey
+	}
+
+	ad, err := webauthn.ParseAttestationObject(rawAtt)
+	if err != nil || ad.CredentialID == nil || webauthn.Conf.VerifyAuthData(ad, true) != nil {
+		return nil, ErrBadPasskey
+	}
+
+	if _, err := webauthn.ParsePublicKey(ad.PublicKey); err != nil {
+		return nil, ErrBadPasskey
+	}
+
+	p := &Passkey{
+		ID:        base64.RawURLEncoding.EncodeToString(ad.CredentialID),
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+
+	if db.Exists(`webauthn_credentials`, `id = $1`, p.ID) {
+		return nil, ErrBadPasskey
+	}
+
+	_, err = db.InsertOne(
+		`webauthn_credentials`,
+		[]string{`id`, `user_id`, `name`, `public_key`, `sign_count`, `created_at`},
+		p.ID, userID, p.Name, ad.PublicKey, int64(ad.SignCount), p.CreatedAt,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	audit(userID, by, AUDIT_PASSKEY_ADD, p.ID, nil)
+
+	return p, nil
+}
+
+// Passkeys are discoverable, so no username is needed
+func StartPasskeyLogin() (*PasskeyRequestOptions, error) {
+	if webauthn.Conf == nil {
+		return nil, ErrWebAuthnNotConfigured
+	}
+
+	challenge, err := newPasskeyChallenge(passkeyKindLogin, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &PasskeyRequestOptions{
+		Challenge:        challenge,
+		RPID:             webauthn.Conf.RPID,
+		Timeout:          challengeTTL.Milliseconds(),
+		UserVerification: "required",
+	}, nil
+}
+
+// Returns the same as Exchange does
+func FinishPasskeyLogin(cred *PasskeyCredential, by *Actor) (id, token string, err error) {
+	if webauthn.Conf == nil {
+		return "", "", ErrWebAuthnNotConfigured
+	}
+	if cred == nil || cred.Response == nil || cred.Type != "public-key" {
+		return "", "", ErrBadPasskey
+	}
+
+	clientData, err := usePasskeyChallenge(passkeyKindLogin, "", cred.Response.ClientDataJSON)
+	if err != nil {
+		return "", "", err
+	}
+
+	id, err = verifyPasskeyAssertion(cred, clientData, by)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := checkNotErasing(id); err != nil {
+		return "", "", err
+	}
+
+	err = db.QueryRowID(`SELECT token FROM ppl WHERE id = $1`, id, &token)
+	if err != nil {
+		return "", "", ErrDBHandle(err)
+	}
+
+	audit(id, by, AUDIT_LOGIN, "", nil)
+
+	return id, token, nil
+}
+
+// For users without a password, see Reauth. Only the user's own passkeys are accepted.
+func StartPasskeyReauth(userID string) (*PasskeyRequestOptions, error) {
+	if webauthn.Conf == nil {
+		return nil, ErrWebAuthnNotConfigured
+	}
+
+	passkeys, err := GetPasskeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(passkeys) == 0 {
+		return nil, ErrBadPasskey
+	}
+
+	challenge, err := newPasskeyChallenge(passkeyKindReauth, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	allow := []*PasskeyDescriptor{}
+	for _, p := range passkeys {
+		allow = append(allow, &PasskeyDescriptor{
+			Type: "public-key",
+			ID:   p.ID,
+		})
+	}
+
+	return &PasskeyRequestOptions{
+		Challenge:        challenge,
+		RPID:             webauthn.Conf.RPID,
+		Timeout:          challengeTTL.Milliseconds(),
+		UserVerification: "required",
+		AllowCredentials: allow,
+	}, nil
+}
+
+func FinishPasskeyReauth(userID string, cred *PasskeyCredential, by *Actor) (*ReauthGrant, error) {
+	if webauthn.Conf == nil {
+		return nil, ErrWebAuthnNotConfigured
+	}
+	if cred == nil || cred.Response == nil || cred.Type != "public-key" {
+		return nil, ErrBadPasskey
+	}
+
+	clientData, err := usePasskeyChallenge(passkeyKindReauth, userID, cred.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := verifyPasskeyAssertion(cred, clientData, by)
+	if err != nil {
+		return nil, err
+	}
+	if id != userID {
+		return nil, ErrBadPasskey
+	}
+
+	return newReauthGrant(userID)
+}
+
+// Verifies an assertion of a login or re-authentication, once its challenge is used up. Returns the user of the passkey.
+func verifyPasskeyAssertion(cred *PasskeyCredential, clientData []byte, by *Actor) (string, error) {
+	id := ""
+
+	credID, err := webauthn.DecodeBase64URL(cred.ID)
+	if err != nil {
+		return "", ErrBadPasskey
+	}
+
+	publicKey := []byte{}
+	signCount := int64(0)
+
+	err = db.QueryRowID(
+		`SELECT user_id, public_key, sign_count FROM webauthn_credentials WHERE id = $1`,
+		base64.RawURLEncoding.EncodeToString(credID),
+		&id, &publicKey, &signCount,
+	)
+	if err != nil {
+		if db.NoRows(err) {
+			return "", ErrBadPasskey
+		}
+
+		return "", ErrServerErr
+	}
+
+	if cred.Response.UserHandle != "" {
+		handle, err := webauthn.DecodeBase64URL(cred.Response.UserHandle)
+		if err != nil || string(handle) != id {
+			return "", ErrBadPasskey
+		}
+	}
+
+	authData, err := webauthn.DecodeBase64URL(cred.Response.AuthenticatorData)
+	if err != nil {
+		return "", ErrBadPasskey
+	}
+	sig, err := webauthn.DecodeBase64URL(cred.Response.Signature)
+	if err != nil {
+		return "", ErrBadPasskey
+	}
+
+	ad, err := webauthn.ParseAuthData(authData)
+	if err != nil || webauthn.Conf.VerifyAuthData(ad, true) != nil {
+		return "", ErrBadPasskey
+	}
+
+	if webauthn.VerifySignature(publicKey, authData, clientData, sig) != nil {
+		audit(id, by, AUDIT_LOGIN_FAILED, "", nil)
+		return "", ErrBadPasskey
+	}
+
+	newCount := int64(ad.SignCount)
+	if !webauthn.SignCountValid(uint32(signCount), ad.SignCount) {
+		log.Warn("Passkey '%s' of '%s' has a sign count that didn't go up (%d -> %d)", cred.ID, id, signCount, newCount)
+		audit(id, by, AUDIT_LOGIN_FAILED, "", nil)
+
+		return "", ErrBadPasskey
+	}
+
+	tag, err := db.Exec(
+		`UPDATE webauthn_credentials SET sign_count = $2, last_used_at = now() WHERE id = $1 AND sign_count = $3`,
+		base64.RawURLEncoding.EncodeToString(credID), newCount, signCount,
+	)
+	if err != nil {
+		return "", ErrDBHandle(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return "", ErrBadPasskey
+	}
+
+	return id, nil
+}
+
+func GetPasskeys(userID string) ([]*Passkey, error) {
+	rows, err := db.Query(`SELECT id, name, created_at, last_used_at FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	defer rows.Close()
+
+	passkeys := []*Passkey{}
+
+	for rows.Next() {
+		p := &Passkey{}
+
+		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.LastUsedAt); err != nil {
+			return nil, ErrDBHandle(err)
+		}
+
+		passkeys = append(passkeys, p)
+	}
+
+	return passkeys, nil
+}
+
+func DeletePasskey(userID, passkeyID string, by *Actor) (*Passkey, error) {
+	if err := checkNotLastLogin(userID); err != nil {
+		return nil, err
+	}
+
+	p := &Passkey{
+		ID: passkeyID,
+	}
+
+	err := db.QueryRow(
+		`DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2 RETURNING name, created_at, last_used_at`,
+		[]any{passkeyID, userID},
+		&p.Name, &p.CreatedAt, &p.LastUsedAt,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	audit(userID, by, AUDIT_PASSKEY_REMOVE, p.ID, nil)
+
+	return p, nil
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/shadiestgoat/who/webauthn"
+	"github.com/shadiestgoat/who/webauthn/webauthntest"
+)
+
+const (
+	testRPID   = "who.test"
+	testOrigin = "https://who.test"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func withWebAuthn(t *testing.T) {
+	t.Helper()
+
+	old := webauthn.Conf
+	webauthn.Conf = &webauthn.Config{
+		RPID:    testRPID,
+		RPName:  "Who",
+		Origins: []string{testOrigin},
+	}
+
+	t.Cleanup(func() { webauthn.Conf = old })
+}
+
+func registrationCredential(a *webauthntest.Authenticator, challenge string) *PasskeyCredential {
+	cd, attObj := a.Register(challenge)
+
+	return &PasskeyCredential{
+		ID:   b64(a.CredentialID),
+		Type: "public-key",
+		Response: &PasskeyResponse{
+			ClientDataJSON:    b64(cd),
+			AttestationObject: b64(attObj),
+		},
+	}
+}
+
+func assertionCredential(a *webauthntest.Authenticator, challenge, userID string) *PasskeyCredential {
+	cd, authData, sig := a.Assert(challenge)
+
+	return &PasskeyCredential{
+		ID:   b64(a.CredentialID),
+		Type: "public-key",
+		Response: &PasskeyResponse{
+			ClientDataJSON:    b64(cd),
+			AuthenticatorData: b64(authData),
+			Signature:         b64(sig),
+			UserHandle:        b64([]byte(userID)),
+		},
+	}
+}
+
+// Registers a new passkey for a new user, returning the user's ID
+func newTestPasskey(t *testing.T, a *webauthntest.Authenticator) string {
+	t.Helper()
+
+	userID, password := newTestUser(t)
+
+	opts, err := StartPasskeyRegistration(userID, &Reauth{Password: password})
+	if err != nil {
+		t.Fatalf("starting the registration: %v", err)
+	}
+
+	if _, err := FinishPasskeyRegistration(userID, "", registrationCredential(a, opts.Challenge), nil); err != nil {
+		t.Fatalf("finishing the registration: %v", err)
+	}
+
+	return userID
+}
+
+func login(t *testing.T, a *webauthntest.Authenticator, userID string) (*PasskeyCredential, error) {
+	t.Helper()
+
+	opts, err := StartPasskeyLogin()
+	if err != nil {
+		t.Fatalf("starting the login: %v", err)
+	}
+
+	cred := assertionCredential(a, opts.Challenge, userID)
+
+	_, _, err = FinishPasskeyLogin(cred, nil)
+
+	return cred, err
+}
+
+func TestPasskeyCeremonies(t *testing.T) {
+	needDB(t)
+	withWebAuthn(t)
+
+	a := webauthntest.New(testRPID, testOrigin)
+	userID := newTestPasskey(t, a)
+
+	a.SignCount = 1
+
+	opts, err := StartPasskeyLogin()
+	if err != nil {
+		t.Fatalf("starting the login: %v", err)
+	}
+
+	id, token, err := FinishPasskeyLogin(assertionCredential(a, opts.Challenge, userID), nil)
+	if err != nil {
+		t.Fatalf("logging in: %v", err)
+	}
+
+	if id != userID || token == "" {
+		t.Errorf("logged in as '%s' instead of '%s'", id, userID)
+	}
+}
+
+func TestPasskeyRegistrationNeedsPassword(t *testing.T) {
+	needDB(t)
+	withWebAuthn(t)
+
+	userID, _ := newTestUser(t)
+
+	_, err := StartPasskeyRegistration(userID, &Reauth{Password: "not the password"})
+	assertHTTPError(t, "a wrong password", err, ErrNoAuth)
+}
+
+func TestPasskeyReusedChallenge(t *testing.T) {
+	needDB(t)
+	withWebAuthn(t)
+
+	a := webauthntest.New(testRPID, testOrigin)
+	userID := newTestPasskey(t, a)
+
+	a.SignCount = 1
+
+	cred, err := login(t, a, userID)
+	if err != nil {
+		t.Fatalf("logging in: %v", err)
+	}
+
+	// The exact same assertion, replayed
+	_, _, err = FinishPasskeyLogin(cred, nil)
+	assertHTTPError(t, "a replayed assertion", err, ErrChallengeExpired)
+
+	// A registration challenge can't be used to log in, & can only be used once
+	other := webauthntest.New(testRPID, testOrigin)
+	otherID, password := newTestUser(t)
+
+	opts, err := StartPasskeyRegistration(otherID, &Reauth{Password: password})
+	if err != nil {
+		t.Fatalf("starting the registration: %v", err)
+	}
+
+	_, _, err = FinishPasskeyLogin(assertionCredential(a, opts.Challenge, userID), nil)
+	assertHTTPError(t, "a registration challenge used for a login", err, ErrChallengeExpired)
+
+	cred = registrationCredential(other, opts.Challenge)
+	if _, err := FinishPasskeyRegistration(otherID, "", cred, nil); err != nil {
+		t.Fatalf("finishing the registration: %v", err)
+	}
+
+	_, err = FinishPasskeyRegistration(otherID, "", cred, nil)
+	assertHTTPError(t, "a replayed registration", err, ErrChallengeExpired)
+
+	// Someone else's registration challenge
+	opts, err = StartPasskeyRegistration(otherID, &Reauth{Password: password})
+	if err != nil {
+		t.Fatalf("starting the registration: %v", err)
+	}
+
+	_, err = FinishPasskeyRegistration(userID, "", registrationCredential(webauthntest.New(testRPID, testOrigin), opts.Challenge), nil)
+	assertHTTPError(t, "another user's registration challenge", err, ErrChallengeExpired)
+}
+
+func TestPasskeySignCountRegression(t *testing.T) {
+	needDB(t)
+	withWebAuthn(t)
+
+	a := webauthntest.New(testRPID, testOrigin)
+	a.SignCount = 5
+	userID := newTestPasskey(t, a)
+
+	for _, count := range []uint32{5, 4, 0} {
+		a.SignCount = count
+
+		if _, err := login(t, a, userID); err != ErrBadPasskey {
+			t.Errorf("a sign count of %d after 5: expected ErrBadPasskey, got %v", count, err)
+		}
+	}
+
+	a.SignCount = 6
+	if _, err := login(t, a, userID); err != nil {
+		t.Fatalf("a sign count of 6 after 5: %v", err)
+	}
+
+	a.SignCount = 6
+	if _, err := login(t, a, userID); err != ErrBadPasskey {
+		t.Errorf("the same sign count twice: expected ErrBadPasskey, got %v", err)
+	}
+}
+
+func TestPasskeyBadResponses(t *testing.T) {
+	needDB(t)
+	withWebAuthn(t)
+
+	a := webauthntest.New(testRPID, testOrigin)
+	userID := newTestPasskey(t, a)
+
+	badResponses := map[string]func(a *webauthntest.Authenticator){
+		"another origin":       func(a *webauthntest.Authenticator) { a.Origin = "https://evil.test" },
+		"another RP ID":        func(a *webauthntest.Authenticator) { a.RPID = "evil.test" },
+		"no user verification": func(a *webauthntest.Authenticator) { a.Flags = webauthn.FLAG_USER_PRESENT },
+	}
+
+	for name, change := range badResponses {
+		otherID, password := newTestUser(t)
+
+		opts, err := StartPasskeyRegistration(otherID, &Reauth{Password: password})
+		if err != nil {
+			t.Fatalf("starting the registration: %v", err)
+		}
+
+		other := webauthntest.New(testRPID, testOrigin)
+		change(other)
+
+		_, err = FinishPasskeyRegistration(otherID, "", registrationCredential(other, opts.Challenge), nil)
+		assertHTTPError(t, "registering with "+name, err, ErrBadPasskey)
+	}
+
+	// Logins, every one with a sign count that would otherwise be accepted
+	for name, change := range badResponses {
+		orig := *a
+		change(a)
+		a.SignCount++
+
+		_, err := login(t, a, userID)
+		assertHTTPError(t, "logging in with "+name, err, ErrBadPasskey)
+
+		*a = orig
+	}
+
+	opts, err := StartPasskeyLogin()
+	if err != nil {
+		t.Fatalf("starting the login: %v", err)
+	}
+
+	a.SignCount++
+	cred := assertionCredential(a, opts.Challenge, userID)
+
+	authData, _ := webauthn.DecodeBase64URL(cred.Response.AuthenticatorData)
+	cred.Response.AuthenticatorData = b64(authData[:36])
+
+	_, _, err = FinishPasskeyLogin(cred, nil)
+	assertHTTPError(t, "truncated authenticator data", err, ErrBadPasskey)
+
+	// Someone else's user handle
+	_, err = login(t, a, "not the user")
+	assertHTTPError(t, "another user handle", err, ErrBadPasskey)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/passwords"
+)
+
+// New passwords have to follow passwords.Rules. Each rule fails with its own error code, so the frontend can explain it.
+
+func GetPasswordPolicy() *passwords.Policy {
+	p := passwords.Rules
+	return &p
+}
+
+// userInputs are things an attacker would know about the user, ie. their username
+func checkNewPassword(password string, userInputs ...string) error {
+	p := passwords.Rules
+	l := utf8.RuneCountInString(password)
+
+	if l < p.MinLength {
+		return &HTTPError{
+			Msg:    fmt.Sprintf("The password has to be at least %d characters long", p.MinLength),
+			Status: 400,
+			Code:   "password.too_short",
+		}
+	}
+	if l > p.MaxLength {
+		return &HTTPError{
+			Msg:    fmt.Sprintf("The password can't be longer than %d characters", p.MaxLength),
+			Status: 400,
+			Code:   "password.too_long",
+		}
+	}
+
+	if passwords.Strength(password, append(userInputs, "who")...).Score < p.MinStrength {
+		return ErrPasswordTooWeak
+	}
+
+	if passwords.Breached != nil && p.BreachedCount > 0 {
+		count, err := passwords.Breached.Count(password)
+		if log.ErrorIfErr(err, "checking for a breached password") {
+			return ErrServerErr
+		}
+
+		if count >= p.BreachedCount {
+			return ErrPasswordBreached
+		}
+	}
+
+	return nil
+}
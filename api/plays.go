@@ -0,0 +1,88 @@
+package api
+
+import (
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/snownode"
+)
+
+// A play session is created each time someone opens a quiz preview.
+// The viewer sends it back with every answer, so that we know when they finish the quiz.
+//
+// Only live quizzes (see isQuizLive) can be played, apart from author previews:
+// the author can play their own quiz in any state, using a play session marked as a preview.
+
+// authorPreview should only be true if the caller made sure that the user is the author
+// inviteToken is optional, see invites.go
+func NewPlay(quizID string, authorPreview bool, inviteToken string) (string, error) {
+	if !authorPreview && !isQuizLive(quizID) {
+		return "", ErrNotFound
+	}
+
+	var invite *string
+
+	if inviteToken != "" {
+		inviteID, err := resolveInvite(quizID, inviteToken)
+		if err != nil {
+			return "", err
+		}
+
+		invite = &inviteID
+	}
+
+	id := snownode.Generate()
+
+	_, err := db.InsertOne(`plays`, []string{`id`, `quiz`, `is_preview`, `invite`}, id, quizID, authorPreview, invite)
+	if err != nil {
+		return "", ErrDBHandle(err)
+	}
+
+	return id, nil
+}
+
+// Marks the play as completed & notifies the author (see notifications.go). Plays that don't exist or belong to another quiz are ignored.
+func completePlay(play, quizID string) {
+	if play == "" {
+		return
+	}
+
+	tag, err := db.Exec(`UPDATE plays SET completed = true WHERE id = $1 AND quiz = $2 AND NOT completed`, play, quizID)
+	if err != nil || tag.RowsAffected() == 0 {
+		return
+	}
+
+	go notifyCompleted(play, quizID)
+}
+
+func isPlayOf(play, quizID string) bool {
+	return play != "" && db.Exists(`plays`, `id = $1 AND quiz = $2`, play, quizID)
+}
+
+// Returns ErrNotFound if the quiz can't be played by this play session
+func checkPlayable(quizID, play string) error {
+	if play != "" && db.Exists(`plays JOIN invites ON plays.invite = invites.id`, `plays.id = $1 AND invites.revoked`, play) {
+		return ErrInviteRevoked
+	}
+
+	if isQuizLive(quizID) {
+		return nil
+	}
+
+	if play != "" && db.Exists(`plays`, `id = $1 AND quiz = $2 AND is_preview`, play, quizID) {
+		return nil
+	}
+
+	return ErrNotFound
+}
+
+// Returns ErrPlayNotDone if the play session isn't a completed session of quizID
+func checkPlayCompleted(play, quizID string) error {
+	if err := checkPlayable(quizID, play); err != nil {
+		return err
+	}
+
+	if play == "" || !db.Exists(`plays`, `id = $1 AND quiz = $2 AND completed`, play, quizID) {
+		return ErrPlayNotDone
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+type PronounSet struct {
+	Subject           string `json:"subject"`
+	Object            string `json:"object"`
+	Possessive        string `json:"possessive"`
+	PossessivePronoun string `json:"possessivePronoun"`
+	Reflexive         string `json:"reflexive"`
+}
+
+// Used when a quiz has no pronouns, or before the reveal (see pronounsForSection)
+var pronounsThey = PronounSet{"they", "them", "their", "theirs", "themselves"}
+
+// Presets, by their short name (ie. what pronouns.page uses for them)
+var pronounPresets = map[string]PronounSet{
+	"she":  {"she", "her", "her", "hers", "herself"},
+	"he":   {"he", "him", "his", "his", "himself"},
+	"they": pronounsThey,
+	"it":   {"it", "it", "its", "its", "itself"},
+}
+
+func (p PronounSet) forms() []string {
+	return []string{p.Subject, p.Object, p.Possessive, p.PossessivePronoun, p.Reflexive}
+}
+
+// ie. she/her
+func (p PronounSet) String() string {
+	return p.Subject + "/" + p.Object
+}
+
+// The key of this set if it's a preset, otherwise an empty string
+func (p PronounSet) preset() string {
+	if preset, ok := pronounPresets[p.Subject]; ok && preset == p {
+		return p.Subject
+	}
+
+	return ""
+}
+
+// Accepts either the full object, or a preset as a string ("she/her" or "she")
+func (p *PronounSet) UnmarshalJSON(b []byte) error {
+	short := ""
+
+	if json.Unmarshal(b, &short) == nil {
+		preset, ok := pronounPresets[strings.ToLower(strings.Split(short, "/")[0])]
+		if !ok {
+			return ErrBadPronouns
+		}
+
+		*p = preset
+
+		return nil
+	}
+
+	type rawPronounSet PronounSet
+
+	return json.Unmarshal(b, (*rawPronounSet)(p))
+}
+
+func (p *PronounSet) Sanitize() error {
+	forms := []*string{&p.Subject, &p.Object, &p.Possessive, &p.PossessivePronoun, &p.Reflexive}
+
+	for _, f := range forms {
+		*f = strings.ToLower(*f)
+
+		if err := cleanString(f, 1, 16, "pronouns"); err != nil {
+			return err
+		}
+
+		if strings.ContainsAny(*f, " /&") {
+			return ErrBadPronouns
+		}
+	}
+
+	return nil
+}
+
+func sanitizePronouns(sets []PronounSet) ([]PronounSet, error) {
+	if len(sets) > 3 {
+		return nil, &HTTPError{
+			Msg:    "Need at most 3 pronoun sets",
+			Status: 400,
+		}
+	}
+
+	seen := map[PronounSet]bool{}
+	newSets := []PronounSet{}
+
+	for _, p := range sets {
+		if err := p.Sanitize(); err != nil {
+			return nil, err
+		}
+
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		newSets = append(newSets, p)
+	}
+
+	return newSets, nil
+}
+
+// Pronoun sets are stored in the db as 'subject/object/possessive/possessivePronoun/reflexive'
+
+func encodePronouns(sets []PronounSet) []string {
+	enc := []string{}
+
+	for _, p := range sets {
+		enc = append(enc, strings.Join(p.forms(), "/"))
+	}
+
+	return enc
+}
+
+func decodePronouns(enc []string) []PronounSet {
+	sets := []PronounSet{}
+
+	for _, e := range enc {
+		forms := strings.Split(e, "/")
+		if len(forms) != 5 {
+			continue
+		}
+
+		sets = append(sets, PronounSet{forms[0], forms[1], forms[2], forms[3], forms[4]})
+	}
+
+	return sets
+}
+
+// Generates a pronouns.page link for the pronoun sets, ie. https://pronouns.page/she&they
+func PronounsPageURL(sets []PronounSet) string {
+	if len(sets) == 0 {
+		return ""
+	}
+
+	paths := []string{}
+
+	for _, p := range sets {
+		if preset := p.preset(); preset != "" {
+			paths = append(paths, preset)
+			continue
+		}
+
+		forms := []string{}
+		for _, f := range p.forms() {
+			forms = append(forms, url.PathEscape(f))
+		}
+
+		paths = append(paths, strings.Join(forms, "/"))
+	}
+
+	return "https://pronouns.page/" + strings.Join(paths, "&")
+}
+
+// The redirect at the end of the quiz. If the author didn't set one, its a pronouns.page link
+func quizRedirect(redirect string, sets []PronounSet) string {
+	if redirect != "" {
+		return redirect
+	}
+
+	return PronounsPageURL(sets)
+}
+
+// The pronouns to use in question content.
+// Before the reveal (sections 1 & 2), neutral pronouns are used. Section 3 uses the chosen pronouns.
+func pronounsForSection(section int, sets []PronounSet) PronounSet {
+	if section != 3 || len(sets) == 0 {
+		return pronounsThey
+	}
+
+	return sets[0]
+}
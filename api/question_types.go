@@ -0,0 +1,319 @@
+package api
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type QuestionType string
+
+const (
+	// Free text, answers are the accepted answers
+	QUESTION_TEXT QuestionType = "text"
+	// Single answer multiple choice, answers are the options & CorrectAnswer the index of the correct one
+	QUESTION_CHOICE QuestionType = "choice"
+	// Select all that apply, answers are the options & CorrectAnswers the indexes of the correct ones
+	QUESTION_MULTI QuestionType = "multi"
+	// NumericAnswer ± NumericTolerance
+	QUESTION_NUMERIC QuestionType = "numeric"
+	// CorrectAnswer is 0 for yes, 1 for no
+	QUESTION_YES_NO QuestionType = "yesno"
+)
+
+var ErrBadQuestionType = &HTTPError{
+	Msg:    "Unknown question type",
+	Status: 400,
+}
+
+func (t QuestionType) IsValid() bool {
+	switch t {
+	case QUESTION_TEXT, QUESTION_CHOICE, QUESTION_MULTI, QUESTION_NUMERIC, QUESTION_YES_NO:
+		return true
+	}
+
+	return false
+}
+
+// Whether the question is answered by picking options
+func (t QuestionType) HasOptions() bool {
+	return t == QUESTION_CHOICE || t == QUESTION_MULTI
+}
+
+// What a viewer submits
+type Answer struct {
+	// Free text, the option ID for multiple choice, a number for numeric and 'yes'/'no' for yes/no questions
+	Answer string `json:"answer"`
+	// Option IDs, for multi-select questions
+	Options []string `json:"options,omitempty"`
+}
+
+// Sanitizes q.Answers for questions with options. Options keep their casing, since they are displayed
+func (q *FullQuestion) sanitizeOptions() error {
+	if err := cleanStringArr(q.Answers, 1, 33, "questions.answers"); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+
+	for _, ans := range q.Answers {
+		key := strings.ToLower(ans)
+
+		if seen[key] {
+			return &HTTPError{
+				Msg:    "Duplicate options",
+				Status: 400,
+			}
+		}
+		seen[key] = true
+	}
+
+	if len(q.Answers) < 2 || len(q.Answers) > 6 {
+		return &HTTPError{
+			Msg:    "Need 2-6 options",
+			Status: 400,
+		}
+	}
+
+	return nil
+}
+
+func (q *FullQuestion) sanitizeText() error {
+	if err := cleanStringArr(q.Answers, 1, 33, "questions.answers"); err != nil {
+		return err
+	}
+
+	answers := map[string]bool{}
+
+	newAns := []string{}
+
+	for _, ans := range q.Answers {
+		ans = q.Match.Normalize(ans)
+
+		if ans == "" || answers[ans] {
+			continue
+		}
+		answers[ans] = true
+
+		newAns = append(newAns, ans)
+	}
+
+	q.Answers = newAns
+
+	if len(q.Answers) == 0 || len(q.Answers) > 4 {
+		return &HTTPError{
+			Msg:    "Need 1-4 answers",
+			Status: 400,
+		}
+	}
+
+	return nil
+}
+
+// Type specific sanitization, the type itself is assumed to be valid
+func (q *FullQuestion) sanitizeType() error {
+	switch q.Type {
+	case QUESTION_TEXT:
+		return q.sanitizeText()
+	case QUESTION_CHOICE:
+		if err := q.sanitizeOptions(); err != nil {
+			return err
+		}
+
+		if q.CorrectAnswer >= len(q.Answers) || q.CorrectAnswer < 0 {
+			return ErrBadBody
+		}
+	case QUESTION_MULTI:
+		if err := q.sanitizeOptions(); err != nil {
+			return err
+		}
+
+		correct := map[int]bool{}
+
+		for _, c := range q.CorrectAnswers {
+			if c >= len(q.Answers) || c < 0 {
+				return ErrBadBody
+			}
+
+			correct[c] = true
+		}
+
+		if len(correct) == 0 {
+			return &HTTPError{
+				Msg:    "Need at least 1 correct option",
+				Status: 400,
+			}
+		}
+
+		q.CorrectAnswers = []int{}
+		for c := range correct {
+			q.CorrectAnswers = append(q.CorrectAnswers, c)
+		}
+		sort.Ints(q.CorrectAnswers)
+	case QUESTION_NUMERIC:
+		q.Answers = []string{}
+
+		if math.IsNaN(q.NumericAnswer) || math.IsInf(q.NumericAnswer, 0) ||
+			math.IsNaN(q.NumericTolerance) || math.IsInf(q.NumericTolerance, 0) || q.NumericTolerance < 0 {
+			return &HTTPError{
+				Msg:    "Bad numeric answer",
+				Status: 400,
+			}
+		}
+	case QUESTION_YES_NO:
+		q.Answers = []string{}
+
+		if q.CorrectAnswer != 0 && q.CorrectAnswer != 1 {
+			return ErrBadBody
+		}
+	}
+
+	return nil
+}
+
+// Whether the creator gave the answer of the question, instead of it being left at its zero value
+func (q *FullQuestion) hasAnswer() bool {
+	switch q.Type {
+	case QUESTION_TEXT:
+		return len(q.Answers) != 0
+	case QUESTION_CHOICE:
+		return len(q.Answers) != 0 && q.sentCorrectAnswer
+	case QUESTION_MULTI:
+		return len(q.Answers) != 0 && len(q.CorrectAnswers) != 0
+	case QUESTION_NUMERIC:
+		return q.sentNumericAnswer
+	case QUESTION_YES_NO:
+		return q.sentCorrectAnswer
+	}
+
+	return false
+}
+
+func parseYesNo(s string) int {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "yes", "y", "true":
+		return 0
+	case "no", "n", "false":
+		return 1
+	}
+
+	return -1
+}
+
+// Check if the answer is correct. play is the play session that the options were shuffled for.
+func (q *FullQuestion) Check(play string, a *Answer) bool {
+	switch q.Type {
+	case QUESTION_TEXT:
+		answer := q.Match.Normalize(a.Answer)
+
+		for _, stored := range q.Answers {
+			if q.Match.Matches(q.Match.Normalize(stored), answer) {
+				return true
+			}
+		}
+	case QUESTION_CHOICE:
+		if q.CorrectAnswer >= len(q.Answers) || q.CorrectAnswer < 0 {
+			return true
+		}
+
+		return optionIndex(play, q.ID, len(q.Answers), strings.TrimSpace(a.Answer)) == q.CorrectAnswer
+	case QUESTION_MULTI:
+		picked := map[int]bool{}
+
+		for _, o := range a.Options {
+			i := optionIndex(play, q.ID, len(q.Answers), strings.TrimSpace(o))
+			if i == -1 {
+				return false
+			}
+
+			picked[i] = true
+		}
+
+		if len(picked) != len(q.CorrectAnswers) {
+			return false
+		}
+
+		for _, c := range q.CorrectAnswers {
+			if !picked[c] {
+				return false
+			}
+		}
+
+		return true
+	case QUESTION_NUMERIC:
+		n, err := strconv.ParseFloat(strings.TrimSpace(a.Answer), 64)
+		if err != nil {
+			return false
+		}
+
+		return math.Abs(n-q.NumericAnswer) <= q.NumericTolerance
+	case QUESTION_YES_NO:
+		return parseYesNo(a.Answer) == q.CorrectAnswer
+	}
+
+	return false
+}
+
+// The columns of a full question, in the order of (*FullQuestion).scanTargets
+const fullQuestionColumns = `questions.id, question_type, answers, correct_answer, correct_answers, numeric_answer, numeric_tolerance, content, ` +
+	`fold_accents, strip_punctuation, ignore_articles, match_tolerance, hint, hint_after, max_attempts, media`
+
+// The columns used when inserting questions, in the order of (*FullQuestion).insertRow
+var questionInsertColumns = []string{
+	`id`, `quiz`,
+	`question_type`,
+	`answers`,
+	`correct_answer`, `correct_answers`, `numeric_answer`, `numeric_tolerance`,
+	`content`,
+	`fold_accents`, `strip_punctuation`, `ignore_articles`, `match_tolerance`,
+	`hint`, `hint_after`, `max_attempts`,
+	`media`,
+}
+
+func (q *FullQuestion) insertRow(quizID string) []any {
+	return []any{
+		q.ID, quizID,
+		q.Type,
+		q.Answers,
+		q.CorrectAnswer, q.CorrectAnswers, q.NumericAnswer, q.NumericTolerance,
+		q.Content,
+		q.Match.FoldAccents, q.Match.StripPunctuation, q.Match.IgnoreArticles, q.Match.Tolerance,
+		q.Hint, q.HintAfter, q.MaxAttempts,
+		q.Media,
+	}
+}
+
+func newFullQuestion() *FullQuestion {
+	return &FullQuestion{
+		Question: Question{
+			ID:      "",
+			Type:    QUESTION_TEXT,
+			Content: "",
+			Answers: []string{},
+			Media:   "",
+			Match:   &MatchPolicy{},
+		},
+		CorrectAnswer:    0,
+		CorrectAnswers:   []int{},
+		NumericAnswer:    0,
+		NumericTolerance: 0,
+		Hint:             "",
+		HintAfter:        0,
+		MaxAttempts:      0,
+	}
+}
+
+func (q *FullQuestion) scanTargets() []any {
+	return []any{
+		&q.ID, &q.Type, &q.Answers, &q.CorrectAnswer, &q.CorrectAnswers, &q.NumericAnswer, &q.NumericTolerance, &q.Content,
+		&q.Match.FoldAccents, &q.Match.StripPunctuation, &q.Match.IgnoreArticles, &q.Match.Tolerance,
+		&q.Hint, &q.HintAfter, &q.MaxAttempts,
+		&q.Media,
+	}
+}
+
+// Must be called after scanning
+func (q *FullQuestion) afterScan() {
+	q.IsMultipleChoice = q.Type == QUESTION_CHOICE
+}
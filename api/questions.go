@@ -1,76 +1,97 @@
 package api
 
 import (
-	"fmt"
-	"strings"
+	"encoding/json"
 
-	"github.com/shadiestgoat/log"
 	"github.com/shadiestgoat/who/db"
 )
 
-// Notice about question IDs:
-// There are 2 types of questions: user created ones (the manual ones) and the special ones (automatic)
-//
-// Normal questions are the ones created by the user, ie. the ones that go into the questions table
-// Their ID is generated using {id}{section:1|2|3}
-//
-// There are 2 special questions per quiz: 3d question of section 2 & 3.
-// 3-2 (q3s2): What is another name for {nickname}
-// Accepted answers are {deadname}, {deadname} {deadlastname}, {chosenname} {chosenlastname}
-// (note: {deadname} and {chosenname} are arrays, combinations apply to all items in the array)
-// If {chosenname} is in the answer, the response should be the 'redirect', otherwise go to 1-3
-// 3-3: What is another name for {chosenname}
-// Accepted answers are {deadname}, {deadname} {deadlastname}, {nickname}
-// Special ID format: sp-{2|3}-{quizID}
-// (2 = "3-2", 3 = "3-3")
+// See steps.go for a notice about question (step) IDs
 
 type Question struct {
-	ID string `json:"id"`
-
-	Content          string   `json:"content"`
-	IsMultipleChoice bool     `json:"isMultipleChoice"`
-	Answers          []string `json:"answers,omitempty"`
+	// For viewers this is the step ID (see steps.go), for admin endpoints it's the question's ID
+	ID   string `json:"id"`
+	Quiz string `json:"quiz"`
+
+	Type    QuestionType `json:"type"`
+	Content string       `json:"content"`
+	// Legacy, same as Type == QUESTION_CHOICE. Used as the type if Type isn't set when creating/editing
+	IsMultipleChoice bool `json:"isMultipleChoice"`
+	// Only for admins & when creating/editing. Viewers get Options instead
+	Answers []string `json:"answers,omitempty"`
+	// The shuffled answers of a question with options, for viewers
+	Options []Option `json:"options,omitempty"`
+	// The ID of an image attached to the question, see media.go
+	Media string `json:"media,omitempty"`
+
+	// If nil when creating/editing, the default policy is used
+	Match *MatchPolicy `json:"match,omitempty"`
 }
 
+// See QuestionType for the meaning of the answer fields
 type FullQuestion struct {
 	Question
 
-	CorrectAnswer int `json:"correctAnswer"`
+	CorrectAnswer    int     `json:"correctAnswer"`
+	CorrectAnswers   []int   `json:"correctAnswers,omitempty"`
+	NumericAnswer    float64 `json:"numericAnswer,omitempty"`
+	NumericTolerance float64 `json:"numericTolerance,omitempty"`
+
+	// See attempts.go
+	Hint        string `json:"hint,omitempty"`
+	HintAfter   int    `json:"hintAfter,omitempty"`
+	MaxAttempts int    `json:"maxAttempts,omitempty"`
+
+	// Whether correctAnswer & numericAnswer were in the request, since their zero values are valid answers (see hasAnswer)
+	sentCorrectAnswer bool
+	sentNumericAnswer bool
 }
 
-func (q *Question) Sanitize() error {
-	var err error
+func (q *FullQuestion) UnmarshalJSON(b []byte) error {
+	type rawFullQuestion FullQuestion
 
-	if err = cleanString(&q.Content, 2, 65, "questions.content"); err != nil {
+	if err := json.Unmarshal(b, (*rawFullQuestion)(q)); err != nil {
 		return err
 	}
-	if err = cleanStringArr(q.Answers, 2, 33, "questions.answers"); err != nil {
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
 		return err
 	}
 
-	answers := map[string]bool{}
+	q.sentCorrectAnswer = fields["correctAnswer"] != nil && string(fields["correctAnswer"]) != "null"
+	q.sentNumericAnswer = fields["numericAnswer"] != nil && string(fields["numericAnswer"]) != "null"
 
-	newAns := []string{}
-
-	for _, ans := range q.Answers {
-		ans = strings.ToLower(ans)
+	return nil
+}
 
-		if answers[ans] {
-			continue
-		}
-		answers[ans] = true
+func (q *Question) Sanitize() error {
+	var err error
 
-		newAns = append(newAns, ans)
+	if err = cleanString(&q.Content, 2, 65, "questions.content"); err != nil {
+		return err
+	}
+	if err = validateTemplate(q.Content); err != nil {
+		return err
 	}
 
-	q.Answers = newAns
-
-	if len(q.Answers) == 0 || len(q.Answers) > 4 {
-		return &HTTPError{
-			Msg:    "Too many answers",
-			Status: 400,
+	if q.Type == "" {
+		q.Type = QUESTION_TEXT
+		if q.IsMultipleChoice {
+			q.Type = QUESTION_CHOICE
 		}
 	}
+	if !q.Type.IsValid() {
+		return ErrBadQuestionType
+	}
+	q.IsMultipleChoice = q.Type == QUESTION_CHOICE
+
+	if q.Match == nil {
+		q.Match = defaultMatchPolicy()
+	}
+	if err = q.Match.Sanitize(); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -80,24 +101,27 @@ func (q *FullQuestion) Sanitize() error {
 		return err
 	}
 
-	if q.CorrectAnswer >= len(q.Answers) || q.CorrectAnswer < 0 {
-		return ErrBadBody
+	if q.CorrectAnswers == nil {
+		q.CorrectAnswers = []int{}
 	}
 
-	return nil
-}
+	if err := q.sanitizeAttempts(); err != nil {
+		return err
+	}
 
-func genSpecialQuestion(ogID string) (*Question, error) {
-	id := ogID[3:]
+	return q.sanitizeType()
+}
 
-	specialTime := id[0]
-	quizID := id[2:]
+func genSpecialQuestion(step StepID, play string) (*Question, error) {
+	if err := checkPlayable(step.Quiz, play); err != nil {
+		return nil, err
+	}
 
-	switch specialTime {
-	case '2':
+	switch step.Section {
+	case 2:
 		nickname := ""
 
-		err := db.QueryRowID(`SELECT nickname FROM quiz WHERE id = $1`, quizID, &nickname)
+		err := db.QueryRowID(`SELECT nickname FROM quiz WHERE id = $1`, step.Quiz, &nickname)
 		if err != nil {
 			if db.NoRows(err) {
 				return nil, ErrNotFound
@@ -107,23 +131,19 @@ func genSpecialQuestion(ogID string) (*Question, error) {
 		}
 
 		return &Question{
-			ID:      ogID,
+			ID:      step.String(),
+			Quiz:    step.Quiz,
 			Content: "What is another name for " + Capitalize(nickname) + "?",
 		}, nil
-	case '3':
-		chosenName := ""
-
-		err := db.QueryRowID(`SELECT chosenname[1] FROM quiz WHERE id = $1`, quizID, &chosenName)
+	case 3:
+		chosenName, err := GetChosenName(step.Quiz)
 		if err != nil {
-			if db.NoRows(err) {
-				return nil, ErrNotFound
-			}
-
-			return nil, ErrServerErr
+			return nil, err
 		}
 
 		return &Question{
-			ID:      ogID,
+			ID:      step.String(),
+			Quiz:    step.Quiz,
 			Content: "Who the fuck is " + Capitalize(chosenName) + "??",
 		}, nil
 	}
@@ -131,65 +151,87 @@ func genSpecialQuestion(ogID string) (*Question, error) {
 	return nil, ErrNotFound
 }
 
+// Returns the question IDs of a section, in order
+func sectionOrder(section int, order []string, dropQuestion int) []string {
+	if section == 1 {
+		return order
+	}
+
+	tmpOrder := []string{}
+
+	for i, o := range order {
+		if i == dropQuestion {
+			continue
+		}
+
+		tmpOrder = append(tmpOrder, o)
+	}
+
+	return tmpOrder
+}
+
 // Get a question based of off it's position in the quiz, section and question being from 1-3 (inclusive)
-func GetQuestionUsingPosition(section, question int, quizID string) (*Question, error) {
+func GetQuestionUsingPosition(section, question int, quizID, play string) (*Question, error) {
 	if question == 3 && section != 1 {
-		return genSpecialQuestion("sp-" + fmt.Sprint(section) + "-" + quizID)
+		return genSpecialQuestion(StepID{
+			Section: section,
+			Quiz:    quizID,
+		}, play)
 	}
 
-	qID := ""
-	if section == 1 {
-
-		err := db.QueryRowID(`SELECT order[1] FROM quiz WHERE id = $1`, quizID, &qID)
+	order := []string{}
+	dropQuestion := 0
 
-		if err != nil {
-			return nil, ErrDBHandle(err)
-		}
-	} else {
-		order := []string{}
-		exception := 0
+	err := db.QueryRowID(`SELECT "order", drop_question FROM quiz WHERE id = $1`, quizID, &order, &dropQuestion)
 
-		err := db.QueryRowID(`SELECT order, drop_question FROM quiz WHERE id = $1`, quizID, &order, &exception)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
 
-		if err != nil {
-			return nil, ErrDBHandle(err)
-		}
+	order = sectionOrder(section, order, dropQuestion)
 
-		if exception == 0 {
-			qID = order[1]
-		} else {
-			qID = order[0]
-		}
+	if question < 1 || question > len(order) {
+		return nil, ErrNotFound
 	}
 
-	return GetQuestion(qID + fmt.Sprint(section))
+	return getStep(StepID{
+		Section:  section,
+		Question: order[question-1],
+		Quiz:     quizID,
+	}, play)
 }
 
-func GetQuestion(id string) (*Question, error) {
-	if strings.HasPrefix(id, "sp-") {
-		return genSpecialQuestion(id)
+// Get a question using it's step ID. quizID can be empty for legacy routes, play can be empty if the viewer has no play session
+func GetQuestion(quizID, play, id string) (*Question, error) {
+	step, err := parseScopedStepID(quizID, id)
+	if err != nil {
+		return nil, err
 	}
 
-	if id == "" {
-		return nil, ErrNotFound
+	return getStep(step, play)
+}
+
+func getStep(step StepID, play string) (*Question, error) {
+	if step.IsSpecial() {
+		return genSpecialQuestion(step, play)
 	}
 
 	q := &Question{
-		ID:               id,
+		ID:               step.String(),
+		Type:             QUESTION_TEXT,
 		Content:          "",
 		IsMultipleChoice: false,
 		Answers:          []string{},
 	}
 
-	quiz := ""
-
 	err := db.QueryRowID(
-		`SELECT is_multiple_choice, answers, content, quiz FROM questions WHERE id = $1`,
-		id[:len(id)-1],
-		&q.IsMultipleChoice,
+		`SELECT question_type, answers, content, quiz, media FROM questions WHERE id = $1`,
+		step.Question,
+		&q.Type,
 		&q.Answers,
 		&q.Content,
-		&quiz,
+		&q.Quiz,
+		&q.Media,
 	)
 
 	if err != nil {
@@ -200,32 +242,90 @@ func GetQuestion(id string) (*Question, error) {
 		return nil, ErrServerErr
 	}
 
-	if !q.IsMultipleChoice {
-		q.Answers = nil
+	if err := step.Scope(q.Quiz); err != nil {
+		return nil, err
+	}
+
+	if err := checkPlayable(q.Quiz, play); err != nil {
+		return nil, err
+	}
+
+	q.IsMultipleChoice = q.Type == QUESTION_CHOICE
+
+	if q.Type.HasOptions() {
+		q.Options = shuffleOptions(play, step.Question, q.Answers)
+	}
+
+	q.Answers = nil
+
+	vars, err := quizTemplateVars(q.Quiz, step.Section)
+	if err != nil {
+		return nil, err
+	}
+
+	q.Content = renderTemplate(q.Content, vars)
+
+	return q, nil
+}
+
+func quizTemplateVars(quizID string, section int) (templateVars, error) {
+	nickname := ""
+	pronouns := []string{}
+	names := newQuizNames(quizID)
+
+	err := db.QueryRowID(
+		`SELECT nickname, pronouns, `+quizNameColumns+` FROM quiz WHERE id = $1`,
+		quizID,
+		append([]any{&nickname, &pronouns}, names.scanTargets()...)...,
+	)
+
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	if err := names.decrypt(); err != nil {
+		return nil, err
+	}
+
+	deadName, deadLastName, chosenName := "", names.DeadLastName.Reveal(), ""
+	if len(names.DeadNames) != 0 {
+		deadName = names.DeadNames[0].Reveal()
+	}
+	if len(names.ChosenNames) != 0 {
+		chosenName = names.ChosenNames[0]
 	}
 
-	name := ""
+	// The dead names of hash only quizzes are not stored
+	if names.HashOnly {
+		deadName, deadLastName = nickname, ""
+	}
 
-	switch id[len(id)-1] {
-	case '1':
-		// deadname
-		db.QueryRowID(`SELECT deadname[1] FROM quiz WHERE id = $1`, quiz, &name)
-	case '2':
-		// nickname
-		db.QueryRowID(`SELECT nickname FROM quiz WHERE id = $1`, quiz, &name)
-	case '3':
-		// chosenname
-		db.QueryRowID(`SELECT chosenname[1] FROM quiz WHERE id = $1`, quiz, &name)
+	return newTemplateVars(
+		section,
+		deadName, deadLastName,
+		chosenName, names.ChosenLastName,
+		nickname,
+		decodePronouns(pronouns),
+	), nil
+}
+
+// Admin only!
+func getFullQuestion(id string) (*FullQuestion, error) {
+	q := newFullQuestion()
+
+	err := db.QueryRowID(`SELECT `+fullQuestionColumns+` FROM questions WHERE id = $1`, id, q.scanTargets()...)
+	if err != nil {
+		return nil, ErrDBHandle(err)
 	}
 
-	q.Content = strings.ReplaceAll(q.Content, "{{name}}", name)
+	q.afterScan()
 
 	return q, nil
 }
 
 // Admin only!
 func GetQuestions(quiz string) ([3]*FullQuestion, error) {
-	rows, err := db.Query(`SELECT id, is_multiple_choice, answers, correct_answer, content FROM questions WHERE quiz = $1 LIMIT 3`, quiz)
+	rows, err := db.Query(`SELECT `+fullQuestionColumns+` FROM questions WHERE quiz = $1 LIMIT 3`, quiz)
 
 	if err != nil {
 		return [3]*FullQuestion{}, ErrDBHandle(err)
@@ -238,44 +338,63 @@ func GetQuestions(quiz string) ([3]*FullQuestion, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		tmpQ := &FullQuestion{
-			Question: Question{
-				ID:               "",
-				Content:          "",
-				IsMultipleChoice: false,
-				Answers:          []string{},
-			},
-			CorrectAnswer: 0,
-		}
+		tmpQ := newFullQuestion()
 
 		q[i] = tmpQ
 
-		err := rows.Scan(&tmpQ.ID, &tmpQ.IsMultipleChoice, &tmpQ.Answers, &tmpQ.CorrectAnswer, &tmpQ.Content)
+		err := rows.Scan(tmpQ.scanTargets()...)
 
 		if err != nil {
 			return [3]*FullQuestion{}, ErrDBHandle(err)
 		}
 
+		tmpQ.afterScan()
+
 		i++
 	}
 
 	return q, nil
 }
 
-func EditQuestion(q *FullQuestion) (*FullQuestion, error) {
+func EditQuestion(q *FullQuestion, by *Actor) (*FullQuestion, error) {
 	if err := q.Sanitize(); err != nil {
 		return nil, err
 	}
 
-	_, err := db.Exec(
-		`UPDATE questions SET is_multiple_choice = $1, answers = $2, correct_answer = $3, content = $4 WHERE id = $5`,
-		q.IsMultipleChoice, q.Answers, q.CorrectAnswer, q.Content, q.ID,
+	quizID, author := "", ""
+
+	err := db.QueryRowID(`SELECT questions.quiz, quiz.author FROM questions JOIN quiz ON questions.quiz = quiz.id WHERE questions.id = $1`, q.ID, &quizID, &author)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	old, err := getFullQuestion(q.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	old.Quiz, q.Quiz = quizID, quizID
+
+	if err := checkMedia(quizID, q.Media); err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(
+		`UPDATE questions SET question_type = $1, answers = $2, correct_answer = $3, correct_answers = $4, numeric_answer = $5, numeric_tolerance = $6, content = $7, `+
+			`fold_accents = $8, strip_punctuation = $9, ignore_articles = $10, match_tolerance = $11, hint = $12, hint_after = $13, max_attempts = $14, media = $15 WHERE id = $16`,
+		q.Type, q.Answers, q.CorrectAnswer, q.CorrectAnswers, q.NumericAnswer, q.NumericTolerance, q.Content,
+		q.Match.FoldAccents, q.Match.StripPunctuation, q.Match.IgnoreArticles, q.Match.Tolerance,
+		q.Hint, q.HintAfter, q.MaxAttempts,
+		q.Media,
+		q.ID,
 	)
 
 	if err != nil {
 		return nil, ErrDBHandle(err)
 	}
 
+	audit(author, by, AUDIT_QUESTION_EDIT, q.ID, auditDiff(old, q))
+
 	return q, nil
 }
 
@@ -283,6 +402,11 @@ type QuestionResp struct {
 	Correct bool      `json:"correct"`
 	Next    *Question `json:"next,omitempty"`
 
+	// Set after enough wrong attempts
+	Hint string `json:"hint,omitempty"`
+	// Set when the viewer ran out of attempts, Next is then set too
+	Answer string `json:"answer,omitempty"`
+
 	Redirect string `json:"redirect,omitempty"`
 }
 
@@ -297,203 +421,181 @@ func genGoodQuestionResp(q *Question, err error) (*QuestionResp, error) {
 	}, nil
 }
 
-func AnswerQuestion(id string, answer string) (*QuestionResp, error) {
-	answer = strings.ToLower(answer)
-
-	if strings.HasPrefix(id, "sp-") {
-		return answerSpecial(id, answer)
-	}
-
-	if id == "" {
-		return nil, ErrNotFound
+// Answer a step. quizID can be empty for legacy routes, play can be empty if the viewer has no play session
+func AnswerQuestion(quizID, play, id string, answer *Answer) (*QuestionResp, error) {
+	step, err := parseScopedStepID(quizID, id)
+	if err != nil {
+		return nil, err
 	}
 
-	section := id[len(id)-1]
-
-	if section < '1' || section > '3' {
-		return nil, ErrNotFound
+	if step.IsSpecial() {
+		return answerSpecial(step, play, specialMatchPolicy.Normalize(answer.Answer))
 	}
 
-	qID := id[:len(id)-1]
-
-	multipleChoice := false
-	answers := []string{}
-	correctAnswer := 0
+	q := newFullQuestion()
 	order := []string{}
 	dropQuestion := 0
-	quizID := ""
 
-	err := db.QueryRowID(
-		`SELECT is_multiple_choice, answers, correct_answer, quiz.order, drop_question, quiz.id FROM questions JOIN quiz ON questions.quiz = quiz.id WHERE questions.id = $1`,
-		qID,
-		&multipleChoice, &answers, &correctAnswer, &order, &dropQuestion, &quizID,
+	err = db.QueryRowID(
+		`SELECT `+fullQuestionColumns+`, quiz."order", drop_question, quiz.id FROM questions JOIN quiz ON questions.quiz = quiz.id WHERE questions.id = $1`,
+		step.Question,
+		append(q.scanTargets(), &order, &dropQuestion, &quizID)...,
 	)
 	if err != nil {
 		return nil, ErrDBHandle(err)
 	}
 
-	isCorrect := false
+	q.afterScan()
 
-	if multipleChoice {
-		if correctAnswer >= len(answers) || correctAnswer < 0 {
-			isCorrect = true
-		} else {
-			isCorrect = answer == answers[correctAnswer]
-		}
-	} else {
-		for _, a := range answers {
-			if a == answer {
-				isCorrect = true
-				break
-			}
-		}
+	if err := step.Scope(quizID); err != nil {
+		return nil, err
 	}
 
-	if !isCorrect {
-		return &QuestionResp{
-			Correct: false,
-		}, nil
+	if err := checkPlayable(quizID, play); err != nil {
+		return nil, err
 	}
 
-	if section != '1' {
-		tmpOrder := []string{}
-
-		for i, o := range order {
-			if i == dropQuestion {
-				continue
-			}
-
-			tmpOrder = append(tmpOrder, o)
-		}
-
-		order = tmpOrder
-	}
+	order = sectionOrder(step.Section, order, dropQuestion)
 
 	questionIndex := -1
 
 	for i, o := range order {
-		if o == qID {
+		if o == step.Question {
 			questionIndex = i
 			break
 		}
 	}
 
+	// ie. the dropped question in section 2 or 3
 	if questionIndex == -1 {
-		log.Warn("Crazy stuff is happening mannn")
-		return nil, ErrServerErr
+		return nil, ErrNotFound
+	}
+
+	resp := &QuestionResp{
+		Correct: q.Check(play, answer),
+	}
+
+	if !resp.Correct {
+		wrong := recordWrongAnswer(play, quizID, step)
+
+		if q.Hint != "" && wrong >= q.HintAfter {
+			vars, err := quizTemplateVars(quizID, step.Section)
+			if err != nil {
+				return nil, err
+			}
+
+			resp.Hint = renderTemplate(q.Hint, vars)
+		}
+
+		if q.MaxAttempts == 0 || wrong < q.MaxAttempts {
+			return resp, nil
+		}
+
+		resp.Answer = q.correctAnswerText()
 	}
 
 	// last question of section 1
-	if section == '1' && questionIndex == 2 {
-		return genGoodQuestionResp(GetQuestionUsingPosition(2, 1, quizID))
+	if step.Section == 1 && questionIndex == 2 {
+		resp.Next, err = GetQuestionUsingPosition(2, 1, quizID, play)
+	} else {
+		resp.Next, err = GetQuestionUsingPosition(step.Section, (questionIndex+1)+1, quizID, play)
+	}
+
+	if err != nil {
+		return nil, err
 	}
 
-	return genGoodQuestionResp(GetQuestionUsingPosition(int(section-'0'), (questionIndex+1)+1, quizID))
+	return resp, nil
 }
 
-func answerSpecial(id string, answer string) (*QuestionResp, error) {
-	id = id[3:]
-	specialID := id[0]
-	quizID := id[2:]
+func answerSpecial(step StepID, play, answer string) (*QuestionResp, error) {
+	quizID := step.Quiz
+
+	if err := checkPlayable(quizID, play); err != nil {
+		return nil, err
+	}
 
 	// Answer -> 1|2 (!ok -> bad answer)
 	// 1 -> lead to section 3
 	// 2 -> lead to redirect
 	m := map[string]int{}
+	add := func(answer string, resp int) {
+		m[specialMatchPolicy.Normalize(answer)] = resp
+	}
+
 	redirect := ""
+	pronouns := []string{}
+
+	// Dead names are checked separately, since hash only quizzes only have their digests (see quizNames.isDeadName)
+	names := newQuizNames(quizID)
+	deadResp := 0
 
-	switch specialID {
-	case '2':
+	switch step.Section {
+	case 2:
 		// {deadname}
 		// {deadname} {deadlastname}
 		// {chosenname}
 		// {chosenname} {chosenlastname}
 
-		deadNames := []string{}
-		deadLastName := ""
-
-		chosenNames := []string{}
-		chosenLastName := ""
+		deadResp = 1
 
 		err := db.QueryRowID(
-			`SELECT deadname, deadlastname, chosenname, chosenlastname, redirect FROM quiz WHERE id = $1`,
+			`SELECT redirect, pronouns, `+quizNameColumns+` FROM quiz WHERE id = $1`,
 			quizID,
-
-			&deadNames,
-			&deadLastName,
-
-			&chosenNames,
-			&chosenLastName,
-
-			&redirect,
+			append([]any{&redirect, &pronouns}, names.scanTargets()...)...,
 		)
 
 		if err != nil {
-			if db.NoRows(err) {
-				return nil, ErrNotFound
-			}
-
-			return nil, ErrServerErr
+			return nil, ErrDBHandle(err)
 		}
 
-		for _, n := range deadNames {
-			n = strings.ToLower(n)
-
-			m[n] = 1
-			m[n+" "+deadLastName] = 1
+		if err := names.decrypt(); err != nil {
+			return nil, err
 		}
 
-		for _, n := range chosenNames {
-			n = strings.ToLower(n)
-
-			m[n] = 2
-			m[n+" "+chosenLastName] = 2
+		for _, n := range names.ChosenNames {
+			add(n, 2)
+			add(n+" "+names.ChosenLastName, 2)
 		}
-	case '3':
+	case 3:
 		// {deadname}
 		// {deadname} {deadlastname}
 		// {nickname}
 
-		deadNames := []string{}
-		deadLastName := ""
+		deadResp = 2
 		nickname := ""
 
 		err := db.QueryRowID(
-			`SELECT deadname, deadlastname, nickname, redirect FROM quiz WHERE id = $1`,
+			`SELECT nickname, redirect, pronouns, `+quizNameColumns+` FROM quiz WHERE id = $1`,
 			quizID,
-
-			&deadNames,
-			&deadLastName,
-
-			&nickname,
-			&redirect,
+			append([]any{&nickname, &redirect, &pronouns}, names.scanTargets()...)...,
 		)
 
 		if err != nil {
-			if db.NoRows(err) {
-				return nil, ErrNotFound
-			}
-
-			return nil, ErrServerErr
+			return nil, ErrDBHandle(err)
 		}
 
-		for _, n := range deadNames {
-			n = strings.ToLower(n)
-
-			m[n] = 2
-			m[n+" "+deadLastName] = 2
+		if err := names.decrypt(); err != nil {
+			return nil, err
 		}
 
-		m[nickname] = 2
+		add(nickname, 2)
 	}
 
-	if resp, ok := m[answer]; ok {
+	resp, ok := m[answer]
+	if !ok && deadResp != 0 && names.isDeadName(answer) {
+		resp, ok = deadResp, true
+	}
+
+	if ok {
 		if resp == 1 {
-			return genGoodQuestionResp(GetQuestionUsingPosition(3, 1, quizID))
+			return genGoodQuestionResp(GetQuestionUsingPosition(3, 1, quizID, play))
 		} else {
+			completePlay(play, quizID)
+
 			return &QuestionResp{
 				Correct:  true,
-				Redirect: redirect,
+				Redirect: quizRedirect(redirect, decodePronouns(pronouns)),
 			}, nil
 		}
 	}
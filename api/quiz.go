@@ -1,29 +1,63 @@
 package api
 
 import (
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/shadiestgoat/who/db"
 	"github.com/shadiestgoat/who/snownode"
+	"github.com/shadiestgoat/who/vault"
+)
+
+type QuizStatus string
+
+const (
+	// Only playable by the author, through author previews
+	QUIZ_DRAFT QuizStatus = "draft"
+	// Playable by anyone with the link
+	QUIZ_PUBLISHED QuizStatus = "published"
+	// Not playable anymore, apart from author previews
+	QUIZ_ARCHIVED QuizStatus = "archived"
 )
 
 type Quiz struct {
 	ID       string `json:"id"`
 	AuthorID string `json:"-"`
+	// Read only, see SetQuizStatus
+	Status QuizStatus `json:"status"`
+	// Read only, from the ID
+	CreatedAt time.Time `json:"createdAt"`
 
-	DeadNames    []string `json:"deadNames"`
-	DeadLastName string `json:"deadLastName"`
+	// A published quiz only goes live at PublishAt, and stops working (is archived) at ExpiresAt. Both are optional.
+	PublishAt *time.Time `json:"publishAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	// Redacted in responses, see RevealQuiz
+	DeadNames    []vault.Sensitive `json:"deadNames"`
+	DeadLastName vault.Sensitive   `json:"deadLastName"`
 
 	ChosenNames    []string  `json:"chosenNames"`
 	ChosenLastName string  `json:"chosenLastName"`
 
 	Nickname string  `json:"nickname"`
 
+	// If true, the dead names are not stored, only digests of the answers that use them (see quizNames).
+	// The dead names are then empty in responses, & don't have to be re-supplied on edits unless they change.
+	// Placeholders that would use them (see template.go) use the nickname instead.
+	HashOnly    bool `json:"hashOnly"`
+	deadDigests []string
+
 	// TODO: figure out json tags for these
 	Order        []string `json:"order"`
 	DropQuestion int 
 
+	// If empty, a pronouns.page link is generated from Pronouns
 	Redirect string  `json:"redirect"`
+
+	// Shown on the character sheet (card), after the quiz is completed
+	Pronouns    []PronounSet `json:"pronouns"`
+	CardMessage string       `json:"cardMessage"`
 }
 
 func verifyName(inp *string) error {
@@ -34,25 +68,61 @@ func verifyName(inp *string) error {
 	return nil
 }
 
+func verifyRedirect(inp *string) error {
+	if *inp == "" {
+		return nil
+	}
+
+	u, err := url.Parse(*inp)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrBadRedirect
+	}
+
+	return nil
+}
+
 // Sanitizes the quiz for step 1 (ie. creation). Does not sanitize or verify ID, AuthorID
 func (q *Quiz) Sanitize1() error {
+	deadNames, deadLastName := vault.RevealAll(q.DeadNames), q.DeadLastName.Reveal()
+	// Edits of hash only quizzes can keep the current digests, see EditQuiz
+	keepDigests := q.ID != "" && q.HashOnly && len(deadNames) == 0 && deadLastName == ""
+
 	errCombo := []error{
-		cleanString(&q.DeadLastName, 2, 33, "dead Last Name"),
 		cleanString(&q.ChosenLastName, -1, 33, "chosen Last Name"),
 		cleanString(&q.Nickname, 2, 33, "nickname"),
-		cleanString(&q.DeadLastName, 0, 33, "redirect"),
+		cleanString(&q.Redirect, -1, 257, "redirect"),
+		verifyRedirect(&q.Redirect),
+		cleanString(&q.CardMessage, -1, 513, "card message"),
 		verifyName(&q.ChosenLastName),
-		verifyName(&q.DeadLastName),
-		cleanStringArr(q.DeadNames, 2, 33, "dead Name", verifyName),
 		cleanStringArr(q.ChosenNames, 2, 33, "chosen Name", verifyName),
 	}
 
-	if len(q.DeadNames) == 0 || len(q.DeadNames) > 4 {
-		errCombo = append(errCombo, &HTTPError{
-			Msg:    "Need 1-4 dead names",
-			Status: 400,
-		})
+	if !keepDigests {
+		errCombo = append(errCombo,
+			cleanString(&deadLastName, 2, 33, "dead Last Name"),
+			verifyName(&deadLastName),
+			cleanStringArr(deadNames, 2, 33, "dead Name", verifyName),
+		)
+
+		if len(deadNames) == 0 || len(deadNames) > 4 {
+			errCombo = append(errCombo, &HTTPError{
+				Msg:    "Need 1-4 dead names",
+				Status: 400,
+			})
+		}
+	}
+
+	if q.HashOnly && !vault.DigestEnabled() {
+		errCombo = append(errCombo, ErrHashOnlyNotConfigured)
 	}
+
+	pronouns, err := sanitizePronouns(q.Pronouns)
+	if err != nil {
+		errCombo = append(errCombo, err)
+	} else {
+		q.Pronouns = pronouns
+	}
+
 	if len(q.ChosenNames) == 0 || len(q.ChosenNames) > 4 {
 		errCombo = append(errCombo, &HTTPError{
 			Msg:    "Need 1-4 chosen names",
@@ -60,6 +130,27 @@ func (q *Quiz) Sanitize1() error {
 		})
 	}
 
+	if q.ExpiresAt != nil {
+		if q.PublishAt != nil && !q.ExpiresAt.After(*q.PublishAt) {
+			errCombo = append(errCombo, &HTTPError{
+				Msg:    "The quiz has to expire after it's published",
+				Status: 400,
+			})
+		}
+
+		createdAt := time.Now()
+		if q.ID != "" {
+			createdAt = snownode.SnowToTime(q.ID)
+		}
+
+		if !q.ExpiresAt.After(createdAt) {
+			errCombo = append(errCombo, &HTTPError{
+				Msg:    "The quiz has to expire after it's created",
+				Status: 400,
+			})
+		}
+	}
+
 	if q.DropQuestion > 2 || q.DropQuestion < 0 {
 		errCombo = append(errCombo, &HTTPError{
 			Msg:    "Drop question out of bounds",
@@ -67,23 +158,34 @@ func (q *Quiz) Sanitize1() error {
 		})
 	}
 
-	err := newHTTPErrorStack(errCombo)
-	if err != nil {
+	if err := newHTTPErrorStack(errCombo); err != nil {
 		return err
 	}
+
+	q.DeadNames, q.DeadLastName = vault.WrapAll(deadNames), vault.Sensitive(deadLastName)
 	
 	if q.ChosenLastName == "" {
-		q.ChosenLastName = q.DeadLastName
+		q.ChosenLastName = deadLastName
 	}
 
 	return nil
 }
 
-func NewQuiz(q *Quiz, rqs []*Question) (*Quiz, error) {
+// If templateID is not empty, the questions are seeded from that template (see templates.go)
+func NewQuiz(q *Quiz, rqs []*FullQuestion, templateID string, by *Actor) (*Quiz, error) {
 	if err := q.Sanitize1(); err != nil {
 		return nil, err
 	}
 
+	if templateID != "" {
+		var err error
+
+		rqs, err = seedFromTemplate(templateID, rqs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if len(rqs) != 3 {
 		return nil, &HTTPError{
 			Msg:    "Need 3 questions",
@@ -92,81 +194,245 @@ func NewQuiz(q *Quiz, rqs []*Question) (*Quiz, error) {
 	}
 
 	q.ID = snownode.Generate()
-
-	// `id`, `quiz`,
-	// `is_multiple_choice`,
-	// `answers`,
-	// `content`,
-	questions := [][]any{}
+	q.Status = QUIZ_DRAFT
+	q.CreatedAt = snownode.SnowToTime(q.ID)
+	q.Order = []string{}
 
 	for _, question := range rqs {
 		if err := question.Sanitize(); err != nil {
 			return nil, err
 		}
+		// media can only be uploaded once the quiz exists
+		if err := checkMedia(q.ID, question.Media); err != nil {
+			return nil, err
+		}
 		question.ID = snownode.Generate()
-		questions = append(questions, []any{
-			question, q.ID,
-			question.IsMultipleChoice,
-			question.Answers,
-			question.Content,
-		})
+		q.Order = append(q.Order, question.ID)
+	}
+
+	if err := insertQuiz(q, rqs); err != nil {
+		return nil, err
+	}
+
+	audit(q.AuthorID, by, AUDIT_QUIZ_CREATE, q.ID, auditQuizDiff(nil, q))
+
+	return q, nil
+}
+
+// Inserts an already sanitized quiz & its questions
+func insertQuiz(q *Quiz, questions []*FullQuestion) error {
+	names, err := q.names().values()
+	if err != nil {
+		return err
 	}
 
-	db.InsertOne(`quiz`, []string{
-		`id`, `author`,
+	_, err = db.InsertOne(`quiz`, []string{
+		`id`, `author`, `status`,
+		`publish_at`, `expires_at`,
 		`deadname`, `deadlastname`,
 		`chosenname`, `chosenlastname`,
+		`enc_key`, `enc_dek`,
+		`hash_only`, `dead_digests`,
 		`nickname`,
 		`order`, `drop_question`,
 		`redirect`,
+		`pronouns`, `card_message`,
 	},
-		q.ID, q.AuthorID,
-		q.DeadNames, q.DeadLastName,
-		q.ChosenNames, q.ChosenLastName,
+		q.ID, q.AuthorID, q.Status,
+		q.PublishAt, q.ExpiresAt,
+		names[0], names[1],
+		names[2], names[3],
+		names[4], names[5],
+		names[6], names[7],
 		q.Nickname,
 		q.Order, q.DropQuestion,
 		q.Redirect,
+		encodePronouns(q.Pronouns), q.CardMessage,
 	)
 
-	db.Insert(`questions`, []string{
-		`id`, `quiz`,
-		`is_multiple_choice`,
-		`answers`,
-		`content`,
-	}, questions)
+	if err != nil {
+		return ErrDBHandle(err)
+	}
 
-	return q, nil
+	rows := [][]any{}
+
+	for _, question := range questions {
+		rows = append(rows, question.insertRow(q.ID))
+	}
+
+	_, err = db.Insert(`questions`, questionInsertColumns, rows)
+
+	if err != nil {
+		db.Exec(`DELETE FROM quiz WHERE id = $1`, q.ID)
+		return ErrDBHandle(err)
+	}
+
+	return nil
 }
 
 // Note: use with POST, it overrides everything!
-func EditQuiz(q *Quiz) (*Quiz, error) {
+func EditQuiz(q *Quiz, by *Actor) (*Quiz, error) {
 	if err := q.Sanitize1(); err != nil {
 		return nil, err
 	}
 
-	_, err := db.Exec(`UPDATE quiz SET deadname = $1, deadlastname = $2, chosenname = $3, chosenlastname = $4, nickname = $5, order = $6, drop_question = $7, redirect = $8 WHERE id = $9`,
-		q.DeadNames, q.DeadLastName, q.ChosenNames, q.ChosenLastName, q.Nickname, q.Order, q.DropQuestion, q.Redirect, q.ID,
+	old, err := GetQuiz(q.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read only fields
+	q.AuthorID, q.Status, q.CreatedAt = old.AuthorID, old.Status, old.CreatedAt
+
+	// The dead names weren't re-supplied, keep the current digests
+	if q.HashOnly && len(q.DeadNames) == 0 {
+		wasHashOnly := false
+
+		err := db.QueryRowID(`SELECT hash_only, dead_digests FROM quiz WHERE id = $1`, q.ID, &wasHashOnly, &q.deadDigests)
+		if err != nil {
+			return nil, ErrDBHandle(err)
+		}
+
+		if !wasHashOnly {
+			return nil, &HTTPError{
+				Msg:    "Need 1-4 dead names",
+				Status: 400,
+			}
+		}
+	}
+
+	names, err := q.names().values()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`UPDATE quiz SET deadname = $1, deadlastname = $2, chosenname = $3, chosenlastname = $4, enc_key = $5, enc_dek = $6, hash_only = $7, dead_digests = $8, nickname = $9, "order" = $10, drop_question = $11, redirect = $12, pronouns = $13, card_message = $14, publish_at = $15, expires_at = $16 WHERE id = $17`,
+		names[0], names[1], names[2], names[3], names[4], names[5], names[6], names[7], q.Nickname, q.Order, q.DropQuestion, q.Redirect, encodePronouns(q.Pronouns), q.CardMessage, q.PublishAt, q.ExpiresAt, q.ID,
 	)
 
 	if err != nil {
 		return nil, ErrDBHandle(err)
 	}
 
+	audit(old.AuthorID, by, AUDIT_QUIZ_EDIT, q.ID, auditQuizDiff(old, q))
+
+	return q, nil
+}
+
+// A quiz is live if it's published, and it's between publish_at & expires_at
+const quizLiveCondition = `status = 'published' AND (publish_at IS NULL OR publish_at <= now()) AND (expires_at IS NULL OR expires_at > now())`
+
+func isQuizLive(id string) bool {
+	return db.Exists(`quiz`, `id = $1 AND `+quizLiveCondition, id)
+}
+
+func SetQuizStatus(id string, status QuizStatus, by *Actor) (*Quiz, error) {
+	old, err := GetQuiz(id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`UPDATE quiz SET status = $1 WHERE id = $2`, status, id)
+
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	q, err := GetQuiz(id)
+	if err != nil {
+		return nil, err
+	}
+
+	audit(q.AuthorID, by, AUDIT_QUIZ_EDIT, id, auditQuizDiff(old, q))
+
 	return q, nil
 }
 
-func DeleteQuiz(id string) (*Quiz, error) {
+func DeleteQuiz(id string, by *Actor) (*Quiz, error) {
 	q, err := GetQuiz(id)
 	if err != nil {
 		return nil, err
 	}
 
+	deleteQuizMediaBlobs(id)
+
 	_, err = db.Exec(`DELETE FROM quiz WHERE id = $1`, id)
 
 	if err != nil {
 		return nil, ErrServerErr
 	}
 
+	audit(q.AuthorID, by, AUDIT_QUIZ_DELETE, id, auditQuizDiff(q, nil))
+
+	return q, nil
+}
+
+// Creates a copy of the quiz, its questions & media. Play sessions & such are not copied.
+// Hash only quizzes can't be copied, since their digests are bound to the quiz (see quizNames.isDeadName).
+func DuplicateQuiz(id string, by *Actor) (*Quiz, error) {
+	q, err := GetQuiz(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.HashOnly {
+		return nil, ErrHashOnlyDuplicate
+	}
+
+	questions, err := GetQuestions(id)
+	if err != nil {
+		return nil, err
+	}
+
+	media, err := GetQuizMedia(id)
+	if err != nil {
+		return nil, err
+	}
+
+	q.ID = snownode.Generate()
+	q.Status = QUIZ_DRAFT
+	q.CreatedAt = snownode.SnowToTime(q.ID)
+	// The schedule was for the original, a copy of an expired quiz would be dead on arrival
+	q.PublishAt = nil
+	q.ExpiresAt = nil
+
+	mediaIDs := map[string]string{}
+	for _, m := range media {
+		mediaIDs[m.ID] = snownode.Generate()
+	}
+
+	questionIDs := map[string]string{}
+	newQuestions := []*FullQuestion{}
+
+	for _, question := range questions {
+		if question == nil {
+			continue
+		}
+
+		questionIDs[question.ID] = snownode.Generate()
+
+		question.ID = questionIDs[question.ID]
+		question.Media = mediaIDs[question.Media]
+
+		newQuestions = append(newQuestions, question)
+	}
+
+	for i, o := range q.Order {
+		q.Order[i] = questionIDs[o]
+	}
+
+	if err := insertQuiz(q, newQuestions); err != nil {
+		return nil, err
+	}
+
+	for _, m := range media {
+		if err := copyMedia(m, q.ID, mediaIDs[m.ID]); err != nil {
+			db.Exec(`UPDATE questions SET media = '' WHERE media = $1`, mediaIDs[m.ID])
+		}
+	}
+
+	audit(q.AuthorID, by, AUDIT_QUIZ_CREATE, q.ID, auditQuizDiff(nil, q))
+
 	return q, nil
 }
 
@@ -174,7 +440,11 @@ func GetQuiz(id string) (*Quiz, error) {
 	q := &Quiz{
 		ID:             id,
 		AuthorID:       "",
-		DeadNames:      []string{},
+		Status:         QUIZ_DRAFT,
+		CreatedAt:      snownode.SnowToTime(id),
+		PublishAt:      nil,
+		ExpiresAt:      nil,
+		DeadNames:      []vault.Sensitive{},
 		DeadLastName:   "",
 		ChosenNames:    []string{},
 		ChosenLastName: "",
@@ -182,21 +452,103 @@ func GetQuiz(id string) (*Quiz, error) {
 		Order:          []string{},
 		DropQuestion:   0,
 		Redirect:       "",
+		Pronouns:       []PronounSet{},
+		CardMessage:    "",
 	}
 
+	pronouns := []string{}
+	names := newQuizNames(id)
+
 	err := db.QueryRowID(
-		`SELECT author, deadname, deadlastname, chosenname, chosenlastname, nickname, order, drop_question, redirect WHERE id = $1`,
+		`SELECT author, status, publish_at, expires_at, nickname, "order", drop_question, redirect, pronouns, card_message, `+quizNameColumns+` FROM quiz WHERE id = $1`,
 		id,
-		&q.AuthorID, &q.DeadNames, &q.DeadLastName, &q.ChosenNames, &q.ChosenLastName, &q.Nickname, &q.Order, &q.DropQuestion, &q.Redirect,
+		append([]any{&q.AuthorID, &q.Status, &q.PublishAt, &q.ExpiresAt, &q.Nickname, &q.Order, &q.DropQuestion, &q.Redirect, &pronouns, &q.CardMessage}, names.scanTargets()...)...,
 	)
 
 	if err != nil {
 		return nil, ErrDBHandle(err)
 	}
 
+	if err := names.decrypt(); err != nil {
+		return nil, err
+	}
+
+	q.DeadNames, q.DeadLastName = names.DeadNames, names.DeadLastName
+	q.HashOnly, q.deadDigests = names.HashOnly, names.DeadDigests
+	q.ChosenNames, q.ChosenLastName = names.ChosenNames, names.ChosenLastName
+	q.Pronouns = decodePronouns(pronouns)
+
 	return q, nil
 }
 
-func GetQuizFirstQuestion(id string) (*Question, error) {
-	return GetQuestionUsingPosition(1, 1, id)
+// The quiz as seen by its author, with the dead names revealed. Only for author only responses!
+type RevealedQuiz struct {
+	*Quiz
+	DeadNames    []string `json:"deadNames"`
+	DeadLastName string   `json:"deadLastName"`
+}
+
+// Intended for wrapping calls, ie. RevealQuiz(GetQuiz(id))
+func RevealQuiz(q *Quiz, err error) (*RevealedQuiz, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &RevealedQuiz{
+		Quiz:         q,
+		DeadNames:    vault.RevealAll(q.DeadNames),
+		DeadLastName: q.DeadLastName.Reveal(),
+	}, nil
+}
+
+func GetQuizFirstQuestion(id, play string) (*Question, error) {
+	return GetQuestionUsingPosition(1, 1, id, play)
+}
+
+// The character sheet, shown after the quiz is completed
+type Card struct {
+	ChosenNames    []string     `json:"chosenNames"`
+	ChosenLastName string       `json:"chosenLastName"`
+	Pronouns       []PronounSet `json:"pronouns"`
+	Message        string       `json:"message"`
+
+	Redirect string `json:"redirect"`
+}
+
+// Get the card of a quiz. Only available to completed play sessions.
+func GetCard(quizID, play string) (*Card, error) {
+	if err := checkPlayCompleted(play, quizID); err != nil {
+		return nil, err
+	}
+
+	c := &Card{
+		ChosenNames:    []string{},
+		ChosenLastName: "",
+		Pronouns:       []PronounSet{},
+		Message:        "",
+		Redirect:       "",
+	}
+
+	pronouns := []string{}
+	names := newQuizNames(quizID)
+
+	err := db.QueryRowID(
+		`SELECT pronouns, card_message, redirect, `+quizNameColumns+` FROM quiz WHERE id = $1`,
+		quizID,
+		append([]any{&pronouns, &c.Message, &c.Redirect}, names.scanTargets()...)...,
+	)
+
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	if err := names.decrypt(); err != nil {
+		return nil, err
+	}
+
+	c.ChosenNames, c.ChosenLastName = names.ChosenNames, names.ChosenLastName
+	c.Pronouns = decodePronouns(pronouns)
+	c.Redirect = quizRedirect(c.Redirect, c.Pronouns)
+
+	return c, nil
 }
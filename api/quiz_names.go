@@ -0,0 +1,247 @@
+package api
+
+import (
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/vault"
+)
+
+// The name columns of a quiz are encrypted at rest, using an envelope per quiz (see vault).
+// Always go through quizNames when reading or writing deadname, deadlastname, chosenname & chosenlastname.
+
+type quizNames struct {
+	DeadNames    []vault.Sensitive
+	DeadLastName vault.Sensitive
+
+	ChosenNames    []string
+	ChosenLastName string
+
+	// If true, the dead names are not stored. Only the digests of the accepted answers that use them are (see vault.Digest).
+	HashOnly    bool
+	DeadDigests []string
+
+	// The encrypted values, as scanned
+	deadNames    []string
+	deadLastName string
+
+	// The values are bound to the quiz, see vault.Envelope.Encrypt
+	quizID     string
+	keyID      string
+	wrappedKey []byte
+}
+
+// Same order as scanTargets & values
+const quizNameColumns = `deadname, deadlastname, chosenname, chosenlastname, enc_key, enc_dek, hash_only, dead_digests`
+
+func newQuizNames(quizID string) *quizNames {
+	return &quizNames{
+		quizID:      quizID,
+		DeadNames:   []vault.Sensitive{},
+		ChosenNames: []string{},
+		DeadDigests: []string{},
+	}
+}
+
+func (n *quizNames) scanTargets() []any {
+	return []any{&n.deadNames, &n.deadLastName, &n.ChosenNames, &n.ChosenLastName, &n.keyID, &n.wrappedKey, &n.HashOnly, &n.DeadDigests}
+}
+
+// Decrypts the names in place, after scanning
+func (n *quizNames) decrypt() error {
+	env, err := vault.OpenEnvelope(n.keyID, n.wrappedKey)
+	if log.ErrorIfErr(err, "opening the names envelope") {
+		return ErrServerErr
+	}
+
+	deadNames, err1 := env.DecryptAll(n.deadNames, n.quizID+"/deadname")
+	deadLastName, err2 := env.Decrypt(n.deadLastName, n.quizID+"/deadlastname")
+	chosenNames, err3 := env.DecryptAll(n.ChosenNames, n.quizID+"/chosenname")
+	chosenLastName, err4 := env.Decrypt(n.ChosenLastName, n.quizID+"/chosenlastname")
+
+	for _, err := range []error{err1, err2, err3, err4} {
+		if log.ErrorIfErr(err, "decrypting names") {
+			return ErrServerErr
+		}
+	}
+
+	n.DeadNames, n.DeadLastName = vault.WrapAll(deadNames), vault.Sensitive(deadLastName)
+	n.ChosenNames, n.ChosenLastName = chosenNames, chosenLastName
+	n.deadNames, n.deadLastName = nil, ""
+	n.keyID, n.wrappedKey = "", nil
+
+	return nil
+}
+
+// What is digested for an answer. Bound to the quiz, so the same dead name has different digests in different quizzes.
+func deadNameDigestInput(quizID, answer string) string {
+	return quizID + "\x00" + answer
+}
+
+// The digests of the normalized answers that use dead names, see answerSpecial
+func deadNameDigests(quizID string, deadNames []vault.Sensitive, deadLastName vault.Sensitive) ([]string, error) {
+	digests := []string{}
+
+	for _, n := range deadNames {
+		for _, answer := range []string{n.Reveal(), n.Reveal() + " " + deadLastName.Reveal()} {
+			d, err := vault.Digest(deadNameDigestInput(quizID, specialMatchPolicy.Normalize(answer)))
+			if err != nil {
+				return nil, err
+			}
+
+			digests = append(digests, d)
+		}
+	}
+
+	return digests, nil
+}
+
+// Checks a normalized answer against the dead names, or their digests for hash only quizzes
+func (n *quizNames) isDeadName(answer string) bool {
+	if n.HashOnly {
+		return vault.MatchesDigest(deadNameDigestInput(n.quizID, answer), n.DeadDigests)
+	}
+
+	for _, d := range n.DeadNames {
+		if answer == specialMatchPolicy.Normalize(d.Reveal()) || answer == specialMatchPolicy.Normalize(d.Reveal()+" "+n.DeadLastName.Reveal()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Encrypts the names with a new envelope, returning the values to store (same order as quizNameColumns).
+// For hash only quizzes, the digests are re-computed if the dead names are known, otherwise the current digests are kept.
+func (n *quizNames) values() ([]any, error) {
+	env, err := vault.NewEnvelope()
+	if log.ErrorIfErr(err, "creating a names envelope") {
+		return nil, ErrServerErr
+	}
+
+	storedDeadNames, storedDeadLastName := n.DeadNames, n.DeadLastName
+	digests := []string{}
+
+	if n.HashOnly {
+		digests = n.DeadDigests
+
+		if len(n.DeadNames) != 0 {
+			digests, err = deadNameDigests(n.quizID, n.DeadNames, n.DeadLastName)
+			if log.ErrorIfErr(err, "computing dead name digests") {
+				return nil, ErrServerErr
+			}
+		}
+
+		storedDeadNames, storedDeadLastName = []vault.Sensitive{}, ""
+	}
+
+	deadNames, err1 := env.EncryptAll(vault.RevealAll(storedDeadNames), n.quizID+"/deadname")
+	deadLastName, err2 := env.Encrypt(storedDeadLastName.Reveal(), n.quizID+"/deadlastname")
+	chosenNames, err3 := env.EncryptAll(n.ChosenNames, n.quizID+"/chosenname")
+	chosenLastName, err4 := env.Encrypt(n.ChosenLastName, n.quizID+"/chosenlastname")
+
+	for _, err := range []error{err1, err2, err3, err4} {
+		if log.ErrorIfErr(err, "encrypting names") {
+			return nil, ErrServerErr
+		}
+	}
+
+	return []any{deadNames, deadLastName, chosenNames, chosenLastName, env.KeyID, env.WrappedKey, n.HashOnly, digests}, nil
+}
+
+func (q *Quiz) names() *quizNames {
+	return &quizNames{
+		quizID:         q.ID,
+		DeadNames:      q.DeadNames,
+		DeadLastName:   q.DeadLastName,
+		ChosenNames:    q.ChosenNames,
+		ChosenLastName: q.ChosenLastName,
+		HashOnly:       q.HashOnly,
+		DeadDigests:    q.deadDigests,
+	}
+}
+
+func getQuizNames(quizID string) (*quizNames, error) {
+	n := newQuizNames(quizID)
+
+	err := db.QueryRowID(`SELECT `+quizNameColumns+` FROM quiz WHERE id = $1`, quizID, n.scanTargets()...)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	return n, n.decrypt()
+}
+
+// The 1st chosen name of the quiz
+func GetChosenName(quizID string) (string, error) {
+	n, err := getQuizNames(quizID)
+	if err != nil {
+		return "", err
+	}
+
+	if len(n.ChosenNames) == 0 {
+		return "", nil
+	}
+
+	return n.ChosenNames[0], nil
+}
+
+// Re-encrypts the names of every quiz that isn't using the current key, or every quiz if all is true.
+// Returns the amount of re-encrypted quizzes.
+func RotateQuizKeys(all bool) (int, error) {
+	rows, err := db.Query(`SELECT id, `+quizNameColumns+` FROM quiz WHERE $1 OR enc_key != $2`, all, vault.CurrentKeyID())
+	if err != nil {
+		return 0, err
+	}
+
+	ids := []string{}
+	names := []*quizNames{}
+
+	for rows.Next() {
+		id := ""
+		n := newQuizNames("")
+
+		if err := rows.Scan(append([]any{&id}, n.scanTargets()...)...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		n.quizID = id
+		ids = append(ids, id)
+		names = append(names, n)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for i, n := range names {
+		// Skip the row if it was edited since it was read
+		oldKeyID, oldWrappedKey := n.keyID, n.wrappedKey
+
+		if err := n.decrypt(); err != nil {
+			return count, err
+		}
+
+		values, err := n.values()
+		if err != nil {
+			return count, err
+		}
+
+		tag, err := db.Exec(
+			`UPDATE quiz SET deadname = $1, deadlastname = $2, chosenname = $3, chosenlastname = $4, enc_key = $5, enc_dek = $6, hash_only = $7, dead_digests = $8 `+
+				`WHERE id = $9 AND enc_key = $10 AND enc_dek IS NOT DISTINCT FROM $11`,
+			append(values, ids[i], oldKeyID, oldWrappedKey)...,
+		)
+		if err != nil {
+			return count, err
+		}
+
+		count += int(tag.RowsAffected())
+	}
+
+	return count, nil
+}
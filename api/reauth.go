@@ -0,0 +1,126 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/db"
+)
+
+// Re-authentication for sensitive changes (erasure, 2FA changes, registering passkeys), see checkPassword.
+//
+// Users with a password just give it again. Users without one (see newOIDCUser) sign in again instead,
+// either with a passkey (see StartPasskeyReauth) or at an identity provider they linked (see StartOIDC).
+// That gives them a ReauthGrant, a short lived & single use token that they pass on instead of the password.
+
+type Reauth struct {
+	Password string `json:"password"`
+	// Only needed if 2FA is on
+	Code string `json:"code"`
+	// For users without a password, see ReauthGrant
+	Token string `json:"reauthToken"`
+}
+
+// Proof of having just signed in again, for users without a password
+type ReauthGrant struct {
+	Token     string    `json:"reauthToken"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+const (
+	reauthTTL = 5 * time.Minute
+	// How long ago the user can have signed in at the identity provider, see oidc.Session.MaxAge
+	reauthMaxAge = 5 * time.Minute
+)
+
+func reauthTokenHash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func newReauthGrant(userID string) (*ReauthGrant, error) {
+	b, err := generateRandomBytes(32)
+	if log.ErrorIfErr(err, "generating reauth token") {
+		return nil, ErrServerErr
+	}
+
+	g := &ReauthGrant{
+		Token:     base64.RawURLEncoding.EncodeToString(b),
+		ExpiresAt: time.Now().Add(reauthTTL),
+	}
+
+	_, err = db.InsertOne(`reauth_tokens`, []string{`token_hash`, `user_id`, `expires_at`}, reauthTokenHash(g.Token), userID, g.ExpiresAt)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	return g, nil
+}
+
+func useReauthToken(userID, token string) error {
+	if token == "" {
+		return ErrReauthRequired
+	}
+
+	tag, err := db.Exec(`DELETE FROM reauth_tokens WHERE token_hash = $1 AND user_id = $2 AND expires_at > now()`, reauthTokenHash(token), userID)
+	if err != nil {
+		return ErrDBHandle(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrReauthRequired
+	}
+
+	return nil
+}
+
+// Checks the password, or the reauth token for users without a password. Doesn't check the code, see reauthenticate.
+func checkPassword(userID string, r *Reauth) error {
+	if r == nil {
+		r = &Reauth{}
+	}
+
+	hash := ""
+	hasPassword := false
+
+	err := db.QueryRowID(`SELECT password, has_password FROM ppl WHERE id = $1`, userID, &hash, &hasPassword)
+	if err != nil {
+		return ErrDBHandle(err)
+	}
+
+	if !hasPassword {
+		return useReauthToken(userID, r.Token)
+	}
+
+	match, err := comparePasswordAndHash(r.Password, hash)
+	if log.ErrorIfErr(err, "comparePasswordAndHash") {
+		return ErrServerErr
+	}
+	if !match {
+		return ErrNoAuth
+	}
+
+	return nil
+}
+
+// Re-authentication, & a code if 2FA is on
+func reauthenticate(userID string, r *Reauth) error {
+	if err := checkPassword(userID, r); err != nil {
+		return err
+	}
+
+	totpEnabled := false
+
+	err := db.QueryRowID(`SELECT totp_enabled FROM ppl WHERE id = $1`, userID, &totpEnabled)
+	if err != nil {
+		return ErrDBHandle(err)
+	}
+
+	if totpEnabled {
+		return checkSecondFactor(userID, r.Code)
+	}
+
+	return nil
+}
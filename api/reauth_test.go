@@ -0,0 +1,128 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/webauthn/webauthntest"
+)
+
+// Makes the user like one that signed up through an identity provider
+func dropPassword(t *testing.T, userID string) {
+	t.Helper()
+
+	if _, err := db.Exec(`UPDATE ppl SET has_password = false WHERE id = $1`, userID); err != nil {
+		t.Fatalf("dropping the password: %v", err)
+	}
+}
+
+func passkeyReauth(t *testing.T, a *webauthntest.Authenticator, userID string) (*ReauthGrant, error) {
+	t.Helper()
+
+	opts, err := StartPasskeyReauth(userID)
+	if err != nil {
+		t.Fatalf("starting the re-authentication: %v", err)
+	}
+
+	if len(opts.AllowCredentials) != 1 || opts.AllowCredentials[0].ID != b64(a.CredentialID) {
+		t.Errorf("unexpected allowed credentials: %+v", opts.AllowCredentials)
+	}
+
+	a.SignCount++
+
+	return FinishPasskeyReauth(userID, assertionCredential(a, opts.Challenge, userID), nil)
+}
+
+func TestReauthWithoutPassword(t *testing.T) {
+	needDB(t)
+	withWebAuthn(t)
+
+	a := webauthntest.New(testRPID, testOrigin)
+	userID := newTestPasskey(t, a)
+
+	dropPassword(t, userID)
+
+	for name, r := range map[string]*Reauth{
+		"no reauth":       nil,
+		"a password":      {Password: "a password"},
+		"a made up token": {Token: "bm90IGEgdG9rZW4"},
+	} {
+		_, err := StartPasskeyRegistration(userID, r)
+		assertHTTPError(t, name, err, ErrReauthRequired)
+	}
+
+	grant, err := passkeyReauth(t, a, userID)
+	if err != nil {
+		t.Fatalf("re-authenticating: %v", err)
+	}
+
+	if _, err := StartPasskeyRegistration(userID, &Reauth{Token: grant.Token}); err != nil {
+		t.Fatalf("registering with the reauth token: %v", err)
+	}
+
+	_, err = StartPasskeyRegistration(userID, &Reauth{Token: grant.Token})
+	assertHTTPError(t, "a reused reauth token", err, ErrReauthRequired)
+
+	// Tokens are only good for the user they were given to
+	otherID, _ := newTestUser(t)
+	dropPassword(t, otherID)
+
+	grant, err = passkeyReauth(t, a, userID)
+	if err != nil {
+		t.Fatalf("re-authenticating: %v", err)
+	}
+
+	_, err = RequestErasure(otherID, &Reauth{Token: grant.Token})
+	assertHTTPError(t, "another user's reauth token", err, ErrReauthRequired)
+}
+
+func TestReauthWithPassword(t *testing.T) {
+	needDB(t)
+	withWebAuthn(t)
+
+	a := webauthntest.New(testRPID, testOrigin)
+	userID := newTestPasskey(t, a)
+
+	// Users with a password have to give it, a passkey isn't enough
+	grant, err := passkeyReauth(t, a, userID)
+	if err != nil {
+		t.Fatalf("re-authenticating: %v", err)
+	}
+
+	_, err = StartPasskeyRegistration(userID, &Reauth{Token: grant.Token})
+	assertHTTPError(t, "a reauth token instead of the password", err, ErrNoAuth)
+}
+
+func TestPasskeyReauthOtherUser(t *testing.T) {
+	needDB(t)
+	withWebAuthn(t)
+
+	a := webauthntest.New(testRPID, testOrigin)
+	userID := newTestPasskey(t, a)
+
+	other := webauthntest.New(testRPID, testOrigin)
+	otherID := newTestPasskey(t, other)
+	dropPassword(t, otherID)
+
+	opts, err := StartPasskeyReauth(otherID)
+	if err != nil {
+		t.Fatalf("starting the re-authentication: %v", err)
+	}
+
+	// Signed with a passkey of another user
+	a.SignCount++
+
+	_, err = FinishPasskeyReauth(otherID, assertionCredential(a, opts.Challenge, userID), nil)
+	assertHTTPError(t, "another user's passkey", err, ErrBadPasskey)
+
+	// A login challenge can't be used to re-authenticate
+	login, err := StartPasskeyLogin()
+	if err != nil {
+		t.Fatalf("starting the login: %v", err)
+	}
+
+	other.SignCount++
+
+	_, err = FinishPasskeyReauth(otherID, assertionCredential(other, login.Challenge, otherID), nil)
+	assertHTTPError(t, "a login challenge", err, ErrChallengeExpired)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/snownode"
+)
+
+// Old play data is purged by the sweeper (see StartSweeper), based on a retention policy.
+// Rows are found by the time in their snowflake ID, so no extra timestamp columns are needed.
+
+// The amount of days each kind of data is kept for. 0 keeps it forever.
+type RetentionPolicy struct {
+	// Deleting a play session also deletes its attempts
+	Plays int
+	// The per question attempts of play sessions (ie. the analytics), by the time their play session started
+	Attempts int
+	Messages int
+	// Completed erasures, see erasure.go
+	Erasures int
+}
+
+// The advisory lock that makes sure that only 1 replica sweeps at a time
+const sweeperLockKey = 0x77686f5f7377 // "who_sw"
+
+// Reads RETENTION_{PLAYS|ATTEMPTS|MESSAGES|ERASURES}_DAYS, missing values keep data forever
+func RetentionFromEnv() *RetentionPolicy {
+	days := func(kind string) int {
+		key := "RETENTION_" + kind + "_DAYS"
+
+		v := strings.TrimSpace(os.Getenv(key))
+		if v == "" {
+			return 0
+		}
+
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 {
+			log.Fatal("%s has to be a positive amount of days", key)
+		}
+
+		return d
+	}
+
+	return &RetentionPolicy{
+		Plays:    days("PLAYS"),
+		Attempts: days("ATTEMPTS"),
+		Messages: days("MESSAGES"),
+		Erasures: days("ERASURES"),
+	}
+}
+
+// Purges data that is older than the policy allows, on 1 replica at a time
+func StartSweeper(interval time.Duration, policy *RetentionPolicy) (stop func()) {
+	return runEvery(interval, func() {
+		db.WithAdvisoryLock(sweeperLockKey, policy.sweep)
+	})
+}
+
+// The smallest snowflake ID that is still within the retention period, as a number for the id::BIGINT indexes
+func retentionCutoff(days int) int64 {
+	id, _ := strconv.ParseInt(snownode.TimeToSnow(time.Now().AddDate(0, 0, -days)), 10, 64)
+	return id
+}
+
+func (p *RetentionPolicy) sweep() {
+	purge := func(kind string, days int, sql string) {
+		if days <= 0 {
+			return
+		}
+
+		tag, err := db.Exec(sql, retentionCutoff(days))
+		if err != nil {
+			return
+		}
+
+		if tag.RowsAffected() != 0 {
+			log.Debug("Purged %d %s older than %d days", tag.RowsAffected(), kind, days)
+		}
+	}
+
+	purge("attempts", p.Attempts, `DELETE FROM attempts USING plays WHERE attempts.play = plays.id AND plays.id::BIGINT < $1`)
+	purge("plays", p.Plays, `DELETE FROM plays WHERE id::BIGINT < $1`)
+	purge("messages", p.Messages, `DELETE FROM messages WHERE id::BIGINT < $1`)
+	purge("erasures", p.Erasures, `DELETE FROM erasures WHERE completed_at IS NOT NULL AND id::BIGINT < $1`)
+
+	// Not configurable, they are useless once expired
+	db.Exec(`DELETE FROM auth_challenges WHERE expires_at <= now()`)
+	db.Exec(`DELETE FROM webauthn_challenges WHERE expires_at <= now()`)
+	db.Exec(`DELETE FROM oidc_states WHERE expires_at <= now()`)
+	db.Exec(`DELETE FROM reauth_tokens WHERE expires_at <= now()`)
+}
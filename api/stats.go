@@ -0,0 +1,83 @@
+package api
+
+import (
+	"time"
+
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/snownode"
+)
+
+// Play session stats of a quiz, for the author. Author previews are not counted.
+
+type InviteStats struct {
+	Invite    *Invite `json:"invite"`
+	Opened    int     `json:"opened"`
+	Completed int     `json:"completed"`
+	// nil if the invite was never opened
+	LastOpened *time.Time `json:"lastOpened"`
+}
+
+type QuizStats struct {
+	Opened    int            `json:"opened"`
+	Completed int            `json:"completed"`
+	Invites   []*InviteStats `json:"invites"`
+}
+
+// Admin only!
+func GetQuizStats(quizID string) (*QuizStats, error) {
+	stats := &QuizStats{
+		Opened:    0,
+		Completed: 0,
+		Invites:   []*InviteStats{},
+	}
+
+	err := db.QueryRowID(
+		`SELECT COUNT(*), COUNT(*) FILTER (WHERE completed) FROM plays WHERE quiz = $1 AND NOT is_preview`,
+		quizID,
+		&stats.Opened, &stats.Completed,
+	)
+
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	rows, err := db.Query(
+		`SELECT invites.id, invites.name, invites.token, invites.revoked, `+
+			`COUNT(plays.id), COUNT(plays.id) FILTER (WHERE plays.completed), COALESCE(MAX(plays.id::BIGINT)::TEXT, '') `+
+			`FROM invites LEFT JOIN plays ON plays.invite = invites.id AND NOT plays.is_preview `+
+			`WHERE invites.quiz = $1 GROUP BY invites.id ORDER BY invites.id`,
+		quizID,
+	)
+
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		inv := &Invite{
+			Quiz: quizID,
+		}
+		s := &InviteStats{
+			Invite: inv,
+		}
+		lastPlay := ""
+
+		err := rows.Scan(&inv.ID, &inv.Name, &inv.Token, &inv.Revoked, &s.Opened, &s.Completed, &lastPlay)
+		if err != nil {
+			return nil, ErrDBHandle(err)
+		}
+
+		inv.URL = inviteURL(quizID, inv.Token)
+
+		if lastPlay != "" {
+			t := snownode.SnowToTime(lastPlay)
+			s.LastOpened = &t
+		}
+
+		stats.Invites = append(stats.Invites, s)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"strings"
+)
+
+// Notice about step IDs:
+// There are 2 types of steps: user created questions (the manual ones) and the special ones (automatic)
+//
+// Normal questions are the ones created by the user, ie. the ones that go into the questions table
+// Their step ID is {section:1|2|3}-{questionID}
+//
+// There are 2 special questions per quiz: 3d question of section 2 & 3.
+// 3-2 (q3s2): What is another name for {nickname}
+// Accepted answers are {deadname}, {deadname} {deadlastname}, {chosenname} {chosenlastname}
+// (note: {deadname} and {chosenname} are arrays, combinations apply to all items in the array)
+// If {chosenname} is in the answer, the response should be the 'redirect', otherwise go to 1-3
+// 3-3: What is another name for {chosenname}
+// Accepted answers are {deadname}, {deadname} {deadlastname}, {nickname}
+// Their step ID is {section:2|3}-sp
+//
+// Steps are always scoped to a quiz (/quizzes/{quiz}/steps/{step}), so the quiz ID is not part of the step ID.
+//
+// Legacy formats are still accepted when parsing:
+// - {questionID}{section:1|2|3}
+// - sp-{2|3}-{quizID}
+
+const stepSpecial = "sp"
+
+type StepID struct {
+	Section int
+	// Empty for special steps
+	Question string
+	// Only known if the step was parsed from a legacy special ID, or after being scoped
+	Quiz string
+}
+
+func (s StepID) IsSpecial() bool {
+	return s.Question == ""
+}
+
+func (s StepID) String() string {
+	if s.IsSpecial() {
+		return FormatStepID(s.Section, stepSpecial)
+	}
+
+	return FormatStepID(s.Section, s.Question)
+}
+
+// Scope the step to a quiz. If the step already knows its quiz (legacy special IDs), it must be the same one.
+func (s *StepID) Scope(quizID string) error {
+	if quizID == "" {
+		return nil
+	}
+
+	if s.Quiz != "" && s.Quiz != quizID {
+		return ErrNotFound
+	}
+
+	s.Quiz = quizID
+
+	return nil
+}
+
+func FormatStepID(section int, question string) string {
+	return string(rune('0'+section)) + "-" + question
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func parseSection(s string) int {
+	if len(s) != 1 || s[0] < '1' || s[0] > '3' {
+		return 0
+	}
+
+	return int(s[0] - '0')
+}
+
+// Parses both the current and the legacy step ID formats
+func ParseStepID(id string) (StepID, error) {
+	parts := strings.Split(id, "-")
+
+	switch len(parts) {
+	case 1:
+		// legacy {questionID}{section}
+		if len(id) < 2 || !isDigits(id) {
+			return StepID{}, ErrBadStepID
+		}
+
+		section := parseSection(id[len(id)-1:])
+		if section == 0 {
+			return StepID{}, ErrBadStepID
+		}
+
+		return StepID{
+			Section:  section,
+			Question: id[:len(id)-1],
+		}, nil
+	case 2:
+		section := parseSection(parts[0])
+		if section == 0 {
+			return StepID{}, ErrBadStepID
+		}
+
+		if parts[1] == stepSpecial {
+			if section == 1 {
+				return StepID{}, ErrBadStepID
+			}
+
+			return StepID{
+				Section: section,
+			}, nil
+		}
+
+		if !isDigits(parts[1]) {
+			return StepID{}, ErrBadStepID
+		}
+
+		return StepID{
+			Section:  section,
+			Question: parts[1],
+		}, nil
+	case 3:
+		// legacy sp-{section}-{quizID}
+		section := parseSection(parts[1])
+
+		if parts[0] != stepSpecial || section < 2 || !isDigits(parts[2]) {
+			return StepID{}, ErrBadStepID
+		}
+
+		return StepID{
+			Section: section,
+			Quiz:    parts[2],
+		}, nil
+	}
+
+	return StepID{}, ErrBadStepID
+}
+
+// Parse a step ID & scope it to a quiz. quizID can be empty for legacy routes.
+func parseScopedStepID(quizID, id string) (StepID, error) {
+	step, err := ParseStepID(id)
+	if err != nil {
+		return step, err
+	}
+
+	return step, step.Scope(quizID)
+}
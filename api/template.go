@@ -0,0 +1,151 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Question content can contain placeholders, ie. "What is {{their}} favourite food?"
+// The case of the placeholder decides the case of the value:
+// {{name}} -> value as is, {{Name}} -> first letter capitalized, {{NAME}} -> all upper case
+
+var templatePlaceholders = map[string]bool{
+	"name":     true,
+	"lastname": true,
+	"fullname": true,
+	"nickname": true,
+	"they":     true,
+	"them":     true,
+	"their":    true,
+	"theirs":   true,
+	"themself": true,
+}
+
+type templateVars map[string]string
+
+func newTemplateVars(section int, deadName, deadLastName, chosenName, chosenLastName, nickname string, pronouns []PronounSet) templateVars {
+	name, lastName := deadName, deadLastName
+
+	switch section {
+	case 2:
+		name = nickname
+	case 3:
+		name, lastName = chosenName, chosenLastName
+	}
+
+	p := pronounsForSection(section, pronouns)
+
+	return templateVars{
+		"name":     name,
+		"lastname": lastName,
+		"fullname": strings.TrimSpace(name + " " + lastName),
+		"nickname": nickname,
+		"they":     p.Subject,
+		"them":     p.Object,
+		"their":    p.Possessive,
+		"theirs":   p.PossessivePronoun,
+		"themself": p.Reflexive,
+	}
+}
+
+type templateCase int
+
+const (
+	TEMPLATE_CASE_NONE templateCase = iota
+	TEMPLATE_CASE_CAPITALIZE
+	TEMPLATE_CASE_UPPER
+)
+
+func placeholderCase(key string) templateCase {
+	if strings.ToUpper(key) == key {
+		return TEMPLATE_CASE_UPPER
+	}
+
+	first, _ := utf8.DecodeRuneInString(key)
+	if unicode.IsUpper(first) {
+		return TEMPLATE_CASE_CAPITALIZE
+	}
+
+	return TEMPLATE_CASE_NONE
+}
+
+func applyCase(v string, c templateCase) string {
+	switch c {
+	case TEMPLATE_CASE_UPPER:
+		return strings.ToUpper(v)
+	case TEMPLATE_CASE_CAPITALIZE:
+		first, size := utf8.DecodeRuneInString(v)
+		if size == 0 {
+			return v
+		}
+
+		return string(unicode.ToUpper(first)) + v[size:]
+	}
+
+	return v
+}
+
+// Calls cb for every placeholder in content, returns the content with each placeholder replaced by what cb returned
+func walkTemplate(content string, cb func(key string) (string, error)) (string, error) {
+	out := strings.Builder{}
+
+	for {
+		start := strings.Index(content, "{{")
+		if start == -1 {
+			out.WriteString(content)
+			break
+		}
+
+		end := strings.Index(content[start:], "}}")
+		if end == -1 {
+			return "", &HTTPError{
+				Msg:    "Unclosed placeholder in question",
+				Status: 400,
+			}
+		}
+		end += start
+
+		v, err := cb(strings.TrimSpace(content[start+2 : end]))
+		if err != nil {
+			return "", err
+		}
+
+		out.WriteString(content[:start])
+		out.WriteString(v)
+
+		content = content[end+2:]
+	}
+
+	return out.String(), nil
+}
+
+// Returns an error if the content has bad or unknown placeholders
+func validateTemplate(content string) error {
+	_, err := walkTemplate(content, func(key string) (string, error) {
+		if !templatePlaceholders[strings.ToLower(key)] {
+			return "", &HTTPError{
+				Msg:    fmt.Sprintf("Unknown placeholder '{{%s}}'", key),
+				Status: 400,
+			}
+		}
+
+		return "", nil
+	})
+
+	return err
+}
+
+// Content is assumed to be validated, unknown placeholders are removed
+func renderTemplate(content string, vars templateVars) string {
+	out, err := walkTemplate(content, func(key string) (string, error) {
+		return applyCase(vars[strings.ToLower(key)], placeholderCase(key)), nil
+	})
+
+	if err != nil {
+		return content
+	}
+
+	return out
+}
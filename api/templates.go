@@ -0,0 +1,159 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/shadiestgoat/who/db"
+)
+
+// Templates are curated question sets that a quiz can be started from (see db/tables.go for the built in ones).
+// Template questions have no answers, since those are personal - the creator fills them in.
+
+type Template struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Questions   []*FullQuestion `json:"questions"`
+}
+
+// Template questions use the same columns as the questions table, so they are selected as 'questions'
+const templateQuestionsQuery = `SELECT template, ` + fullQuestionColumns + ` FROM template_questions AS questions`
+
+func GetTemplates() ([]*Template, error) {
+	rows, err := db.Query(`SELECT id, name, description FROM templates ORDER BY id`)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	templates := []*Template{}
+	byID := map[string]*Template{}
+
+	for rows.Next() {
+		t := &Template{
+			Questions: []*FullQuestion{},
+		}
+
+		if err := rows.Scan(&t.ID, &t.Name, &t.Description); err != nil {
+			rows.Close()
+			return nil, ErrDBHandle(err)
+		}
+
+		templates = append(templates, t)
+		byID[t.ID] = t
+	}
+
+	rows.Close()
+
+	rows, err = db.Query(templateQuestionsQuery + ` ORDER BY template, position`)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		templateID := ""
+		q := newFullQuestion()
+
+		if err := rows.Scan(append([]any{&templateID}, q.scanTargets()...)...); err != nil {
+			return nil, ErrDBHandle(err)
+		}
+
+		q.afterScan()
+
+		if t, ok := byID[templateID]; ok {
+			t.Questions = append(t.Questions, q)
+		}
+	}
+
+	return templates, nil
+}
+
+func getTemplateQuestions(id string) ([]*FullQuestion, error) {
+	rows, err := db.Query(templateQuestionsQuery+` WHERE template = $1 ORDER BY position`, id)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	defer rows.Close()
+
+	questions := []*FullQuestion{}
+
+	for rows.Next() {
+		templateID := ""
+		q := newFullQuestion()
+
+		if err := rows.Scan(append([]any{&templateID}, q.scanTargets()...)...); err != nil {
+			return nil, ErrDBHandle(err)
+		}
+
+		q.afterScan()
+
+		questions = append(questions, q)
+	}
+
+	if len(questions) == 0 {
+		return nil, &HTTPError{
+			Msg:    "Unknown template",
+			Status: 400,
+		}
+	}
+
+	return questions, nil
+}
+
+// Merges the creator's questions into the template's questions, by position.
+// Fields the creator left empty are taken from the template; the answers have to come from the creator,
+// since a template's answers (ie. 0 for numeric questions) are never the right ones.
+func seedFromTemplate(id string, rqs []*FullQuestion) ([]*FullQuestion, error) {
+	questions, err := getTemplateQuestions(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, tq := range questions {
+		if i >= len(rqs) || rqs[i] == nil {
+			return nil, templateAnswerMissing(i)
+		}
+
+		rq := rqs[i]
+
+		if rq.Content == "" {
+			rq.Content = tq.Content
+		}
+		if rq.Type == "" && !rq.IsMultipleChoice {
+			rq.Type = tq.Type
+		}
+		if len(rq.Answers) == 0 {
+			rq.Answers = tq.Answers
+		}
+		if rq.Match == nil {
+			rq.Match = tq.Match
+		}
+		if rq.NumericTolerance == 0 {
+			rq.NumericTolerance = tq.NumericTolerance
+		}
+		if rq.Hint == "" {
+			rq.Hint, rq.HintAfter = tq.Hint, tq.HintAfter
+		}
+
+		// Same as in Sanitize, which runs after this
+		if rq.Type == "" && rq.IsMultipleChoice {
+			rq.Type = QUESTION_CHOICE
+		}
+		if rq.Type.IsValid() && !rq.hasAnswer() {
+			return nil, templateAnswerMissing(i)
+		}
+
+		questions[i] = rq
+	}
+
+	return questions, nil
+}
+
+func templateAnswerMissing(i int) error {
+	return &HTTPError{
+		Msg:    fmt.Sprintf("Template question %d needs an answer", i+1),
+		Status: 400,
+	}
+}
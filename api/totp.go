@@ -0,0 +1,85 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// TOTP (RFC 6238): HMAC-SHA1, 30 second steps, 6 digits. Codes of the previous & next step are accepted too, for clock drift.
+
+const (
+	totpPeriod     = 30
+	totpDigits     = 6
+	totpSecretSize = 20
+	totpSkew       = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// The code of a time step (RFC 4226 HOTP)
+func hotp(secret []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// Returns the step of the matching code, or -1 if the code doesn't match.
+// Steps <= lastStep are rejected, so that a code can't be used twice.
+func totpCheck(secret []byte, code string, t time.Time, lastStep int64) int64 {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return -1
+	}
+
+	now := totpStep(t)
+
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(hotp(secret, uint64(step))), []byte(code)) == 1 {
+			return step
+		}
+	}
+
+	return -1
+}
+
+// The otpauth:// URI that authenticator apps use, usually shown as a QR code.
+// The issuer is TOTP_ISSUER, "Who" by default.
+func totpURI(secret []byte, username string) string {
+	issuer := strings.TrimSpace(os.Getenv("TOTP_ISSUER"))
+	if issuer == "" {
+		issuer = "Who"
+	}
+
+	v := url.Values{}
+	v.Set("secret", totpEncoding.EncodeToString(secret))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+username) + "?" + v.Encode()
+}
@@ -0,0 +1,507 @@
+package api
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/vault"
+)
+
+// Optional 2FA for accounts, using TOTP (see totp.go) & single use recovery codes.
+//
+// Enrolling is 2 steps: EnrollTOTP creates a secret for the authenticator app, then ConfirmTOTP enables 2FA once a code from the app works.
+// When 2FA is on, Exchange returns a challenge instead of the token, which is then exchanged together with a code (see ExchangeChallenge).
+// Disabling 2FA & regenerating recovery codes require re-authenticating (see Reauth) & a code again.
+// Wrong codes count per user, not per challenge: every secondFactorMaxFailures wrong codes in a row lock 2FA for a while (see checkSecondFactor).
+//
+// The TOTP secret is encrypted at rest, in its own envelope (see vault). Recovery codes are hashed like passwords.
+
+const (
+	recoveryCodeCount = 10
+	challengeTTL      = 5 * time.Minute
+	challengeAttempts = 5
+
+	secondFactorMaxFailures = 5
+	// Doubles with every lockout in a row, up to secondFactorMaxLockout
+	secondFactorLockout    = 15 * time.Minute
+	secondFactorMaxLockout = 24 * time.Hour
+)
+
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+type TOTPEnrollment struct {
+	// Base32, for entering manually
+	Secret string `json:"secret"`
+	// otpauth:// URI, for a QR code
+	URI string `json:"uri"`
+}
+
+// Shown only once, when they are generated
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
+
+func GetTwoFactorStatus(userID string) (*TwoFactorStatus, error) {
+	s := &TwoFactorStatus{}
+
+	err := db.QueryRowID(
+		`SELECT totp_enabled, (SELECT COUNT(*) FROM recovery_codes WHERE user_id = ppl.id) FROM ppl WHERE id = $1`,
+		userID,
+		&s.Enabled, &s.RecoveryCodesLeft,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	return s, nil
+}
+
+// What the encrypted secret is bound to, see vault.Envelope.Encrypt
+func totpSecretContext(userID string) string {
+	return userID + "/totp_secret"
+}
+
+type userTOTP struct {
+	secret   []byte
+	enabled  bool
+	lastStep int64
+}
+
+func getTOTP(userID string) (*userTOTP, error) {
+	t := &userTOTP{}
+	secret, keyID, wrappedKey := "", "", []byte{}
+
+	err := db.QueryRowID(
+		`SELECT totp_secret, totp_enc_key, totp_enc_dek, totp_enabled, totp_last_step FROM ppl WHERE id = $1`,
+		userID,
+		&secret, &keyID, &wrappedKey, &t.enabled, &t.lastStep,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	if secret == "" {
+		return t, nil
+	}
+
+	env, err := vault.OpenEnvelope(keyID, wrappedKey)
+	if log.ErrorIfErr(err, "opening the totp envelope") {
+		return nil, ErrServerErr
+	}
+
+	secret, err = env.Decrypt(secret, totpSecretContext(userID))
+	if log.ErrorIfErr(err, "decrypting the totp secret") {
+		return nil, ErrServerErr
+	}
+
+	t.secret, err = totpEncoding.DecodeString(secret)
+	if log.ErrorIfErr(err, "decoding the totp secret") {
+		return nil, ErrServerErr
+	}
+
+	return t, nil
+}
+
+// Starts enrolling, replacing any unconfirmed secret
+func EnrollTOTP(userID string) (*TOTPEnrollment, error) {
+	current, err := getTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if current.enabled {
+		return nil, ErrTOTPEnabled
+	}
+
+	username := ""
+
+	err = db.QueryRowID(`SELECT username FROM ppl WHERE id = $1`, userID, &username)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	secret, err := generateRandomBytes(totpSecretSize)
+	if log.ErrorIfErr(err, "generating totp secret") {
+		return nil, ErrServerErr
+	}
+
+	env, err := vault.NewEnvelope()
+	if log.ErrorIfErr(err, "creating the totp envelope") {
+		return nil, ErrServerErr
+	}
+
+	encrypted, err := env.Encrypt(totpEncoding.EncodeToString(secret), totpSecretContext(userID))
+	if log.ErrorIfErr(err, "encrypting the totp secret") {
+		return nil, ErrServerErr
+	}
+
+	_, err = db.Exec(
+		`UPDATE ppl SET totp_secret = $1, totp_enc_key = $2, totp_enc_dek = $3, totp_enabled = false, totp_last_step = -1 WHERE id = $4`,
+		encrypted, env.KeyID, env.WrappedKey, userID,
+	)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	return &TOTPEnrollment{
+		Secret: totpEncoding.EncodeToString(secret),
+		URI:    totpURI(secret, username),
+	}, nil
+}
+
+// Marks the step as used, returns false if it (or a later step) was already used
+func useTOTPStep(userID string, step int64) bool {
+	tag, err := db.Exec(`UPDATE ppl SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1`, step, userID)
+	return err == nil && tag.RowsAffected() == 1
+}
+
+// Enables 2FA, if the code matches the enrolled secret
+func ConfirmTOTP(userID, code string, by *Actor) (*RecoveryCodes, error) {
+	t, err := getTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.enabled {
+		return nil, ErrTOTPEnabled
+	}
+	if t.secret == nil {
+		return nil, ErrTOTPNotEnabled
+	}
+
+	step := totpCheck(t.secret, code, time.Now(), t.lastStep)
+	if step < 0 || !useTOTPStep(userID, step) {
+		return nil, ErrBadCode
+	}
+
+	_, err = db.Exec(`UPDATE ppl SET totp_enabled = true WHERE id = $1`, userID)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	audit(userID, by, AUDIT_2FA_ENABLE, "", nil)
+
+	return newRecoveryCodes(userID)
+}
+
+// Replaces all recovery codes of the user
+func newRecoveryCodes(userID string) (*RecoveryCodes, error) {
+	codes := &RecoveryCodes{
+		Codes: []string{},
+	}
+	rows := [][]any{}
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		b, err := generateRandomBytes(5)
+		if log.ErrorIfErr(err, "generating recovery code") {
+			return nil, ErrServerErr
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		code = code[:4] + "-" + code[4:]
+
+		hash, err := generateFromPassword(code)
+		if log.ErrorIfErr(err, "hashing recovery code") {
+			return nil, ErrServerErr
+		}
+
+		codes.Codes = append(codes.Codes, code)
+		rows = append(rows, []any{userID, hash})
+	}
+
+	_, err := db.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	_, err = db.Insert(`recovery_codes`, []string{`user_id`, `hash`}, rows)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	return codes, nil
+}
+
+// Consumes the recovery code if it's valid
+func useRecoveryCode(userID, code string) bool {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	// Hashing is expensive, don't bother for things that can't be recovery codes (ie. TOTP codes)
+	if len(code) != 9 || code[4] != '-' {
+		return false
+	}
+
+	rows, err := db.Query(`SELECT hash FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return false
+	}
+
+	hashes := []string{}
+
+	for rows.Next() {
+		hash := ""
+		if rows.Scan(&hash) == nil {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	rows.Close()
+
+	for _, hash := range hashes {
+		match, err := comparePasswordAndHash(code, hash)
+		if err != nil || !match {
+			continue
+		}
+
+		tag, err := db.Exec(`DELETE FROM recovery_codes WHERE user_id = $1 AND hash = $2`, userID, hash)
+
+		return err == nil && tag.RowsAffected() == 1
+	}
+
+	return false
+}
+
+// Returns ErrSecondFactorLocked if the user had too many wrong codes recently
+func checkSecondFactorLock(userID string) error {
+	locked := false
+
+	err := db.QueryRowID(`SELECT COALESCE(second_factor_locked_until > now(), false) FROM ppl WHERE id = $1`, userID, &locked)
+	if err != nil {
+		return ErrDBHandle(err)
+	}
+
+	if locked {
+		return ErrSecondFactorLocked
+	}
+
+	return nil
+}
+
+// Counts a wrong code, locking 2FA after every secondFactorMaxFailures in a row
+func recordSecondFactorFailure(userID string) {
+	failures := 0
+
+	err := db.QueryRowID(`UPDATE ppl SET second_factor_failures = second_factor_failures + 1 WHERE id = $1 RETURNING second_factor_failures`, userID, &failures)
+	if err != nil || failures%secondFactorMaxFailures != 0 {
+		return
+	}
+
+	lockout := secondFactorLockout
+	for i := 1; i < failures/secondFactorMaxFailures && lockout < secondFactorMaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > secondFactorMaxLockout {
+		lockout = secondFactorMaxLockout
+	}
+
+	db.Exec(`UPDATE ppl SET second_factor_locked_until = $1 WHERE id = $2`, time.Now().Add(lockout), userID)
+}
+
+// Checks a TOTP or recovery code of a user with 2FA enabled.
+// Wrong codes are counted for the user (see recordSecondFactorFailure), a correct one resets the count.
+func checkSecondFactor(userID, code string) error {
+	if err := checkSecondFactorLock(userID); err != nil {
+		return err
+	}
+
+	t, err := getTOTP(userID)
+	if err != nil {
+		return err
+	}
+
+	if !t.enabled {
+		return ErrTOTPNotEnabled
+	}
+
+	step := totpCheck(t.secret, code, time.Now(), t.lastStep)
+
+	if !(step >= 0 && useTOTPStep(userID, step)) && !useRecoveryCode(userID, code) {
+		recordSecondFactorFailure(userID)
+		return ErrBadCode
+	}
+
+	db.Exec(`UPDATE ppl SET second_factor_failures = 0, second_factor_locked_until = NULL WHERE id = $1`, userID)
+
+	return nil
+}
+
+// Requires re-authenticating & a code
+func DisableTOTP(userID string, r *Reauth, by *Actor) (*TwoFactorStatus, error) {
+	if err := checkPassword(userID, r); err != nil {
+		return nil, err
+	}
+	if err := checkSecondFactor(userID, r.Code); err != nil {
+		return nil, err
+	}
+
+	_, err := db.Exec(`UPDATE ppl SET totp_secret = '', totp_enc_key = '', totp_enc_dek = NULL, totp_enabled = false, totp_last_step = -1 WHERE id = $1`, userID)
+	if err != nil {
+		return nil, ErrDBHandle(err)
+	}
+
+	db.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+
+	audit(userID, by, AUDIT_2FA_DISABLE, "", nil)
+
+	return GetTwoFactorStatus(userID)
+}
+
+// Requires re-authenticating & a code
+func RegenerateRecoveryCodes(userID string, r *Reauth, by *Actor) (*RecoveryCodes, error) {
+	if err := checkPassword(userID, r); err != nil {
+		return nil, err
+	}
+	if err := checkSecondFactor(userID, r.Code); err != nil {
+		return nil, err
+	}
+
+	codes, err := newRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	audit(userID, by, AUDIT_2FA_RECOVERY, "", nil)
+
+	return codes, nil
+}
+
+// Creates a login challenge, for the 2nd step of logging in. Fails while 2FA is locked, see checkSecondFactor.
+func newChallenge(userID string) (string, error) {
+	if err := checkSecondFactorLock(userID); err != nil {
+		return "", err
+	}
+
+	b, err := generateRandomBytes(32)
+	if log.ErrorIfErr(err, "generating login challenge") {
+		return "", ErrServerErr
+	}
+
+	challenge := base64.RawURLEncoding.EncodeToString(b)
+
+	_, err = db.InsertOne(`auth_challenges`, []string{`token`, `user_id`, `expires_at`}, challenge, userID, time.Now().Add(challengeTTL))
+	if err != nil {
+		return "", ErrDBHandle(err)
+	}
+
+	return challenge, nil
+}
+
+// The 2nd step of logging in with 2FA, see Exchange. The code can be a TOTP or a recovery code.
+func ExchangeChallenge(challenge, code string, by *Actor) (id, token string, err error) {
+	// Every try counts, even the correct one
+	err = db.QueryRow(
+		`UPDATE auth_challenges SET attempts = attempts + 1 WHERE token = $1 AND expires_at > now() AND attempts < $2 RETURNING user_id`,
+		[]any{challenge, challengeAttempts},
+		&id,
+	)
+	if err != nil {
+		if db.NoRows(err) {
+			return "", "", ErrChallengeExpired
+		}
+
+		return "", "", ErrServerErr
+	}
+
+	// The erasure could have been asked for after the challenge was created
+	if err := checkNotErasing(id); err != nil {
+		return "", "", err
+	}
+
+	if err := checkSecondFactor(id, code); err != nil {
+		if err == ErrBadCode {
+			audit(id, by, AUDIT_LOGIN_FAILED, "", nil)
+		}
+
+		return "", "", err
+	}
+
+	db.Exec(`DELETE FROM auth_challenges WHERE token = $1 OR expires_at <= now()`, challenge)
+
+	err = db.QueryRowID(`SELECT token FROM ppl WHERE id = $1`, id, &token)
+	if err != nil {
+		return "", "", ErrDBHandle(err)
+	}
+
+	audit(id, by, AUDIT_LOGIN, "", nil)
+
+	return id, token, nil
+}
+
+// Re-encrypts the TOTP secret of every user that isn't using the current key, or every user if all is true.
+// Returns the amount of re-encrypted secrets.
+func RotateTOTPKeys(all bool) (int, error) {
+	rows, err := db.Query(`SELECT id, totp_secret, totp_enc_key, totp_enc_dek FROM ppl WHERE totp_secret != '' AND ($1 OR totp_enc_key != $2)`, all, vault.CurrentKeyID())
+	if err != nil {
+		return 0, err
+	}
+
+	type storedSecret struct {
+		userID     string
+		secret     string
+		keyID      string
+		wrappedKey []byte
+	}
+
+	secrets := []*storedSecret{}
+
+	for rows.Next() {
+		s := &storedSecret{}
+
+		if err := rows.Scan(&s.userID, &s.secret, &s.keyID, &s.wrappedKey); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		secrets = append(secrets, s)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for _, s := range secrets {
+		oldEnv, err := vault.OpenEnvelope(s.keyID, s.wrappedKey)
+		if err != nil {
+			return count, err
+		}
+
+		plain, err := oldEnv.Decrypt(s.secret, totpSecretContext(s.userID))
+		if err != nil {
+			return count, err
+		}
+
+		env, err := vault.NewEnvelope()
+		if err != nil {
+			return count, err
+		}
+
+		encrypted, err := env.Encrypt(plain, totpSecretContext(s.userID))
+		if err != nil {
+			return count, err
+		}
+
+		// Skip the row if the secret was changed since it was read
+		tag, err := db.Exec(
+			`UPDATE ppl SET totp_secret = $1, totp_enc_key = $2, totp_enc_dek = $3 WHERE id = $4 AND totp_enc_key = $5 AND totp_enc_dek IS NOT DISTINCT FROM $6`,
+			encrypted, env.KeyID, env.WrappedKey, s.userID, s.keyID, s.wrappedKey,
+		)
+		if err != nil {
+			return count, err
+		}
+
+		count += int(tag.RowsAffected())
+	}
+
+	return count, nil
+}
@@ -0,0 +1,96 @@
+package blobs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shadiestgoat/log"
+)
+
+// Stores binary blobs (ie. media) by key
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	// Deleting a key that doesn't exist is not an error
+	Delete(key string) error
+}
+
+var ErrNotFound = errors.New("blob not found")
+var ErrBadKey = errors.New("bad blob key")
+
+var Store BlobStore
+
+func Init() {
+	dir := strings.TrimSpace(os.Getenv("MEDIA_DIR"))
+
+	if dir == "" {
+		dir = "media"
+	}
+
+	store, err := NewLocalStore(dir)
+	log.FatalIfErr(err, "creating the local blob store")
+
+	Store = store
+}
+
+// Stores blobs as files in a directory
+type LocalStore struct {
+	Dir string
+}
+
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &LocalStore{
+		Dir: dir,
+	}, nil
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return "", ErrBadKey
+	}
+
+	return filepath.Join(s.Dir, key), nil
+}
+
+func (s *LocalStore) Put(key string, data []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(p, data, 0o600)
+}
+
+func (s *LocalStore) Get(key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
+
+func (s *LocalStore) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
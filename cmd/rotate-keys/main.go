@@ -0,0 +1,37 @@
+// Re-encrypts the sensitive columns of every row that isn't using the current encryption key (ENCRYPTION_KEY_ID).
+// Run it after adding a new key, and only remove the old key from ENCRYPTION_KEYS once it's done.
+//
+// Usage: rotate-keys [-all]
+package main
+
+import (
+	"flag"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/api"
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/vault"
+)
+
+func main() {
+	all := flag.Bool("all", false, "re-encrypt every row, even the ones already using the current key")
+	flag.Parse()
+
+	log.Init(log.NewLoggerPrint())
+
+	vault.Init()
+
+	if vault.CurrentKeyID() == "" {
+		log.Fatal("ENCRYPTION_KEYS is required!")
+	}
+
+	db.Init()
+
+	quizzes, err := api.RotateQuizKeys(*all)
+	log.FatalIfErr(err, "rotating quiz keys (rotated %d quizzes before failing)", quizzes)
+
+	secrets, err := api.RotateTOTPKeys(*all)
+	log.FatalIfErr(err, "rotating 2FA secret keys (rotated %d quizzes & %d 2FA secrets before failing)", quizzes, secrets)
+
+	log.Success("Rotated %d quizzes & %d 2FA secrets to key '%s'", quizzes, secrets, vault.CurrentKeyID())
+}
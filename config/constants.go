@@ -7,3 +7,8 @@ const (
 	HASH_PARALLELISM = 2
 	HASH_KEY_LEN     = 32
 )
+
+const (
+	MEDIA_MAX_SIZE     = 5 * 1024 * 1024
+	MEDIA_MAX_PER_QUIZ = 10
+)
@@ -2,7 +2,6 @@ package db
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -48,6 +47,69 @@ func Exec(sql string, args ...any) (pgconn.CommandTag, error) {
 	return v1, err
 }
 
+// Same as Exec, but with a setting (ie. who.erasing) that is only set for the statement's transaction. Used to let statements through triggers.
+func ExecWithSetting(setting, value, sql string, args ...any) (pgconn.CommandTag, error) {
+	tx, err := pool.Begin(context.Background())
+	if err != nil {
+		log.Error("Couldn't begin a transaction for '%s': %v", sql, err)
+		return nil, err
+	}
+
+	// Nothing happens if the transaction was committed
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(context.Background(), `SELECT set_config($1, $2, true)`, setting, value)
+	if err != nil {
+		log.Error("Couldn't set '%s' for '%s': %v", setting, sql, err)
+		return nil, err
+	}
+
+	v1, err := tx.Exec(context.Background(), sql, args...)
+	if err != nil {
+		log.Error("Couldn't exec '%s': %v", sql, err)
+		return nil, err
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		log.Error("Couldn't commit '%s': %v", sql, err)
+		return nil, err
+	}
+
+	return v1, nil
+}
+
+// Same as Exec, but the rows of lock (a SELECT ... FOR UPDATE, with 1 condition) are locked for the statement's transaction first.
+// The statement runs after the lock is taken, so it sees every change committed by whoever held the lock before. Used for check & write statements (ie. limits).
+func ExecWithLock(lock string, lockArg any, sql string, args ...any) (pgconn.CommandTag, error) {
+	tx, err := pool.Begin(context.Background())
+	if err != nil {
+		log.Error("Couldn't begin a transaction for '%s': %v", sql, err)
+		return nil, err
+	}
+
+	// Nothing happens if the transaction was committed
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(context.Background(), lock, lockArg)
+	if err != nil {
+		log.Error("Couldn't lock '%s' for '%s': %v", lock, sql, err)
+		return nil, err
+	}
+
+	v1, err := tx.Exec(context.Background(), sql, args...)
+	if err != nil {
+		log.Error("Couldn't exec '%s': %v", sql, err)
+		return nil, err
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		log.Error("Couldn't commit '%s': %v", sql, err)
+		return nil, err
+	}
+
+	return v1, nil
+}
+
 func Query(sql string, args ...any) (pgx.Rows, error) {
 	rows, err := pool.Query(context.Background(), sql, args...)
 	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
@@ -87,9 +149,9 @@ func Exists(table string, conditions string, values ...any) bool {
 func Insert(table string, columns []string, values [][]any) (int64, error) {
 	n, err := pool.CopyFrom(context.Background(), pgx.Identifier{table}, columns, pgx.CopyFromRows(values))
 
+	// The values are not logged, they can contain sensitive data
 	if err != nil {
-		b, _ := json.MarshalIndent(values, "", "\t")
-		log.Error("Couldn't insert into table '%s' (%v), Values:\n%s", table, columns, string(b))
+		log.Error("Couldn't insert %d rows into table '%s' (%v): %v", len(values), table, columns, err)
 	}
 
 	return n, err
@@ -99,6 +161,44 @@ func InsertOne(table string, columns []string, values ...any) (int64, error) {
 	return Insert(table, columns, [][]any{values})
 }
 
+// Runs f only if the session level advisory lock could be taken, ie. only on 1 replica at a time.
+// Returns false if another session holds the lock.
+func WithAdvisoryLock(key int64, f func()) (bool, error) {
+	conn, err := pool.Acquire(context.Background())
+	if err != nil {
+		log.Error("Couldn't acquire a connection for advisory lock %d: %v", key, err)
+		return false, err
+	}
+
+	// The lock belongs to this connection, so it has to be the one releasing it
+	defer conn.Release()
+
+	locked := false
+
+	err = conn.QueryRow(context.Background(), `SELECT pg_try_advisory_lock($1)`, key).Scan(&locked)
+	if err != nil {
+		log.Error("Couldn't take advisory lock %d: %v", key, err)
+		return false, err
+	}
+
+	if !locked {
+		return false, nil
+	}
+
+	defer func() {
+		_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, key)
+		if err != nil {
+			log.Error("Couldn't release advisory lock %d: %v", key, err)
+			// Don't give a connection that still holds the lock back to the pool
+			conn.Conn().Close(context.Background())
+		}
+	}()
+
+	f()
+
+	return true, nil
+}
+
 func Close() {
 	pool.Close()
 }
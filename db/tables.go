@@ -2,39 +2,417 @@ package db
 
 // array of [2]string{SQL statement, context}
 var setup = [][2]string{
+	{sql_SETUP_users, "creating the users (ppl) table"},
 	{sql_SETUP_quiz, "creating the quiz table"},
 	{sql_SETUP_questions, "creating the questions table"},
-	{sql_SETUP_users, "creating the users (ppl) table"},
+	{sql_SETUP_recovery_codes, "creating the recovery codes table"},
+	{sql_SETUP_auth_challenges, "creating the auth challenges table"},
+	{sql_SETUP_webauthn_credentials, "creating the webauthn credentials table"},
+	{sql_SETUP_webauthn_challenges, "creating the webauthn challenges table"},
+	{sql_SETUP_oidc_identities, "creating the oidc identities table"},
+	{sql_SETUP_oidc_states, "creating the oidc states table"},
+	{sql_SETUP_reauth_tokens, "creating the reauth tokens table"},
+	{sql_SETUP_invites, "creating the invites table"},
+	{sql_SETUP_plays, "creating the plays table"},
+	{sql_SETUP_attempts, "creating the attempts table"},
+	{sql_SETUP_media, "creating the media table"},
+	{sql_SETUP_messages, "creating the messages table"},
+	{sql_SETUP_erasures, "creating the erasures table"},
+	{sql_SETUP_audit_log, "creating the audit log table"},
+	{sql_MIGRATE_quiz, "adding the new columns of the quiz table"},
+	{sql_MIGRATE_quiz_status, "setting the default status of quizzes"},
+	{sql_MIGRATE_questions, "adding the new columns of the questions table"},
+	{sql_MIGRATE_question_type, "moving is_multiple_choice to question_type"},
+	{sql_MIGRATE_users, "adding the new columns of the users (ppl) table"},
+	{sql_MIGRATE_plays, "adding the new columns of the plays table"},
+	{sql_MIGRATE_invites, "adding the new columns of the invites table"},
+	{sql_MIGRATE_messages, "adding the new columns of the messages table"},
+	{sql_MIGRATE_oidc_states, "adding the new columns of the oidc states table"},
+	{sql_DROP_RULE_audit_log, "dropping the old audit log rule"},
+	{sql_FUNC_audit_log_append_only, "creating the audit log append only function"},
+	{sql_DROP_TRIGGER_audit_log, "dropping the old audit log trigger"},
+	{sql_TRIGGER_audit_log, "making the audit log append only"},
+	{sql_INDEX_audit_log_user, "creating the audit log user index"},
+	{sql_INDEX_plays_time, "creating the plays time index"},
+	{sql_INDEX_messages_time, "creating the messages time index"},
+	{sql_INDEX_erasures_time, "creating the erasures time index"},
+	{sql_INDEX_erasures_pending, "creating the pending erasures index"},
+	{sql_SETUP_templates, "creating the templates table"},
+	{sql_SETUP_template_questions, "creating the template questions table"},
+	{sql_SEED_templates, "seeding the templates"},
+	{sql_SEED_template_questions, "seeding the template questions"},
 }
 
+// status: draft|published|archived, see api.QuizStatus
 // drop_question: an index from order, 0 based
+// pronouns: 'subject/object/possessive/possessivePronoun/reflexive'
+// deadname, deadlastname, chosenname, chosenlastname: encrypted with the data key enc_dek, wrapped by the master key enc_key (see vault)
+// hash_only, dead_digests: see api.Quiz.HashOnly
+// notify_*: see api.NotifySettings
 const sql_SETUP_quiz = `CREATE TABLE IF NOT EXISTS quiz (
-	id PRIMARY KEY,
-	author TEXT REFERANCES ppl(id),
+	id TEXT PRIMARY KEY,
+	author TEXT REFERENCES ppl(id),
+	status TEXT NOT NULL DEFAULT 'draft',
+	publish_at TIMESTAMPTZ,
+	expires_at TIMESTAMPTZ,
 	deadname TEXT[] NOT NULL,
 	deadlastname TEXT NOT NULL,
 	chosenname TEXT[] NOT NULL,
 	chosenlastname TEXT NOT NULL,
+	enc_key TEXT NOT NULL DEFAULT '',
+	enc_dek BYTEA,
+	hash_only BOOLEAN NOT NULL DEFAULT false,
+	dead_digests TEXT[] NOT NULL DEFAULT '{}',
 	nickname TEXT NOT NULL,
-	order TEXT[] NOT NULL,
+	"order" TEXT[] NOT NULL,
 	drop_question SMALLINT NOT NULL,
-	redirect TEXT NOT NULL
+	redirect TEXT NOT NULL,
+	pronouns TEXT[] NOT NULL DEFAULT '{}',
+	card_message TEXT NOT NULL DEFAULT '',
+	notify_webhook TEXT NOT NULL DEFAULT '',
+	notify_secret TEXT NOT NULL DEFAULT '',
+	notify_email TEXT NOT NULL DEFAULT '',
+	notify_email_pending TEXT NOT NULL DEFAULT '',
+	notify_email_token TEXT NOT NULL DEFAULT '',
+	notify_email_expires TIMESTAMPTZ
 )`
 
-// correct_answer: for multiple choice, 0 based index for answers
+// question_type: see api.QuestionType
+// max_attempts: 0 means no limit
+// media: ID from the media table, or an empty string
+// correct_answer: for multiple choice, 0 based index for answers. For yes/no, 0 = yes & 1 = no
+// correct_answers: for multi-select, 0 based indexes for answers
+// fold_accents, strip_punctuation, ignore_articles, match_tolerance: the match policy, see api.MatchPolicy
 const sql_SETUP_questions = `CREATE TABLE IF NOT EXISTS questions (
 	id TEXT PRIMARY KEY,
-	quiz TEXT REFERANCES quiz(id) ON DELETE CASCADE,
+	quiz TEXT REFERENCES quiz(id) ON DELETE CASCADE,
 
-	is_multiple_choice BOOL DEFAULT 'false',
+	question_type TEXT NOT NULL DEFAULT 'text',
 	answers TEXT[] NOT NULL,
 	correct_answer SMALLINT DEFAULT '0',
-	content TEXT NOT NULL
+	correct_answers SMALLINT[] NOT NULL DEFAULT '{}',
+	numeric_answer DOUBLE PRECISION DEFAULT '0',
+	numeric_tolerance DOUBLE PRECISION DEFAULT '0',
+	content TEXT NOT NULL,
+
+	fold_accents BOOL DEFAULT 'true',
+	strip_punctuation BOOL DEFAULT 'true',
+	ignore_articles BOOL DEFAULT 'false',
+	match_tolerance SMALLINT DEFAULT '0',
+
+	hint TEXT NOT NULL DEFAULT '',
+	hint_after SMALLINT DEFAULT '0',
+	max_attempts SMALLINT DEFAULT '0',
+
+	media TEXT NOT NULL DEFAULT ''
 )`
 
+// has_password: false for users that signed up through an identity provider, see api.FinishOIDC
+// totp_*: see api.EnrollTOTP. totp_secret is encrypted with the data key totp_enc_dek, wrapped by the master key totp_enc_key (see vault)
+// second_factor_*: wrong 2FA codes in a row & the lockout they caused, see api.checkSecondFactor
 const sql_SETUP_users = `CREATE TABLE IF NOT EXISTS ppl (
 	id TEXT PRIMARY KEY,
 	token TEXT UNIQUE NOT NULL,
 	username TEXT UNIQUE NOT NULL,
-	password TEXT NOT NULL
+	password TEXT NOT NULL,
+	has_password BOOL NOT NULL DEFAULT 'true',
+	totp_secret TEXT NOT NULL DEFAULT '',
+	totp_enc_key TEXT NOT NULL DEFAULT '',
+	totp_enc_dek BYTEA,
+	totp_enabled BOOL NOT NULL DEFAULT 'false',
+	totp_last_step BIGINT NOT NULL DEFAULT '-1',
+	second_factor_failures INT NOT NULL DEFAULT '0',
+	second_factor_locked_until TIMESTAMPTZ
+)`
+
+// Argon2 hashes of single use 2FA recovery codes
+const sql_SETUP_recovery_codes = `CREATE TABLE IF NOT EXISTS recovery_codes (
+	user_id TEXT REFERENCES ppl(id) ON DELETE CASCADE,
+	hash TEXT NOT NULL
+)`
+
+// The 2nd step of logging in with 2FA, see api.ExchangeChallenge
+const sql_SETUP_auth_challenges = `CREATE TABLE IF NOT EXISTS auth_challenges (
+	token TEXT PRIMARY KEY,
+	user_id TEXT REFERENCES ppl(id) ON DELETE CASCADE,
+	expires_at TIMESTAMPTZ NOT NULL,
+	attempts INT NOT NULL DEFAULT '0'
+)`
+
+// Passkeys, see api.FinishPasskeyRegistration
+// id: the base64url credential ID
+// public_key: the COSE key of the credential
+const sql_SETUP_webauthn_credentials = `CREATE TABLE IF NOT EXISTS webauthn_credentials (
+	id TEXT PRIMARY KEY,
+	user_id TEXT REFERENCES ppl(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	public_key BYTEA NOT NULL,
+	sign_count BIGINT NOT NULL DEFAULT '0',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_used_at TIMESTAMPTZ
+)`
+
+// Single use challenges of WebAuthn ceremonies
+// kind: register|login|reauth
+// user_id: empty for logins, since the passkey tells who the user is
+const sql_SETUP_webauthn_challenges = `CREATE TABLE IF NOT EXISTS webauthn_challenges (
+	challenge TEXT PRIMARY KEY,
+	kind TEXT NOT NULL,
+	user_id TEXT NOT NULL DEFAULT '',
+	expires_at TIMESTAMPTZ NOT NULL
+)`
+
+// Accounts at identity providers, linked to users. See api.FinishOIDC
+// subject: the provider's ID of the account (the sub claim)
+const sql_SETUP_oidc_identities = `CREATE TABLE IF NOT EXISTS oidc_identities (
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	user_id TEXT REFERENCES ppl(id) ON DELETE CASCADE,
+	email TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (provider, subject)
+)`
+
+// Pending sign ins with an identity provider, see api.StartOIDC
+// user_id: the user linking the identity (or re-authenticating), or empty when logging in
+// binding_hash: SHA-256 of the binding the browser that started the flow keeps, hex
+// reauth: the user is re-authenticating with an identity they linked, instead of linking it
+const sql_SETUP_oidc_states = `CREATE TABLE IF NOT EXISTS oidc_states (
+	state TEXT PRIMARY KEY,
+	provider TEXT NOT NULL,
+	nonce TEXT NOT NULL,
+	verifier TEXT NOT NULL,
+	binding_hash TEXT NOT NULL,
+	user_id TEXT NOT NULL DEFAULT '',
+	reauth BOOL NOT NULL DEFAULT 'false',
+	expires_at TIMESTAMPTZ NOT NULL
+)`
+
+// Single use proof of a fresh sign in, for users without a password. See api.Reauth
+// token_hash: SHA-256 of the token, hex
+const sql_SETUP_reauth_tokens = `CREATE TABLE IF NOT EXISTS reauth_tokens (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT REFERENCES ppl(id) ON DELETE CASCADE,
+	expires_at TIMESTAMPTZ NOT NULL
+)`
+
+// Named invite links to a quiz
+const sql_SETUP_invites = `CREATE TABLE IF NOT EXISTS invites (
+	id TEXT PRIMARY KEY,
+	quiz TEXT REFERENCES quiz(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	token TEXT UNIQUE NOT NULL,
+	revoked BOOL DEFAULT 'false'
+)`
+
+// A play session of a quiz, created when the quiz is previewed
+// is_preview: the author is playing their own quiz
+// invite: the invite used to open the quiz, if any
+const sql_SETUP_plays = `CREATE TABLE IF NOT EXISTS plays (
+	id TEXT PRIMARY KEY,
+	quiz TEXT REFERENCES quiz(id) ON DELETE CASCADE,
+	completed BOOL DEFAULT 'false',
+	is_preview BOOL DEFAULT 'false',
+	invite TEXT REFERENCES invites(id) ON DELETE SET NULL
+)`
+
+// Wrong attempts of a play session on a question. The section is part of the key, since questions are shown in multiple sections
+const sql_SETUP_attempts = `CREATE TABLE IF NOT EXISTS attempts (
+	play TEXT REFERENCES plays(id) ON DELETE CASCADE,
+	question TEXT REFERENCES questions(id) ON DELETE CASCADE,
+	section SMALLINT NOT NULL,
+	wrong SMALLINT NOT NULL DEFAULT '0',
+
+	PRIMARY KEY (play, question, section)
+)`
+
+// Media uploaded for a quiz, the files themselves are in the blob store
+const sql_SETUP_media = `CREATE TABLE IF NOT EXISTS media (
+	id TEXT PRIMARY KEY,
+	quiz TEXT REFERENCES quiz(id) ON DELETE CASCADE,
+	mime TEXT NOT NULL,
+	size INT NOT NULL
+)`
+
+// Messages from viewers to the author, sent after completing the quiz.
+// They outlive their play session, which can be purged before them (see api.RetentionPolicy).
+const sql_SETUP_messages = `CREATE TABLE IF NOT EXISTS messages (
+	id TEXT PRIMARY KEY,
+	quiz TEXT REFERENCES quiz(id) ON DELETE CASCADE,
+	play TEXT REFERENCES plays(id) ON DELETE SET NULL,
+	name TEXT NOT NULL DEFAULT '',
+	content TEXT NOT NULL
 )`
+
+// Account erasure requests, see api.RequestErasure. user_id is cleared once the erasure is completed.
+const sql_SETUP_erasures = `CREATE TABLE IF NOT EXISTS erasures (
+	id TEXT PRIMARY KEY,
+	token TEXT UNIQUE NOT NULL,
+	user_id TEXT NOT NULL,
+	completed_at TIMESTAMPTZ,
+	quizzes INT NOT NULL DEFAULT '0'
+)`
+
+// Append only, see api.AuditEntry. diff: field -> {old, new}, sensitive fields are redacted.
+// Rows are only deleted when their user is erased.
+const sql_SETUP_audit_log = `CREATE TABLE IF NOT EXISTS audit_log (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target TEXT NOT NULL DEFAULT '',
+	actor TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	diff JSONB NOT NULL DEFAULT '{}'
+)`
+
+// Tables are only created if they don't exist, so columns added since have to be added to existing databases too.
+// Keep these in sync with the CREATE TABLE statements above!
+
+// Quizzes from before the status existed were already public, so they're added as published. New quizzes are still drafts (see sql_MIGRATE_quiz_status).
+const sql_MIGRATE_quiz = `ALTER TABLE quiz
+	ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published',
+	ADD COLUMN IF NOT EXISTS publish_at TIMESTAMPTZ,
+	ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ,
+	ADD COLUMN IF NOT EXISTS enc_key TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS enc_dek BYTEA,
+	ADD COLUMN IF NOT EXISTS hash_only BOOLEAN NOT NULL DEFAULT false,
+	ADD COLUMN IF NOT EXISTS dead_digests TEXT[] NOT NULL DEFAULT '{}',
+	ADD COLUMN IF NOT EXISTS pronouns TEXT[] NOT NULL DEFAULT '{}',
+	ADD COLUMN IF NOT EXISTS card_message TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS notify_webhook TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS notify_secret TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS notify_email TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS notify_email_pending TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS notify_email_token TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS notify_email_expires TIMESTAMPTZ`
+
+const sql_MIGRATE_quiz_status = `ALTER TABLE quiz ALTER COLUMN status SET DEFAULT 'draft'`
+
+const sql_MIGRATE_questions = `ALTER TABLE questions
+	ADD COLUMN IF NOT EXISTS question_type TEXT NOT NULL DEFAULT 'text',
+	ADD COLUMN IF NOT EXISTS correct_answers SMALLINT[] NOT NULL DEFAULT '{}',
+	ADD COLUMN IF NOT EXISTS numeric_answer DOUBLE PRECISION DEFAULT '0',
+	ADD COLUMN IF NOT EXISTS numeric_tolerance DOUBLE PRECISION DEFAULT '0',
+	ADD COLUMN IF NOT EXISTS fold_accents BOOL DEFAULT 'true',
+	ADD COLUMN IF NOT EXISTS strip_punctuation BOOL DEFAULT 'true',
+	ADD COLUMN IF NOT EXISTS ignore_articles BOOL DEFAULT 'false',
+	ADD COLUMN IF NOT EXISTS match_tolerance SMALLINT DEFAULT '0',
+	ADD COLUMN IF NOT EXISTS hint TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS hint_after SMALLINT DEFAULT '0',
+	ADD COLUMN IF NOT EXISTS max_attempts SMALLINT DEFAULT '0',
+	ADD COLUMN IF NOT EXISTS media TEXT NOT NULL DEFAULT ''`
+
+// is_multiple_choice was replaced by question_type. Multiple choice questions keep their type, then the old column is dropped, so this only runs once.
+const sql_MIGRATE_question_type = `DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'questions' AND column_name = 'is_multiple_choice') THEN
+		UPDATE questions SET question_type = 'choice' WHERE is_multiple_choice;
+		ALTER TABLE questions DROP COLUMN is_multiple_choice;
+	END IF;
+END
+$$`
+
+const sql_MIGRATE_users = `ALTER TABLE ppl
+	ADD COLUMN IF NOT EXISTS has_password BOOL NOT NULL DEFAULT 'true',
+	ADD COLUMN IF NOT EXISTS totp_secret TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS totp_enc_key TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS totp_enc_dek BYTEA,
+	ADD COLUMN IF NOT EXISTS totp_enabled BOOL NOT NULL DEFAULT 'false',
+	ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT '-1',
+	ADD COLUMN IF NOT EXISTS second_factor_failures INT NOT NULL DEFAULT '0',
+	ADD COLUMN IF NOT EXISTS second_factor_locked_until TIMESTAMPTZ`
+
+const sql_MIGRATE_plays = `ALTER TABLE plays
+	ADD COLUMN IF NOT EXISTS is_preview BOOL DEFAULT 'false',
+	ADD COLUMN IF NOT EXISTS invite TEXT REFERENCES invites(id) ON DELETE SET NULL`
+
+const sql_MIGRATE_invites = `ALTER TABLE invites ADD COLUMN IF NOT EXISTS revoked BOOL DEFAULT 'false'`
+
+const sql_MIGRATE_messages = `ALTER TABLE messages ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT ''`
+
+// Pending states from before the binding can never be finished, the empty hash doesn't match any binding
+const sql_MIGRATE_oidc_states = `ALTER TABLE oidc_states
+	ADD COLUMN IF NOT EXISTS binding_hash TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS reauth BOOL NOT NULL DEFAULT 'false'`
+
+// Replaced by the trigger, which fails loudly instead of silently doing nothing
+const sql_DROP_RULE_audit_log = `DROP RULE IF EXISTS audit_log_no_update ON audit_log`
+
+// Updates always fail. Deletes only go through when who.erasing is 'on' for the transaction, see api.eraseUser & db.ExecWithSetting.
+const sql_FUNC_audit_log_append_only = `CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' AND current_setting('who.erasing', true) = 'on' THEN
+		RETURN OLD;
+	END IF;
+
+	RAISE EXCEPTION 'audit_log is append only (% of %)', TG_OP, OLD.id;
+END
+$$ LANGUAGE plpgsql`
+
+// Re-created on every start, since CREATE OR REPLACE TRIGGER needs postgres 14
+const sql_DROP_TRIGGER_audit_log = `DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log`
+
+const sql_TRIGGER_audit_log = `CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log FOR EACH ROW EXECUTE FUNCTION audit_log_append_only()`
+
+const sql_INDEX_audit_log_user = `CREATE INDEX IF NOT EXISTS audit_log_user ON audit_log (user_id)`
+
+// Snowflake IDs are stored as TEXT, these allow range deletes by time (see api.RetentionPolicy)
+const sql_INDEX_plays_time = `CREATE INDEX IF NOT EXISTS plays_id_num ON plays ((id::BIGINT))`
+const sql_INDEX_messages_time = `CREATE INDEX IF NOT EXISTS messages_id_num ON messages ((id::BIGINT))`
+const sql_INDEX_erasures_time = `CREATE INDEX IF NOT EXISTS erasures_id_num ON erasures ((id::BIGINT))`
+
+// A user can only have 1 pending erasure, see api.RequestErasure
+const sql_INDEX_erasures_pending = `CREATE UNIQUE INDEX IF NOT EXISTS erasures_pending ON erasures (user_id) WHERE completed_at IS NULL`
+
+// Curated question sets, that quizzes can be started from
+const sql_SETUP_templates = `CREATE TABLE IF NOT EXISTS templates (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL
+)`
+
+// Has the same columns as the questions table (apart from quiz), keep them in sync!
+// position: 0 based index of the question in the template
+const sql_SETUP_template_questions = `CREATE TABLE IF NOT EXISTS template_questions (
+	id TEXT PRIMARY KEY,
+	template TEXT REFERENCES templates(id) ON DELETE CASCADE,
+	position SMALLINT NOT NULL,
+
+	question_type TEXT NOT NULL DEFAULT 'text',
+	answers TEXT[] NOT NULL DEFAULT '{}',
+	correct_answer SMALLINT DEFAULT '0',
+	correct_answers SMALLINT[] NOT NULL DEFAULT '{}',
+	numeric_answer DOUBLE PRECISION DEFAULT '0',
+	numeric_tolerance DOUBLE PRECISION DEFAULT '0',
+	content TEXT NOT NULL,
+
+	fold_accents BOOL DEFAULT 'true',
+	strip_punctuation BOOL DEFAULT 'true',
+	ignore_articles BOOL DEFAULT 'false',
+	match_tolerance SMALLINT DEFAULT '0',
+
+	hint TEXT NOT NULL DEFAULT '',
+	hint_after SMALLINT DEFAULT '0',
+	max_attempts SMALLINT DEFAULT '0',
+
+	media TEXT NOT NULL DEFAULT ''
+)`
+
+const sql_SEED_templates = `INSERT INTO templates (id, name, description) VALUES
+	('favourites', 'Favourites', 'Favourite food, colour & animal'),
+	('childhood', 'Childhood', 'Where you grew up, when you were born & your first pet'),
+	('basics', 'The basics', 'Star sign, height & what you do for a living')
+ON CONFLICT (id) DO NOTHING`
+
+const sql_SEED_template_questions = `INSERT INTO template_questions (id, template, position, question_type, content, ignore_articles, numeric_tolerance) VALUES
+	('favourites-1', 'favourites', 0, 'text', 'What is {{name}}''s favourite food?', true, 0),
+	('favourites-2', 'favourites', 1, 'text', 'What is {{name}}''s favourite colour?', false, 0),
+	('favourites-3', 'favourites', 2, 'text', 'What is {{name}}''s favourite animal?', true, 0),
+	('childhood-1', 'childhood', 0, 'text', 'Where did {{name}} grow up?', false, 0),
+	('childhood-2', 'childhood', 1, 'numeric', 'What year was {{name}} born in?', false, 0),
+	('childhood-3', 'childhood', 2, 'yesno', 'Did {{name}} have a pet growing up?', false, 0),
+	('basics-1', 'basics', 0, 'text', 'What is {{name}}''s star sign?', false, 0),
+	('basics-2', 'basics', 1, 'numeric', 'How tall is {{name}}, in cm?', false, 5),
+	('basics-3', 'basics', 2, 'text', 'What does {{name}} do for a living?', true, 0)
+ON CONFLICT (id) DO NOTHING`
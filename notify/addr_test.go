@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"1.1.1.1":              true,
+		"8.8.8.8":              true,
+		"2606:4700::1111":      true,
+		"127.0.0.1":            false,
+		"127.10.0.1":           false,
+		"::1":                  false,
+		"10.0.0.1":             false,
+		"172.16.5.4":           false,
+		"192.168.1.1":          false,
+		"fd00::1":              false,
+		"169.254.169.254":      false,
+		"fe80::1":              false,
+		"0.0.0.0":              false,
+		"::":                   false,
+		"100.64.0.1":           false,
+		"224.0.0.1":            false,
+		"::ffff:127.0.0.1":     false,
+		"::ffff:10.0.0.1":      false,
+		"64:ff9b::7f00:1":      false,
+		"2002:7f00:1::":        false,
+		"255.255.255.255":      false,
+		"::ffff:93.184.216.34": true,
+	} {
+		if got := IsPublicAddr(netip.MustParseAddr(addr)); got != public {
+			t.Errorf("IsPublicAddr(%s) = %v, expected %v", addr, got, public)
+		}
+	}
+}
+
+func TestDialPublicOnly(t *testing.T) {
+	for _, address := range []string{
+		"127.0.0.1:80",
+		"[::1]:443",
+		"10.1.2.3:8080",
+		"192.168.0.10:25",
+		"172.31.255.255:80",
+		"169.254.169.254:80",
+		"[fd12:3456::1]:80",
+		"[::ffff:127.0.0.1]:80",
+		"localhost:80",
+		"not an address",
+	} {
+		if err := dialPublicOnly("tcp", address, nil); err == nil {
+			t.Errorf("dialing %s was allowed", address)
+		}
+	}
+
+	for _, address := range []string{"1.1.1.1:443", "[2606:4700::1111]:443"} {
+		if err := dialPublicOnly("tcp", address, nil); err != nil {
+			t.Errorf("dialing %s was refused: %v", address, err)
+		}
+	}
+}
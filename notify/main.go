@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+)
+
+// Notifies quiz authors about things that happen to their quizzes (ie. a friend completing one)
+
+type EventType string
+
+const (
+	EVENT_COMPLETED EventType = "quiz.completed"
+)
+
+type Event struct {
+	Type EventType `json:"type"`
+	Quiz string    `json:"quiz"`
+	Play string    `json:"play"`
+	// The name of the invite the play was started with, if any
+	Invite string    `json:"invite,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, e *Event) error
+}
+
+// Sends the event through every notifier, returning the first error
+type Multi []Notifier
+
+func (m Multi) Notify(ctx context.Context, e *Event) error {
+	var firstErr error
+
+	for _, n := range m {
+		if err := n.Notify(ctx, e); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// The SMTP config used for email notifications, nil if SMTP_ADDR is not set
+var SMTPConfig *SMTP
+
+func Init() {
+	addr := strings.TrimSpace(os.Getenv("SMTP_ADDR"))
+	if addr == "" {
+		return
+	}
+
+	SMTPConfig = &SMTP{
+		Addr:     addr,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     strings.TrimSpace(os.Getenv("SMTP_FROM")),
+	}
+}
@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// Sends the event as a plain text email to To
+type SMTP struct {
+	// host:port
+	Addr string
+	// If empty, no auth is used. Note that net/smtp only allows PLAIN auth over TLS or to localhost.
+	Username string
+	Password string
+	From     string
+
+	To string
+}
+
+var ErrBadAddress = errors.New("bad email address")
+
+// Returns a copy of the config, sending to another address
+func (s *SMTP) WithTo(to string) *SMTP {
+	c := *s
+	c.To = to
+
+	return &c
+}
+
+func (s *SMTP) Notify(ctx context.Context, e *Event) error {
+	subject := "Someone completed your quiz"
+	body := "Someone just completed your quiz (" + e.Quiz + ")"
+
+	if e.Invite != "" {
+		subject = e.Invite + " completed your quiz"
+		body = e.Invite + " just completed your quiz (" + e.Quiz + ")"
+	}
+
+	return s.Send(ctx, subject, body+", at "+e.Time.UTC().Format("2006-01-02 15:04 MST")+".")
+}
+
+// Sends a plain text email to To
+func (s *SMTP) Send(ctx context.Context, subject, body string) error {
+	// Header injection
+	for _, a := range []string{s.From, s.To, subject} {
+		if strings.ContainsAny(a, "\r\n") {
+			return ErrBadAddress
+		}
+	}
+
+	msg := []byte(fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		s.From, s.To, subject, body,
+	))
+
+	var auth smtp.Auth
+
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- smtp.SendMail(s.Addr, auth, s.From, []string{s.To}, msg)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
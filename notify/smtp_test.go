@@ -0,0 +1,194 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A minimal SMTP server, that accepts every email & keeps the DATA of each
+type fakeSMTP struct {
+	ln    net.Listener
+	mails chan string
+	// If true, the server never greets, so clients hang
+	silent bool
+}
+
+func newFakeSMTP(t *testing.T, silent bool) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+
+	s := &fakeSMTP{ln: ln, mails: make(chan string, 10), silent: silent}
+	t.Cleanup(func() { ln.Close() })
+
+	go s.serve()
+
+	return s
+}
+
+func (s *fakeSMTP) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTP) handle(conn net.Conn) {
+	defer conn.Close()
+
+	if s.silent {
+		// Hold the connection without answering
+		conn.Read(make([]byte, 1))
+		return
+	}
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost ready")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM"), strings.HasPrefix(cmd, "RCPT TO"), strings.HasPrefix(cmd, "RSET"), strings.HasPrefix(cmd, "NOOP"):
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 go ahead")
+
+			data := ""
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+
+				data += l
+			}
+
+			s.mails <- data
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+func TestSMTPSend(t *testing.T) {
+	server := newFakeSMTP(t, false)
+
+	s := &SMTP{
+		Addr: server.ln.Addr().String(),
+		From: "who@example.com",
+		To:   "author@example.com",
+	}
+
+	if err := s.Send(context.Background(), "Confirm your email", "Hi!\r\nThe link: https://example.com"); err != nil {
+		t.Fatalf("sending: %v", err)
+	}
+
+	select {
+	case mail := <-server.mails:
+		for _, want := range []string{
+			"From: who@example.com\r\n",
+			"To: author@example.com\r\n",
+			"Subject: Confirm your email\r\n",
+			"Content-Type: text/plain; charset=UTF-8\r\n",
+			"\r\n\r\nHi!\r\nThe link: https://example.com\r\n",
+		} {
+			if !strings.Contains(mail, want) {
+				t.Errorf("the mail doesn't have %q: %q", want, mail)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server didn't get the mail")
+	}
+}
+
+func TestSMTPNotify(t *testing.T) {
+	server := newFakeSMTP(t, false)
+
+	s := &SMTP{
+		Addr: server.ln.Addr().String(),
+		From: "who@example.com",
+	}
+
+	if err := s.WithTo("author@example.com").Notify(context.Background(), testEvent); err != nil {
+		t.Fatalf("notifying: %v", err)
+	}
+
+	select {
+	case mail := <-server.mails:
+		if !strings.Contains(mail, "Subject: Lucy completed your quiz\r\n") || !strings.Contains(mail, "(quiz)") {
+			t.Errorf("unexpected mail: %q", mail)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server didn't get the mail")
+	}
+
+	if s.To != "" {
+		t.Errorf("WithTo changed the original config")
+	}
+}
+
+func TestSMTPHeaderInjection(t *testing.T) {
+	server := newFakeSMTP(t, false)
+
+	for _, s := range []*SMTP{
+		{Addr: server.ln.Addr().String(), From: "who@example.com", To: "a@example.com\r\nBcc: b@example.com"},
+		{Addr: server.ln.Addr().String(), From: "who@example.com\nBcc: b@example.com", To: "a@example.com"},
+	} {
+		if err := s.Send(context.Background(), "Hi", "body"); !errors.Is(err, ErrBadAddress) {
+			t.Errorf("expected ErrBadAddress, got %v", err)
+		}
+	}
+
+	s := &SMTP{Addr: server.ln.Addr().String(), From: "who@example.com", To: "a@example.com"}
+	if err := s.Send(context.Background(), "Hi\r\nBcc: b@example.com", "body"); !errors.Is(err, ErrBadAddress) {
+		t.Errorf("expected ErrBadAddress for the subject, got %v", err)
+	}
+
+	select {
+	case mail := <-server.mails:
+		t.Errorf("a mail was sent: %q", mail)
+	default:
+	}
+}
+
+func TestSMTPTimeout(t *testing.T) {
+	server := newFakeSMTP(t, true)
+
+	s := &SMTP{Addr: server.ln.Addr().String(), From: "who@example.com", To: "a@example.com"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := s.Send(ctx, "Hi", "body"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+}
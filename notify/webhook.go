@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HEADER_SIGNATURE = "Who-Signature"
+	HEADER_TIMESTAMP = "Who-Timestamp"
+)
+
+// Posts the event as JSON to URL.
+// The payload is signed with Secret: the HEADER_SIGNATURE header is the hex HMAC-SHA256 of "{timestamp}.{body}",
+// where timestamp is the HEADER_TIMESTAMP header (unix seconds).
+// Network errors, 429 & 5xx responses are retried, with exponential backoff.
+type Webhook struct {
+	URL    string
+	Secret string
+
+	// Defaults to a client with a 10s timeout, that only connects to public addresses (see IsPublicAddr)
+	Client *http.Client
+	// Defaults to 3
+	Retries int
+	// The delay before the 1st retry, doubled for each one after. Defaults to 1s.
+	Backoff time.Duration
+}
+
+var defaultClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		// No proxy, it would dial the webhook instead of us
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: dialPublicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhook) Notify(ctx context.Context, e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	client := w.Client
+	if client == nil {
+		client = defaultClient
+	}
+
+	retries := w.Retries
+	if retries <= 0 {
+		retries = 3
+	}
+
+	backoff := w.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	for attempt := 0; ; attempt++ {
+		retry, err := w.send(ctx, client, body)
+		if err == nil || !retry || attempt >= retries {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Returns true if the request should be retried
+func (w *Webhook) send(ctx context.Context, client *http.Client, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HEADER_TIMESTAMP, timestamp)
+	req.Header.Set(HEADER_SIGNATURE, Sign(w.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil && !errors.Is(err, ErrNonPublicAddress), err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, fmt.Errorf("webhook responded with %d", resp.StatusCode)
+}
@@ -0,0 +1,146 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A stand-in for a webhook receiver, that answers with statuses in order (the last one repeats)
+type webhookReceiver struct {
+	t        *testing.T
+	statuses []int
+
+	lock   sync.Mutex
+	bodies [][]byte
+	server *httptest.Server
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	rec := &webhookReceiver{t: t, statuses: statuses}
+	rec.server = httptest.NewServer(http.HandlerFunc(rec.serve))
+	t.Cleanup(rec.server.Close)
+
+	return rec
+}
+
+func (rec *webhookReceiver) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		rec.t.Errorf("unexpected request: %s, content type '%s'", r.Method, r.Header.Get("Content-Type"))
+	}
+
+	if sig := Sign(testWebhookSecret, r.Header.Get(HEADER_TIMESTAMP), body); sig != r.Header.Get(HEADER_SIGNATURE) {
+		rec.t.Errorf("bad signature '%s', expected '%s'", r.Header.Get(HEADER_SIGNATURE), sig)
+	}
+
+	rec.bodies = append(rec.bodies, body)
+
+	status := rec.statuses[len(rec.statuses)-1]
+	if len(rec.bodies) <= len(rec.statuses) {
+		status = rec.statuses[len(rec.bodies)-1]
+	}
+
+	w.WriteHeader(status)
+}
+
+func (rec *webhookReceiver) requests() int {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	return len(rec.bodies)
+}
+
+const testWebhookSecret = "secret"
+
+var testEvent = &Event{
+	Type:   EVENT_COMPLETED,
+	Quiz:   "quiz",
+	Play:   "play",
+	Invite: "Lucy",
+	Time:   time.Unix(1700000000, 0).UTC(),
+}
+
+// The stand-in is on a loopback address, so the test client has to be used instead of the default one
+func (rec *webhookReceiver) webhook() *Webhook {
+	return &Webhook{
+		URL:     rec.server.URL,
+		Secret:  testWebhookSecret,
+		Client:  rec.server.Client(),
+		Retries: 2,
+		Backoff: time.Millisecond,
+	}
+}
+
+func TestWebhookSigned(t *testing.T) {
+	rec := newWebhookReceiver(t, 200)
+
+	if err := rec.webhook().Notify(context.Background(), testEvent); err != nil {
+		t.Fatalf("notifying: %v", err)
+	}
+
+	if rec.requests() != 1 {
+		t.Fatalf("expected 1 request, got %d", rec.requests())
+	}
+
+	e := &Event{}
+	if err := json.Unmarshal(rec.bodies[0], e); err != nil {
+		t.Fatalf("bad body: %v", err)
+	}
+
+	if *e != *testEvent {
+		t.Errorf("got event %+v, expected %+v", e, testEvent)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	for name, c := range map[string]struct {
+		statuses []int
+		requests int
+		fails    bool
+	}{
+		"5xx then ok":       {[]int{500, 503, 200}, 3, false},
+		"429 then ok":       {[]int{429, 204}, 2, false},
+		"always 5xx":        {[]int{502}, 3, true},
+		"4xx isn't retried": {[]int{400}, 1, true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := newWebhookReceiver(t, c.statuses...)
+
+			err := rec.webhook().Notify(context.Background(), testEvent)
+			if (err != nil) != c.fails {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if rec.requests() != c.requests {
+				t.Errorf("expected %d requests, got %d", c.requests, rec.requests())
+			}
+		})
+	}
+}
+
+func TestWebhookDefaultClientRefusesLoopback(t *testing.T) {
+	rec := newWebhookReceiver(t, 200)
+
+	w := rec.webhook()
+	w.Client = nil
+
+	err := w.Notify(context.Background(), testEvent)
+	if !errors.Is(err, ErrNonPublicAddress) {
+		t.Errorf("expected ErrNonPublicAddress, got %v", err)
+	}
+
+	if rec.requests() != 0 {
+		t.Errorf("the webhook reached a loopback address")
+	}
+}
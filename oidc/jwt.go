@@ -0,0 +1,271 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ID token (JWT) verification. Only RS256 & ES256 are accepted, keys come from the provider's JWKS.
+
+var (
+	ErrBadToken   = errors.New("bad ID token")
+	ErrUnknownKey = errors.New("unknown signing key")
+)
+
+// How far off the provider's clock can be
+const clockSkew = time.Minute
+
+// How often the JWKS can be re-fetched when an unknown key shows up
+const keysRefetch = time.Minute
+
+type Claims struct {
+	Issuer            string `json:"iss"`
+	Subject           string `json:"sub"`
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+
+	Audience  audience `json:"aud"`
+	AZP       string   `json:"azp"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	// When the user signed in at the provider. Only checked when the session has a MaxAge.
+	AuthTime int64 `json:"auth_time"`
+}
+
+// aud can be a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	single := ""
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	multi := []string{}
+	if err := json.Unmarshal(b, &multi); err != nil {
+		return err
+	}
+
+	*a = multi
+
+	return nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	N string `json:"n"`
+	E string `json:"e"`
+
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func b64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, ErrBadToken
+		}
+
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, ErrUnknownKey
+		}
+
+		x, err := b64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, ErrBadToken
+		}
+
+		return pub, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// Finds a signing key by ID, re-fetching the JWKS if it's unknown (ie. the provider rotated its keys)
+func (p *Provider) key(ctx context.Context, kid string) (*jwk, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+
+	if time.Since(p.keysFetch) < keysRefetch {
+		return nil, ErrUnknownKey
+	}
+
+	set := struct {
+		Keys []*jwk `json:"keys"`
+	}{}
+
+	p.keysFetch = time.Now()
+
+	if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	p.keys = map[string]*jwk{}
+
+	for _, k := range set.Keys {
+		if k.Use == "" || k.Use == "sig" {
+			p.keys[k.Kid] = k
+		}
+	}
+
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+func verifyJWS(alg string, pub crypto.PublicKey, signed, sig []byte) bool {
+	digest := sha256.Sum256(signed)
+
+	switch alg {
+	case "RS256":
+		pub, ok := pub.(*rsa.PublicKey)
+		return ok && pub.N.BitLen() >= 2048 && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil
+	case "ES256":
+		pub, ok := pub.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+
+		return ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	}
+
+	return false
+}
+
+// Verifies the ID token against the session it was requested for
+func (p *Provider) VerifyIDToken(ctx context.Context, raw string, s *Session) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrBadToken
+	}
+
+	rawHeader, err := b64(parts[0])
+	if err != nil {
+		return nil, ErrBadToken
+	}
+	rawClaims, err := b64(parts[1])
+	if err != nil {
+		return nil, ErrBadToken
+	}
+	sig, err := b64(parts[2])
+	if err != nil {
+		return nil, ErrBadToken
+	}
+
+	h := &jwtHeader{}
+	if err := json.Unmarshal(rawHeader, h); err != nil || (h.Alg != "RS256" && h.Alg != "ES256") {
+		return nil, ErrBadToken
+	}
+
+	k, err := p.key(ctx, h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if k.Alg != "" && k.Alg != h.Alg {
+		return nil, ErrBadToken
+	}
+
+	pub, err := k.publicKey()
+	if err != nil {
+		return nil, ErrBadToken
+	}
+
+	if !verifyJWS(h.Alg, pub, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrBadToken
+	}
+
+	c := &Claims{}
+	if err := json.Unmarshal(rawClaims, c); err != nil {
+		return nil, ErrBadToken
+	}
+
+	return c, p.checkClaims(c, s, time.Now())
+}
+
+func (p *Provider) checkClaims(c *Claims, s *Session, now time.Time) error {
+	if strings.TrimSuffix(c.Issuer, "/") != p.Issuer || c.Subject == "" || c.Nonce == "" || c.Nonce != s.Nonce {
+		return ErrBadToken
+	}
+
+	found := false
+	for _, a := range c.Audience {
+		if a == p.ClientID {
+			found = true
+		}
+	}
+	// azp is the party the token was issued to, only required when there are other audiences
+	if !found || ((c.AZP != "" || len(c.Audience) > 1) && c.AZP != p.ClientID) {
+		return ErrBadToken
+	}
+
+	if c.ExpiresAt == 0 || now.Add(-clockSkew).Unix() >= c.ExpiresAt || now.Add(clockSkew).Unix() < c.IssuedAt {
+		return ErrBadToken
+	}
+
+	if s.MaxAge != 0 && (c.AuthTime == 0 || now.Add(-s.MaxAge-clockSkew).Unix() > c.AuthTime || now.Add(clockSkew).Unix() < c.AuthTime) {
+		return ErrBadToken
+	}
+
+	return nil
+}
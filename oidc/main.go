@@ -0,0 +1,302 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shadiestgoat/log"
+)
+
+// A generic OpenID Connect client, for the authorization code flow with PKCE.
+// Provider endpoints are found through discovery ({issuer}/.well-known/openid-configuration), so only the issuer has to be configured.
+
+var (
+	ErrUnknownProvider = errors.New("unknown provider")
+	ErrDiscovery       = errors.New("bad discovery document")
+	ErrExchange        = errors.New("code exchange failed")
+)
+
+type Provider struct {
+	// The name used in URLs, ie. /auth/oidc/{name}
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Where the provider sends the user back to
+	RedirectURL string
+	Scopes      []string
+
+	// http.DefaultClient if nil
+	Client *http.Client
+
+	lock      sync.Mutex
+	meta      *discovery
+	keys      map[string]*jwk
+	keysFetch time.Time
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// The configured providers, by name
+var Providers = map[string]*Provider{}
+
+// OIDC_PROVIDERS is a comma separated list of provider names. For each {NAME} (upper case):
+//
+//	OIDC_{NAME}_ISSUER, OIDC_{NAME}_CLIENT_ID, OIDC_{NAME}_REDIRECT_URL: required
+//	OIDC_{NAME}_CLIENT_SECRET: optional, for confidential clients
+//	OIDC_{NAME}_SCOPES: space separated, defaults to "openid profile email"
+func Init() {
+	Providers = map[string]*Provider{}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		env := func(key string) string {
+			return strings.TrimSpace(os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + key))
+		}
+
+		p := &Provider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(env("ISSUER"), "/"),
+			ClientID:     env("CLIENT_ID"),
+			ClientSecret: env("CLIENT_SECRET"),
+			RedirectURL:  env("REDIRECT_URL"),
+			Scopes:       strings.Fields(env("SCOPES")),
+		}
+
+		if p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+			log.Fatal("OIDC provider '%s' needs an issuer, a client ID & a redirect URL", name)
+		}
+
+		Providers[name] = p
+	}
+}
+
+func Get(name string) (*Provider, error) {
+	p, ok := Providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	return p, nil
+}
+
+func (p *Provider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+
+	return http.DefaultClient
+}
+
+func (p *Provider) scopes() []string {
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+
+	for _, s := range scopes {
+		if s == "openid" {
+			return scopes
+		}
+	}
+
+	return append([]string{"openid"}, scopes...)
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", u, resp.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// The discovery document, fetched once
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	d := &discovery{}
+
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(d.Issuer, "/") != p.Issuer || d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, ErrDiscovery
+	}
+
+	p.meta = d
+
+	return d, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Everything needed to finish a login, has to be kept until the user comes back
+type Session struct {
+	State    string
+	Nonce    string
+	Verifier string
+
+	// If not 0, the user has to sign in again at the provider (not just reuse their session there), at most this long ago (see Claims.AuthTime)
+	MaxAge time.Duration
+}
+
+func NewSession() (*Session, error) {
+	s := &Session{}
+
+	for _, v := range []*string{&s.State, &s.Nonce, &s.Verifier} {
+		r, err := randomString()
+		if err != nil {
+			return nil, err
+		}
+
+		*v = r
+	}
+
+	return s, nil
+}
+
+// The S256 PKCE code challenge of a verifier
+func CodeChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// The URL to send the user to
+func (p *Provider) AuthURL(ctx context.Context, s *Session) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", ErrDiscovery
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(p.scopes(), " "))
+	q.Set("state", s.State)
+	q.Set("nonce", s.Nonce)
+	q.Set("code_challenge", CodeChallenge(s.Verifier))
+	q.Set("code_challenge_method", "S256")
+
+	if s.MaxAge != 0 {
+		q.Set("prompt", "login")
+		q.Set("max_age", strconv.Itoa(int(s.MaxAge.Seconds())))
+	}
+
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+	Desc    string `json:"error_description"`
+}
+
+// Exchanges the code for tokens, returning the verified claims of the ID token
+func (p *Provider) Exchange(ctx context.Context, code string, s *Session) (*Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {s.Verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	t := &tokenResponse{}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(t); err != nil {
+		return nil, fmt.Errorf("%w: status %d", ErrExchange, resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusOK || t.Error != "" {
+		return nil, fmt.Errorf("%w: status %d: %s %s", ErrExchange, resp.StatusCode, t.Error, t.Desc)
+	}
+
+	if t.IDToken == "" {
+		return nil, fmt.Errorf("%w: no ID token", ErrExchange)
+	}
+
+	return p.VerifyIDToken(ctx, t.IDToken, s)
+}
@@ -0,0 +1,303 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/shadiestgoat/who/oidc"
+	"github.com/shadiestgoat/who/oidc/oidctest"
+)
+
+const (
+	testClientID    = "who"
+	testRedirectURL = "https://who.test/oidc/callback"
+)
+
+func newIssuer(t *testing.T) (*oidctest.Issuer, *oidc.Provider) {
+	t.Helper()
+
+	iss := oidctest.New(testClientID)
+	t.Cleanup(iss.Close)
+
+	return iss, iss.Provider("test", testRedirectURL)
+}
+
+func newSession(t *testing.T) *oidc.Session {
+	t.Helper()
+
+	s, err := oidc.NewSession()
+	if err != nil {
+		t.Fatalf("creating a session: %v", err)
+	}
+
+	return s
+}
+
+// Signs in at the issuer, then exchanges the code with the session
+func signIn(t *testing.T, iss *oidctest.Issuer, p *oidc.Provider, s *oidc.Session) (*oidc.Claims, error) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	u, err := p.AuthURL(ctx, s)
+	if err != nil {
+		t.Fatalf("creating the auth URL: %v", err)
+	}
+
+	code, state, err := iss.Login(u)
+	if err != nil {
+		t.Fatalf("signing in at the issuer: %v", err)
+	}
+
+	if state != s.State {
+		t.Errorf("the issuer sent back the state '%s' instead of '%s'", state, s.State)
+	}
+
+	return p.Exchange(ctx, code, s)
+}
+
+func TestSignIn(t *testing.T) {
+	for _, alg := range []string{"ES256", "RS256"} {
+		iss, p := newIssuer(t)
+		iss.Alg = alg
+		iss.Subject = "alice"
+
+		c, err := signIn(t, iss, p, newSession(t))
+		if err != nil {
+			t.Fatalf("%s: signing in: %v", alg, err)
+		}
+
+		if c.Subject != "alice" || c.Email != "alice@who.test" || !c.EmailVerified || c.PreferredUsername != "alice" {
+			t.Errorf("%s: unexpected claims: %+v", alg, c)
+		}
+	}
+}
+
+func TestAuthURL(t *testing.T) {
+	_, p := newIssuer(t)
+	s := newSession(t)
+
+	u, err := p.AuthURL(context.Background(), s)
+	if err != nil {
+		t.Fatalf("creating the auth URL: %v", err)
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf("parsing the auth URL: %v", err)
+	}
+
+	q := parsed.Query()
+
+	for key, expected := range map[string]string{
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"state":                 s.State,
+		"nonce":                 s.Nonce,
+		"code_challenge":        oidc.CodeChallenge(s.Verifier),
+		"code_challenge_method": "S256",
+		"scope":                 "openid profile email",
+	} {
+		if q.Get(key) != expected {
+			t.Errorf("%s: expected '%s', got '%s'", key, expected, q.Get(key))
+		}
+	}
+
+	// The verifier itself never leaves the server
+	for key, values := range q {
+		for _, v := range values {
+			if v == s.Verifier {
+				t.Errorf("the verifier is in the auth URL, as %s", key)
+			}
+		}
+	}
+
+	if q.Has("prompt") || q.Has("max_age") {
+		t.Errorf("a new sign in was asked for without a max age: %s", u)
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	iss, p := newIssuer(t)
+	ctx := context.Background()
+	s := newSession(t)
+
+	u, err := p.AuthURL(ctx, s)
+	if err != nil {
+		t.Fatalf("creating the auth URL: %v", err)
+	}
+
+	code, _, err := iss.Login(u)
+	if err != nil {
+		t.Fatalf("signing in at the issuer: %v", err)
+	}
+
+	// Someone that got the code, but not the verifier
+	stolen := *s
+	stolen.Verifier = newSession(t).Verifier
+
+	if _, err := p.Exchange(ctx, code, &stolen); !errors.Is(err, oidc.ErrExchange) {
+		t.Errorf("another verifier: expected ErrExchange, got %v", err)
+	}
+
+	// Codes are single use, even with the right verifier after a failed exchange
+	if _, err := p.Exchange(ctx, code, s); !errors.Is(err, oidc.ErrExchange) {
+		t.Errorf("a used code: expected ErrExchange, got %v", err)
+	}
+}
+
+func TestBadNonce(t *testing.T) {
+	iss, p := newIssuer(t)
+	ctx := context.Background()
+	s := newSession(t)
+
+	u, err := p.AuthURL(ctx, s)
+	if err != nil {
+		t.Fatalf("creating the auth URL: %v", err)
+	}
+
+	code, _, err := iss.Login(u)
+	if err != nil {
+		t.Fatalf("signing in at the issuer: %v", err)
+	}
+
+	// The token was issued for another sign in
+	other := *s
+	other.Nonce = newSession(t).Nonce
+
+	if _, err := p.Exchange(ctx, code, &other); !errors.Is(err, oidc.ErrBadToken) {
+		t.Errorf("another nonce: expected ErrBadToken, got %v", err)
+	}
+
+	iss.Tamper = func(header, claims map[string]any) { delete(claims, "nonce") }
+
+	if _, err := signIn(t, iss, p, newSession(t)); !errors.Is(err, oidc.ErrBadToken) {
+		t.Errorf("no nonce: expected ErrBadToken, got %v", err)
+	}
+}
+
+func TestBadClaims(t *testing.T) {
+	iss, p := newIssuer(t)
+
+	for name, c := range map[string]struct {
+		tamper func(claims map[string]any)
+		valid  bool
+	}{
+		"another audience":                 {func(c map[string]any) { c["aud"] = "someone-else" }, false},
+		"no audience":                      {func(c map[string]any) { delete(c, "aud") }, false},
+		"other audiences without azp":      {func(c map[string]any) { c["aud"] = []string{testClientID, "someone-else"} }, false},
+		"other audiences with another azp": {func(c map[string]any) { c["aud"] = []string{testClientID, "someone-else"}; c["azp"] = "someone-else" }, false},
+		"other audiences with azp":         {func(c map[string]any) { c["aud"] = []string{testClientID, "someone-else"}; c["azp"] = testClientID }, true},
+		"another azp":                      {func(c map[string]any) { c["azp"] = "someone-else" }, false},
+		"azp":                              {func(c map[string]any) { c["azp"] = testClientID }, true},
+		"another issuer":                   {func(c map[string]any) { c["iss"] = "https://evil.test" }, false},
+		"no subject":                       {func(c map[string]any) { delete(c, "sub") }, false},
+		"expired":                          {func(c map[string]any) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }, false},
+		"expired within the clock skew":    {func(c map[string]any) { c["exp"] = time.Now().Add(-30 * time.Second).Unix() }, true},
+		"no expiry":                        {func(c map[string]any) { delete(c, "exp") }, false},
+		"issued in the future":             {func(c map[string]any) { c["iat"] = time.Now().Add(5 * time.Minute).Unix() }, false},
+	} {
+		tamper := c.tamper
+		iss.Tamper = func(header, claims map[string]any) { tamper(claims) }
+
+		_, err := signIn(t, iss, p, newSession(t))
+
+		if c.valid && err != nil {
+			t.Errorf("%s: expected the token to be accepted, got %v", name, err)
+		}
+		if !c.valid && !errors.Is(err, oidc.ErrBadToken) {
+			t.Errorf("%s: expected ErrBadToken, got %v", name, err)
+		}
+	}
+}
+
+func TestBadSignature(t *testing.T) {
+	iss, p := newIssuer(t)
+
+	for name, tamper := range map[string]func(header, claims map[string]any){
+		"no algorithm":     func(h, c map[string]any) { h["alg"] = "none" },
+		"HS256":            func(h, c map[string]any) { h["alg"] = "HS256" },
+		"another key's ID": func(h, c map[string]any) { h["kid"] = oidctest.KeyRS256 },
+	} {
+		iss.Tamper = tamper
+
+		if _, err := signIn(t, iss, p, newSession(t)); !errors.Is(err, oidc.ErrBadToken) {
+			t.Errorf("%s: expected ErrBadToken, got %v", name, err)
+		}
+	}
+
+	// Signed by another issuer's key with the same ID
+	other := oidctest.New(testClientID)
+	defer other.Close()
+
+	if _, err := p.VerifyIDToken(context.Background(), other.Sign(map[string]any{"iss": iss.URL()}), newSession(t)); !errors.Is(err, oidc.ErrBadToken) {
+		t.Errorf("another key: expected ErrBadToken, got %v", err)
+	}
+}
+
+func TestUnknownKey(t *testing.T) {
+	iss, p := newIssuer(t)
+	iss.Tamper = func(header, claims map[string]any) { header["kid"] = "rotated" }
+
+	for i := 0; i < 3; i++ {
+		if _, err := signIn(t, iss, p, newSession(t)); !errors.Is(err, oidc.ErrUnknownKey) {
+			t.Errorf("an unknown key: expected ErrUnknownKey, got %v", err)
+		}
+	}
+
+	// Unknown keys re-fetch the JWKS, but not on every token
+	if fetches := iss.JWKSFetches(); fetches != 1 {
+		t.Errorf("the JWKS was fetched %d times", fetches)
+	}
+
+	iss.Tamper = nil
+
+	if _, err := signIn(t, iss, p, newSession(t)); err != nil {
+		t.Errorf("a known key after an unknown one: %v", err)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	iss, p := newIssuer(t)
+	ctx := context.Background()
+
+	s := newSession(t)
+	s.MaxAge = 5 * time.Minute
+
+	u, err := p.AuthURL(ctx, s)
+	if err != nil {
+		t.Fatalf("creating the auth URL: %v", err)
+	}
+
+	q, _ := url.Parse(u)
+	if q.Query().Get("prompt") != "login" || q.Query().Get("max_age") != "300" {
+		t.Errorf("no new sign in was asked for: %s", u)
+	}
+
+	if _, err := signIn(t, iss, p, s); err != nil {
+		t.Errorf("a new sign in: %v", err)
+	}
+
+	// An issuer that ignores prompt=login, & reuses a sign in from an hour ago
+	for name, tamper := range map[string]func(header, claims map[string]any){
+		"an old sign in": func(h, c map[string]any) { c["auth_time"] = iss.SessionStart.Unix() },
+		"no auth time":   func(h, c map[string]any) { delete(c, "auth_time") },
+	} {
+		iss.Tamper = tamper
+
+		s := newSession(t)
+		s.MaxAge = 5 * time.Minute
+
+		if _, err := signIn(t, iss, p, s); !errors.Is(err, oidc.ErrBadToken) {
+			t.Errorf("%s: expected ErrBadToken, got %v", name, err)
+		}
+
+		// Fine when a new sign in wasn't asked for
+		if _, err := signIn(t, iss, p, newSession(t)); err != nil {
+			t.Errorf("%s without a max age: %v", name, err)
+		}
+	}
+}
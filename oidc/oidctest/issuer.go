@@ -0,0 +1,313 @@
+package oidctest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/shadiestgoat/who/oidc"
+)
+
+// A fake identity provider, for testing sign ins without a real one.
+// It serves discovery, a JWKS (an ES256 & an RS256 key) and a token endpoint, like a real provider would.
+// The authorization endpoint signs in whoever Subject is right away, & sends them back to the redirect URL with a code.
+// The token endpoint checks the code, the redirect URL & the PKCE verifier, then returns an ID token signed with Alg.
+// Change the token before it's signed with Tamper, to produce bad ones (ie. another audience or an expired token).
+
+const (
+	KeyES256 = "es256"
+	KeyRS256 = "rs256"
+)
+
+type Issuer struct {
+	Server   *httptest.Server
+	ClientID string
+
+	// The account that signs in
+	Subject string
+	// ES256 or RS256
+	Alg string
+	// When the user signed in at the issuer, used as auth_time unless the client asks for a new sign in (prompt=login)
+	SessionStart time.Time
+
+	// Changes the header & claims of ID tokens before they are signed
+	Tamper func(header, claims map[string]any)
+
+	ecKey  *ecdsa.PrivateKey
+	rsaKey *rsa.PrivateKey
+
+	lock   sync.Mutex
+	grants map[string]*grant
+	// How many times the JWKS was fetched
+	jwksFetches int
+}
+
+type grant struct {
+	redirectURL string
+	challenge   string
+	nonce       string
+	subject     string
+	authTime    time.Time
+}
+
+func New(clientID string) *Issuer {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("oidctest: generating a key: " + err.Error())
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("oidctest: generating a key: " + err.Error())
+	}
+
+	iss := &Issuer{
+		ClientID:     clientID,
+		Subject:      "subject",
+		Alg:          "ES256",
+		SessionStart: time.Now().Add(-time.Hour),
+		ecKey:        ecKey,
+		rsaKey:       rsaKey,
+		grants:       map[string]*grant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", iss.discovery)
+	mux.HandleFunc("/jwks", iss.jwks)
+	mux.HandleFunc("/authorize", iss.authorize)
+	mux.HandleFunc("/token", iss.token)
+
+	iss.Server = httptest.NewServer(mux)
+
+	return iss
+}
+
+func (iss *Issuer) Close() {
+	iss.Server.Close()
+}
+
+func (iss *Issuer) URL() string {
+	return iss.Server.URL
+}
+
+// A provider for this issuer, using the server's client
+func (iss *Issuer) Provider(name, redirectURL string) *oidc.Provider {
+	return &oidc.Provider{
+		Name:        name,
+		Issuer:      iss.URL(),
+		ClientID:    iss.ClientID,
+		RedirectURL: redirectURL,
+		Client:      iss.Server.Client(),
+	}
+}
+
+func (iss *Issuer) JWKSFetches() int {
+	iss.lock.Lock()
+	defer iss.lock.Unlock()
+
+	return iss.jwksFetches
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (iss *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 iss.URL(),
+		"authorization_endpoint": iss.URL() + "/authorize",
+		"token_endpoint":         iss.URL() + "/token",
+		"jwks_uri":               iss.URL() + "/jwks",
+	})
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (iss *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	iss.lock.Lock()
+	iss.jwksFetches++
+	iss.lock.Unlock()
+
+	x, y := make([]byte, 32), make([]byte, 32)
+	iss.ecKey.X.FillBytes(x)
+	iss.ecKey.Y.FillBytes(y)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{
+			{"kty": "EC", "kid": KeyES256, "use": "sig", "alg": "ES256", "crv": "P-256", "x": b64(x), "y": b64(y)},
+			{"kty": "RSA", "kid": KeyRS256, "use": "sig", "alg": "RS256", "n": b64(iss.rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(iss.rsaKey.E)).Bytes())},
+		},
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return b64(b)
+}
+
+func (iss *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	if q.Get("response_type") != "code" || q.Get("client_id") != iss.ClientID || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	authTime := iss.SessionStart
+	if q.Get("prompt") == "login" {
+		authTime = time.Now()
+	}
+
+	code := randomString()
+
+	iss.lock.Lock()
+	iss.grants[code] = &grant{
+		redirectURL: redirect.String(),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		subject:     iss.Subject,
+		authTime:    authTime,
+	}
+	iss.lock.Unlock()
+
+	back := redirect.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirect.RawQuery = back.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (iss *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+
+	// Codes are single use, even when the exchange fails
+	iss.lock.Lock()
+	g := iss.grants[code]
+	delete(iss.grants, code)
+	iss.lock.Unlock()
+
+	if g == nil || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("client_id") != iss.ClientID || r.PostForm.Get("redirect_uri") != g.redirectURL {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	if oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"id_token": iss.Sign(map[string]any{
+			"iss":                iss.URL(),
+			"sub":                g.subject,
+			"aud":                iss.ClientID,
+			"exp":                now.Add(5 * time.Minute).Unix(),
+			"iat":                now.Unix(),
+			"auth_time":          g.authTime.Unix(),
+			"nonce":              g.nonce,
+			"email":              g.subject + "@who.test",
+			"email_verified":     true,
+			"preferred_username": g.subject,
+		}),
+	})
+}
+
+// Signs an ID token with Alg, after Tamper
+func (iss *Issuer) Sign(claims map[string]any) string {
+	header := map[string]any{
+		"alg": iss.Alg,
+		"typ": "JWT",
+		"kid": KeyES256,
+	}
+	if iss.Alg == "RS256" {
+		header["kid"] = KeyRS256
+	}
+
+	if iss.Tamper != nil {
+		iss.Tamper(header, claims)
+	}
+
+	rawHeader, _ := json.Marshal(header)
+	rawClaims, _ := json.Marshal(claims)
+
+	signed := b64(rawHeader) + "." + b64(rawClaims)
+	digest := sha256.Sum256([]byte(signed))
+
+	sig := []byte{}
+
+	switch iss.Alg {
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, iss.ecKey, digest[:])
+		if err != nil {
+			panic("oidctest: signing: " + err.Error())
+		}
+
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case "RS256":
+		var err error
+
+		sig, err = rsa.SignPKCS1v15(rand.Reader, iss.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			panic("oidctest: signing: " + err.Error())
+		}
+	}
+
+	return signed + "." + b64(sig)
+}
+
+// Follows the auth URL like a browser would, signing in as Subject. Returns the code & state the issuer sent back to the redirect URL.
+func (iss *Issuer) Login(authURL string) (code, state string, err error) {
+	c := *iss.Server.Client()
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := c.Get(authURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	resp.Body.Close()
+
+	loc, err := resp.Location()
+	if err != nil {
+		return "", "", err
+	}
+
+	return loc.Query().Get("code"), loc.Query().Get("state"), nil
+}
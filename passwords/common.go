@@ -0,0 +1,93 @@
+package passwords
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/shadiestgoat/log"
+)
+
+// The most common passwords & words in them, most common first. The rank of a word is how many guesses it takes to get to it.
+// This is only the top of the list, so passwords made of less common words score too high.
+// A full frequency list can be loaded with COMMON_PASSWORDS_FILE: 1 password or word per line, most common first (ie. the zxcvbn or SecLists lists).
+const commonList = `
+123456 password 123456789 12345678 12345 qwerty 1234567 111111 1234567890 123123
+abc123 1234 password1 iloveyou 1q2w3e4r 000000 qwerty123 zaq12wsx dragon sunshine
+princess letmein 654321 monkey 1qaz2wsx 123321 qwertyuiop superman asdfghjkl trustno1
+football baseball welcome shadow master michael jordan hunter ranger buster
+soccer harley batman andrew tigger charlie robert thomas hockey killer
+george andrea joshua daniel jessica pepper ashley nicole matthew amanda
+freedom whatever hello secret passw0rd 666666 121212 7777777 888888 112233
+987654321 qwer1234 asdf1234 zxcvbnm asdfgh zxcvbn qazwsx 1q2w3e 1qazxsw2 q1w2e3r4
+555555 222222 333333 444444 999999 11111111 00000000 12341234 123qwe 159753
+147258369 147258 123654 789456 456789 987654 741852963 159357 a1b2c3 aaaaaa
+admin administrator login root guest user test test123 default changeme
+starwars pokemon minecraft computer internet samsung google facebook apple
+summer winter spring autumn flower butterfly purple orange banana cookie
+cheese chocolate sweet angel baby love lovely loveme iloveu friend friends
+forever family heaven jesus blessed god lucky happy smile money
+dragon1 monkey1 shadow1 master1 sunshine1 princess1 football1 baseball1 welcome1 letmein1
+hello123 abcd1234 abcdef abcdefg abcdefgh password123 password12 pass1234 pass word
+secret1 qwerty1 qwertyu iloveyou1 mustang access maggie jennifer michelle
+daniel1 jordan23 liverpool chelsea arsenal barcelona realmadrid juventus yankees cowboys
+dallas boston london paris berlin america canada mexico texas florida
+silver golden diamond crystal ginger tiger lion eagle falcon wolf
+cat dog horse bear snake fish bird mouse rabbit puppy
+red blue green black white yellow pink brown grey gray
+one two three four five six seven eight nine ten
+name first last boy girl man woman king queen prince
+good bad best cool nice hot star moon sun sky
+fire water earth wind storm thunder rain snow ice light
+dark night day time life death world home house dream
+magic power energy music rock metal party game player
+ninja pirate zombie hacker matrix merlin gandalf phoenix wizard knight
+`
+
+var commonRanks = map[string]int{}
+
+func init() {
+	for i, w := range strings.Fields(commonList) {
+		if _, ok := commonRanks[w]; !ok {
+			commonRanks[w] = i + 1
+		}
+	}
+}
+
+func initCommon() {
+	path := strings.TrimSpace(os.Getenv("COMMON_PASSWORDS_FILE"))
+	if path == "" {
+		return
+	}
+
+	f, err := os.Open(path)
+	log.FatalIfErr(err, "opening COMMON_PASSWORDS_FILE")
+
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	rank := 0
+
+	for s.Scan() {
+		w := strings.ToLower(strings.TrimSpace(s.Text()))
+		if w == "" {
+			continue
+		}
+
+		rank++
+
+		// Shorter words are never looked up, see dictionaryMatches
+		if l := utf8.RuneCountInString(w); l < 3 || l > maxWordLen {
+			continue
+		}
+
+		if r, ok := commonRanks[w]; !ok || rank < r {
+			commonRanks[w] = rank
+		}
+	}
+
+	log.FatalIfErr(s.Err(), "reading COMMON_PASSWORDS_FILE")
+
+	log.Debug("Loaded %d common passwords", rank)
+}
@@ -0,0 +1,88 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shadiestgoat/log"
+)
+
+// Password checks that don't depend on the user: how guessable a password is (see Estimate), & whether it showed up in a breach.
+//
+// Init loads the policy (see Policy), the common passwords list (see common.go) & the breached passwords.
+
+// A list of breached passwords, searched the k-anonymity way: by the 1st 5 hex characters of the SHA-1 hash
+type BreachedList interface {
+	// How many times the password showed up in breaches
+	Count(password string) (int, error)
+}
+
+// nil if BREACHED_PASSWORDS_DIR is not set
+var Breached BreachedList
+
+func Init() {
+	initPolicy()
+	initCommon()
+
+	dir := strings.TrimSpace(os.Getenv("BREACHED_PASSWORDS_DIR"))
+	if dir == "" {
+		return
+	}
+
+	info, err := os.Stat(dir)
+	log.FatalIfErr(err, "opening BREACHED_PASSWORDS_DIR")
+
+	if !info.IsDir() {
+		log.Fatal("BREACHED_PASSWORDS_DIR has to be a directory")
+	}
+
+	Breached = RangeDir(dir)
+}
+
+// A local copy of the Pwned Passwords range files: a file per hash prefix, named {PREFIX} or {PREFIX}.txt,
+// with lines of {the other 35 hex characters}:{count}. Only the file of the password's prefix is read.
+type RangeDir string
+
+func (d RangeDir) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(string(d), prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		f, err = os.Open(filepath.Join(string(d), prefix+".txt"))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+
+	for s.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(s.Text()), ":")
+		if !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			// Some lists don't include counts
+			return 1, nil
+		}
+
+		return n, nil
+	}
+
+	return 0, s.Err()
+}
@@ -0,0 +1,58 @@
+package passwords
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/shadiestgoat/log"
+)
+
+// The rules new passwords have to follow (see api.checkNewPassword). Passwords are used exactly as typed, spaces included.
+type Policy struct {
+	// In characters
+	MinLength int `json:"minLength"`
+	MaxLength int `json:"maxLength"`
+	// The least score (0 - 4) of Strength.
+	// The default is low, since the built in dictionary only has the most common passwords & words. Raise it once COMMON_PASSWORDS_FILE is set.
+	MinStrength int `json:"minStrength"`
+	// If Breached is configured, passwords that showed up in breaches at least this many times are refused. 0 turns it off.
+	BreachedCount int `json:"breachedCount"`
+}
+
+// The max length can't be lower than this, so that password managers & passphrases always fit
+const maxLengthFloor = 128
+
+// Set by Init, from PASSWORD_{MIN_LENGTH|MAX_LENGTH|MIN_STRENGTH|BREACHED_COUNT}
+var Rules = Policy{
+	MinLength:     8,
+	MaxLength:     256,
+	MinStrength:   1,
+	BreachedCount: 1,
+}
+
+func initPolicy() {
+	num := func(kind string, def, min, max int) int {
+		key := "PASSWORD_" + kind
+
+		v := strings.TrimSpace(os.Getenv(key))
+		if v == "" {
+			return def
+		}
+
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min || n > max {
+			log.Warn("%s has to be a number from %d to %d, using %d", key, min, max, def)
+			return def
+		}
+
+		return n
+	}
+
+	Rules = Policy{
+		MinLength:     num("MIN_LENGTH", 8, 1, maxLengthFloor),
+		MaxLength:     num("MAX_LENGTH", 256, maxLengthFloor, 4096),
+		MinStrength:   num("MIN_STRENGTH", 1, 0, 4),
+		BreachedCount: num("BREACHED_COUNT", 1, 0, 1<<30),
+	}
+}
@@ -0,0 +1,536 @@
+package passwords
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A zxcvbn style strength estimate. The password is split into the patterns an attacker would try first
+// (common passwords & words, keyboard walks, sequences, repeats & dates), and the guesses needed for the cheapest split are counted.
+// Guesses are kept as log10, since they get big fast.
+
+type Estimate struct {
+	// log10 of the amount of guesses needed
+	Guesses float64 `json:"guesses"`
+	// 0 (very guessable) to 4 (very unguessable)
+	Score int `json:"score"`
+}
+
+// Only the start of longer passwords is looked at, anything this long is plenty strong anyway
+const maxEstimateLen = 100
+
+// log10 of the guesses that each extra pattern in a split costs at least
+const segmentGuesses = 4
+
+// A pattern between runes i (inclusive) & j (exclusive)
+type match struct {
+	i, j    int
+	guesses float64
+}
+
+// userInputs are words the attacker would know to try, ie. the username & the name of the site
+func Strength(password string, userInputs ...string) *Estimate {
+	runes := []rune(password)
+	if len(runes) > maxEstimateLen {
+		runes = runes[:maxEstimateLen]
+	}
+
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	inputs := map[string]int{}
+	for i, w := range userInputs {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if _, ok := inputs[w]; !ok && w != "" {
+			inputs[w] = i + 1
+		}
+	}
+
+	matches := dictionaryMatches(runes, lower, inputs)
+	matches = append(matches, sequenceMatches(lower)...)
+	matches = append(matches, repeatMatches(runes, lower, userInputs)...)
+	matches = append(matches, spatialMatches(runes)...)
+	matches = append(matches, dateMatches(lower)...)
+
+	g := cheapestSplit(len(runes), matches)
+
+	return &Estimate{
+		Guesses: g,
+		Score:   score(g),
+	}
+}
+
+func score(guesses float64) int {
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	}
+
+	return 4
+}
+
+// Finds the split of the password into patterns & brute forced parts that takes the least guesses.
+// Like zxcvbn, a split of l parts costs l! * (product of the parts) + 10^(segmentGuesses * (l-1)).
+func cheapestSplit(n int, matches []*match) float64 {
+	if n == 0 {
+		return 0
+	}
+
+	byEnd := make([][]*match, n+1)
+	for _, m := range matches {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+
+	inf := math.Inf(1)
+
+	// best[j][l]: the least guesses for the 1st j runes, split into l parts
+	best := make([][]float64, n+1)
+	for j := range best {
+		best[j] = make([]float64, n+1)
+		for l := range best[j] {
+			best[j][l] = inf
+		}
+	}
+	best[0][0] = 0
+
+	for j := 1; j <= n; j++ {
+		for l := 1; l <= j; l++ {
+			for i := 0; i < j; i++ {
+				if prev := best[i][l-1]; prev != inf {
+					best[j][l] = math.Min(best[j][l], prev+bruteforceGuesses(j-i))
+				}
+			}
+
+			for _, m := range byEnd[j] {
+				if prev := best[m.i][l-1]; prev != inf {
+					best[j][l] = math.Min(best[j][l], prev+math.Max(m.guesses, minGuesses(m.j-m.i)))
+				}
+			}
+		}
+	}
+
+	total := inf
+	factorial := 0.0
+
+	for l := 1; l <= n; l++ {
+		factorial += math.Log10(float64(l))
+
+		if best[n][l] == inf {
+			continue
+		}
+
+		total = math.Min(total, logAdd(factorial+best[n][l], float64(l-1)*segmentGuesses))
+	}
+
+	return total
+}
+
+// log10(10^a + 10^b)
+func logAdd(a, b float64) float64 {
+	if a < b {
+		a, b = b, a
+	}
+
+	return a + math.Log10(1+math.Pow(10, b-a))
+}
+
+func bruteforceGuesses(l int) float64 {
+	return math.Max(float64(l), minGuesses(l)+0.01)
+}
+
+func minGuesses(l int) float64 {
+	if l == 1 {
+		return 1
+	}
+
+	return math.Log10(50)
+}
+
+var leet = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '3': 'e', '9': 'g', '1': 'i', '!': 'i',
+	'|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't',
+}
+
+// Undoes l33t substitutions, returning the amount of substituted runes. one is what 1 stands for, since it's often i or l.
+func unleet(word []rune, one rune) (string, int) {
+	b := strings.Builder{}
+	subs := 0
+
+	for _, r := range word {
+		if s, ok := leet[r]; ok {
+			if r == '1' {
+				s = one
+			}
+
+			r = s
+			subs++
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String(), subs
+}
+
+func reverse(word []rune) string {
+	b := strings.Builder{}
+
+	for i := len(word) - 1; i >= 0; i-- {
+		b.WriteRune(word[i])
+	}
+
+	return b.String()
+}
+
+// log10 of the ways the word could have been capitalized
+func uppercaseGuesses(word []rune) float64 {
+	upper, lower := 0, 0
+
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+
+	last := len(word) - 1
+
+	switch {
+	case upper == 0:
+		return 0
+	case lower == 0, upper == 1 && (unicode.IsUpper(word[0]) || unicode.IsUpper(word[last])):
+		return math.Log10(2)
+	}
+
+	ways := 0.0
+	for k := 1; k <= upper && k <= lower; k++ {
+		ways += binomial(upper+lower, k)
+	}
+
+	return math.Log10(ways)
+}
+
+func binomial(n, k int) float64 {
+	r := 1.0
+	for i := 1; i <= k; i++ {
+		r = r * float64(n-k+i) / float64(i)
+	}
+
+	return r
+}
+
+// The longest word looked up
+const maxWordLen = 30
+
+func dictionaryMatches(runes, lower []rune, inputs map[string]int) []*match {
+	matches := []*match{}
+
+	rank := func(w string) (int, bool) {
+		if r, ok := inputs[w]; ok {
+			return r, true
+		}
+
+		r, ok := commonRanks[w]
+
+		return r, ok
+	}
+
+	for i := range lower {
+		for j := i + 3; j <= len(lower) && j-i <= maxWordLen; j++ {
+			word := lower[i:j]
+			best := math.Inf(1)
+
+			try := func(w string, extra float64) {
+				if r, ok := rank(w); ok {
+					best = math.Min(best, math.Log10(float64(r))+extra)
+				}
+			}
+
+			try(string(word), 0)
+			try(reverse(word), math.Log10(2))
+
+			for _, one := range []rune{'i', 'l'} {
+				if w, subs := unleet(word, one); subs > 0 {
+					// Every substitution could have been made or not
+					try(w, float64(subs)*math.Log10(2))
+				}
+			}
+
+			if !math.IsInf(best, 1) {
+				matches = append(matches, &match{i, j, best + uppercaseGuesses(runes[i:j])})
+			}
+		}
+	}
+
+	return matches
+}
+
+// Runs like abc, 123 & zyx
+func sequenceMatches(lower []rune) []*match {
+	matches := []*match{}
+
+	for i := 0; i+2 < len(lower); {
+		delta := lower[i+1] - lower[i]
+		j := i + 1
+
+		if delta == 1 || delta == -1 {
+			for j+1 < len(lower) && lower[j+1]-lower[j] == delta {
+				j++
+			}
+		}
+
+		if j-i+1 < 3 {
+			i++
+			continue
+		}
+
+		base := 26.0
+		if unicode.IsDigit(lower[i]) {
+			base = 10
+		}
+		if strings.ContainsRune("a1z9", lower[i]) {
+			base = 4
+		}
+
+		g := math.Log10(base * float64(j-i+1))
+		if delta < 0 {
+			g += math.Log10(2)
+		}
+
+		matches = append(matches, &match{i, j + 1, g})
+
+		i = j
+	}
+
+	return matches
+}
+
+func runesEqual(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// If the chunk isn't a shorter chunk repeated
+func primitive(chunk []rune) bool {
+	for l := 1; l < len(chunk); l++ {
+		if len(chunk)%l != 0 {
+			continue
+		}
+
+		repeated := true
+		for i := l; i < len(chunk) && repeated; i += l {
+			repeated = runesEqual(chunk[i:i+l], chunk[:l])
+		}
+
+		if repeated {
+			return false
+		}
+	}
+
+	return true
+}
+
+// log10 of the possible values of a rune
+func cardinality(r rune) float64 {
+	switch {
+	case unicode.IsDigit(r):
+		return 1
+	case r < unicode.MaxASCII && unicode.IsLetter(r):
+		return math.Log10(26)
+	case r < unicode.MaxASCII:
+		return math.Log10(33)
+	}
+
+	return 2
+}
+
+// Repeated runes (aaa) & chunks (abcabc)
+func repeatMatches(runes, lower []rune, userInputs []string) []*match {
+	matches := []*match{}
+
+	for i := range lower {
+		for l := 1; i+2*l <= len(lower); l++ {
+			chunk := lower[i : i+l]
+			count := 1
+
+			for i+(count+1)*l <= len(lower) && runesEqual(lower[i+count*l:i+(count+1)*l], chunk) {
+				count++
+			}
+
+			// abab is already found as ab repeated, so only the shortest chunk is used
+			if count < 2 || (l == 1 && count < 3) || !primitive(chunk) {
+				continue
+			}
+
+			base := cardinality(chunk[0])
+			if l > 1 {
+				base = Strength(string(runes[i:i+l]), userInputs...).Guesses
+			}
+
+			matches = append(matches, &match{i, i + count*l, base + math.Log10(float64(count))})
+		}
+	}
+
+	return matches
+}
+
+// The QWERTY layout, unshifted & shifted. Rows are staggered by offset, so keys are next to each other if both their rows & columns are at most 1 apart.
+var (
+	keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+	shiftedRows  = []string{"~!@#$%^&*()_+", "QWERTYUIOP{}|", "ASDFGHJKL:\"", "ZXCVBNM<>?"}
+	rowOffsets   = []float64{0, 1.5, 1.75, 2.25}
+)
+
+type keyPos struct {
+	row     int
+	col     float64
+	shifted bool
+}
+
+var keyboard = map[rune]keyPos{}
+
+func init() {
+	for row := range keyboardRows {
+		for col, r := range keyboardRows[row] {
+			keyboard[r] = keyPos{row, float64(col) + rowOffsets[row], false}
+		}
+		for col, r := range shiftedRows[row] {
+			keyboard[r] = keyPos{row, float64(col) + rowOffsets[row], true}
+		}
+	}
+}
+
+// The amount of keys a walk can start from
+const keyboardStarts = 47
+
+// Keyboard walks like qwerty & qazwsx. Turns & shifted keys make walks harder to guess.
+func spatialMatches(runes []rune) []*match {
+	matches := []*match{}
+
+	adjacent := func(a, b rune) (dir [2]int, ok bool) {
+		pa, okA := keyboard[a]
+		pb, okB := keyboard[b]
+		dr, dc := pb.row-pa.row, pb.col-pa.col
+
+		if !okA || !okB || dr < -1 || dr > 1 || math.Abs(dc) > 1 || (dr == 0 && dc == 0) {
+			return dir, false
+		}
+
+		return [2]int{dr, int(math.Copysign(1, dc))}, true
+	}
+
+	for i := 0; i+2 < len(runes); {
+		j := i + 1
+		turns := 0
+		lastDir := [2]int{}
+
+		for j < len(runes) {
+			dir, ok := adjacent(runes[j-1], runes[j])
+			if !ok {
+				break
+			}
+			if j > i+1 && dir != lastDir {
+				turns++
+			}
+
+			lastDir = dir
+			j++
+		}
+
+		if j-i < 3 {
+			i++
+			continue
+		}
+
+		shifted := 0
+		for _, r := range runes[i:j] {
+			if keyboard[r].shifted {
+				shifted++
+			}
+		}
+
+		g := math.Log10(keyboardStarts*float64(j-i)) + float64(turns)*math.Log10(4) + float64(shifted)*math.Log10(2)
+		matches = append(matches, &match{i, j, g})
+
+		i = j - 1
+	}
+
+	return matches
+}
+
+// log10 of how many years an attacker would go through to get to this one
+func yearGuesses(year int) float64 {
+	return math.Log10(math.Max(math.Abs(float64(year-time.Now().Year())), 20))
+}
+
+func validDate(day, month int) bool {
+	return day >= 1 && day <= 31 && month >= 1 && month <= 12
+}
+
+// Years (1900 - 2099) & dates of only digits, ie. 31121999, 19991231, 311299
+func dateMatches(lower []rune) []*match {
+	matches := []*match{}
+
+	num := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+
+	for i := range lower {
+		for _, l := range []int{4, 6, 8} {
+			if i+l > len(lower) {
+				break
+			}
+
+			s := string(lower[i : i+l])
+			if strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }) != -1 {
+				break
+			}
+
+			switch l {
+			case 4:
+				if y := num(s); y >= 1900 && y <= 2099 {
+					matches = append(matches, &match{i, i + l, yearGuesses(y)})
+				}
+			case 6:
+				if validDate(num(s[:2]), num(s[2:4])) || validDate(num(s[2:4]), num(s[:2])) {
+					matches = append(matches, &match{i, i + l, math.Log10(365 * 100)})
+				}
+			case 8:
+				y := num(s[4:])
+				ok := y >= 1900 && y <= 2099 && (validDate(num(s[:2]), num(s[2:4])) || validDate(num(s[2:4]), num(s[:2])))
+
+				if y2 := num(s[:4]); y2 >= 1900 && y2 <= 2099 && validDate(num(s[6:]), num(s[4:6])) {
+					ok, y = true, y2
+				}
+
+				if ok {
+					matches = append(matches, &match{i, i + l, math.Log10(365) + yearGuesses(y)})
+				}
+			}
+		}
+	}
+
+	return matches
+}
@@ -1,28 +1,42 @@
 package router
 
 import (
+	"io"
 	"net/http"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/shadiestgoat/log"
 	"github.com/shadiestgoat/who/api"
-	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/config"
 )
 
 func MainRouter() http.Handler {
 	r := newRouter()
 
 	r.Mount(`/quizzes`, routerQuizzes())
+	r.Mount(`/quizzes/{quiz}/steps`, routerSteps())
 	r.Mount(`/previews`, routerPreview())
 	r.Mount(`/questions/{id}`, routerQuestions())
 	r.Mount(`/auth`, routerAuth())
 
+	r.Get(`/templates`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetTemplates()
+	})
+
+	// From the link in the confirmation email, see api.ConfirmNotifyEmail
+	r.Post(`/email-confirmations/{token}`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.ConfirmNotifyEmail(chi.URLParam(r, "token"))
+	})
+
 	return r
 }
 
 type reqNewQuiz struct {
-	Quiz      api.Quiz        `json:"quiz"`
-	Questions []*api.Question `json:"questions"`
+	Quiz      api.Quiz            `json:"quiz"`
+	Questions []*api.FullQuestion `json:"questions"`
+	// Optional, see api.NewQuiz
+	Template string `json:"template"`
 }
 
 func routerQuizzes() http.Handler {
@@ -39,14 +53,19 @@ func routerQuizzes() http.Handler {
 
 		body.Quiz.AuthorID = r.Context().Value(CTX_USER).(string)
 
-		return api.NewQuiz(&body.Quiz, body.Questions)
+		return api.RevealQuiz(api.NewQuiz(&body.Quiz, body.Questions, body.Template, actor(r)))
 	})
 
-	r.Handle("/{id}", routerQuizID())
+	r.Mount("/{id}", routerQuizID())
 
 	return r
 }
 
+type reqInvite struct {
+	Name string `json:"name"`
+}
+
+// Author only. Expects CTX_USER, ie. has to be mounted behind middlewareAuth
 func routerQuizID() http.Handler {
 	r := newRouter()
 
@@ -61,90 +80,236 @@ func routerQuizID() http.Handler {
 
 		body.ID = chi.URLParam(r, "id")
 
-		return api.EditQuiz(&body)
+		return api.RevealQuiz(api.EditQuiz(&body, actor(r)))
 	})
 
 	r.Delete("/", func(w http.ResponseWriter, r *http.Request) (any, error) {
-		return api.DeleteQuiz(chi.URLParam(r, "id"))
+		return api.RevealQuiz(api.DeleteQuiz(chi.URLParam(r, "id"), actor(r)))
 	})
 
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) (any, error) {
-		return api.GetQuiz(chi.URLParam(r, "id"))
+		return api.RevealQuiz(api.GetQuiz(chi.URLParam(r, "id")))
 	})
 
 	r.Get(`/questions`, func(w http.ResponseWriter, r *http.Request) (any, error) {
 		return api.GetQuestions(chi.URLParam(r, "id"))
 	})
 
+	r.Post(`/duplicate`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.RevealQuiz(api.DuplicateQuiz(chi.URLParam(r, "id"), actor(r)))
+	})
+
+	r.Post(`/publish`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.RevealQuiz(api.SetQuizStatus(chi.URLParam(r, "id"), api.QUIZ_PUBLISHED, actor(r)))
+	})
+
+	r.Post(`/unpublish`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.RevealQuiz(api.SetQuizStatus(chi.URLParam(r, "id"), api.QUIZ_DRAFT, actor(r)))
+	})
+
+	r.Post(`/archive`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.RevealQuiz(api.SetQuizStatus(chi.URLParam(r, "id"), api.QUIZ_ARCHIVED, actor(r)))
+	})
+
+	r.Get(`/stats`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetQuizStats(chi.URLParam(r, "id"))
+	})
+
+	r.Get(`/notifications`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetNotifySettings(chi.URLParam(r, "id"))
+	})
+
+	r.Post(`/notifications`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		body := api.NotifySettings{}
+
+		if err := unmarshalNotOk(w, r, &body); err != nil {
+			return nil, err
+		}
+
+		return api.SetNotifySettings(chi.URLParam(r, "id"), &body)
+	})
+
+	r.Get(`/messages`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetMessages(chi.URLParam(r, "id"))
+	})
+
+	r.Get(`/invites`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetInvites(chi.URLParam(r, "id"))
+	})
+
+	r.Post(`/invites`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		body := reqInvite{}
+
+		if err := unmarshalNotOk(w, r, &body); err != nil {
+			return nil, err
+		}
+
+		return api.NewInvite(chi.URLParam(r, "id"), body.Name)
+	})
+
+	r.Delete(`/invites/{invite}`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.RevokeInvite(chi.URLParam(r, "id"), chi.URLParam(r, "invite"))
+	})
+
+	// Play the quiz as the author, works for quizzes that aren't published
+	r.Get(`/preview`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return genPreview(chi.URLParam(r, "id"), true, "")
+	})
+
+	// The body is the raw file
+	r.Post(`/media`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		if r.Body == nil {
+			return nil, api.ErrBadBody
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, config.MEDIA_MAX_SIZE+1))
+		if err != nil {
+			return nil, api.ErrMediaTooBig
+		}
+
+		return api.UploadMedia(chi.URLParam(r, "id"), data)
+	})
+
+	r.Get(`/media`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetQuizMedia(chi.URLParam(r, "id"))
+	})
+
+	r.Mux.Get(`/media/{media}`, func(w http.ResponseWriter, r *http.Request) {
+		m, data, err := api.GetMedia(chi.URLParam(r, "id"), chi.URLParam(r, "media"))
+		wRespMedia(m, data, err, w)
+	})
+
+	r.Delete(`/media/{media}`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.DeleteMedia(chi.URLParam(r, "id"), chi.URLParam(r, "media"))
+	})
+
 	return r
 }
 
 type respPreview struct {
 	Question1 *api.Question `json:"question"`
 	Title     string `json:"title"`
+	// The play session, to be sent with the PLAY_SESSION_HEADER
+	Session string `json:"session"`
+}
+
+// authorPreview should only be true if the user is the author of the quiz, see api.NewPlay
+func genPreview(quizID string, authorPreview bool, invite string) (*respPreview, error) {
+	play, err := api.NewPlay(quizID, authorPreview, invite)
+
+	if err != nil {
+		return nil, err
+	}
+
+	chosenName, err := api.GetChosenName(quizID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := api.GetQuizFirstQuestion(quizID, play)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &respPreview{
+		Question1: q,
+		Title:     "Who the fuck is " + strings.ToUpper(chosenName[:1]) + chosenName[1:],
+		Session:   play,
+	}
+
+	return resp, nil
 }
 
 func routerPreview() http.Handler {
 	r := newRouter()
 
 	r.Get(`/{id}`, func(w http.ResponseWriter, r *http.Request) (any, error) {
-		quizID := chi.URLParam(r, "id")
-
-		chosenName := ""
-
-		err := db.QueryRowID(`SELECT chosenname[1] FROM quiz WHERE id = $1`, quizID, &chosenName)
+		return genPreview(chi.URLParam(r, "id"), false, r.URL.Query().Get("invite"))
+	})
 
-		if err != nil {
-			return nil, api.ErrDBHandle(err)
-		}
+	r.Get(`/{id}/card`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetCard(chi.URLParam(r, "id"), r.Header.Get(PLAY_SESSION_HEADER))
+	})
 
-		q, err := api.GetQuizFirstQuestion(quizID)
+	r.Post(`/{id}/messages`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		body := api.Message{}
 
-		if err != nil {
+		if err := unmarshalNotOk(w, r, &body); err != nil {
 			return nil, err
 		}
 
-		resp := &respPreview{
-			Question1: q,
-			Title:     "Who the fuck is " + strings.ToUpper(chosenName[:1]) + chosenName[1:],
+		return api.NewMessage(chi.URLParam(r, "id"), r.Header.Get(PLAY_SESSION_HEADER), &body)
+	})
+
+	// The play session can also be in the 'session' query param, so that this can be used directly as an image source
+	r.Mux.Get(`/{id}/media/{media}`, func(w http.ResponseWriter, r *http.Request) {
+		play := r.Header.Get(PLAY_SESSION_HEADER)
+		if play == "" {
+			play = r.URL.Query().Get("session")
 		}
 
-		return resp, nil
+		m, data, err := api.GetMediaForPlay(chi.URLParam(r, "id"), play, chi.URLParam(r, "media"))
+		wRespMedia(m, data, err, w)
 	})
 
 	return r
 }
 
-type reqAnswer struct {
-	Answer string `json:"answer"`
+// /quizzes/{quiz}/steps
+func routerSteps() http.Handler {
+	r := newRouter()
+
+	r.Get(`/{step}`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetQuestion(chi.URLParam(r, `quiz`), r.Header.Get(PLAY_SESSION_HEADER), chi.URLParam(r, `step`))
+	})
+
+	r.Post(`/{step}/answer`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		body := api.Answer{}
+
+		if err := unmarshalNotOk(w, r, &body); err != nil {
+			return nil, err
+		}
+
+		return api.AnswerQuestion(chi.URLParam(r, `quiz`), r.Header.Get(PLAY_SESSION_HEADER), chi.URLParam(r, `step`), &body)
+	})
+
+	return r
 }
 
 // /questions/{id}
+// Legacy, use /quizzes/{quiz}/steps
 func routerQuestions() http.Handler {
 	r := newRouter()
 
 	r.Use(middlewareQuestion)
 
 	r.Post(`/answer`, func(w http.ResponseWriter, r *http.Request) (any, error) {
-		body := reqAnswer{}
+		body := api.Answer{}
 
 		if err := unmarshalNotOk(w, r, &body); err != nil {
 			return nil, err
 		}
 
-		return api.AnswerQuestion(chi.URLParam(r, `id`), body.Answer)
+		return api.AnswerQuestion("", r.Header.Get(PLAY_SESSION_HEADER), chi.URLParam(r, `id`), &body)
 	})
 
 	r.With(middlewareAuth).With(middlewareQuestionAuth).Post(`/`, wrap(func(w http.ResponseWriter, r *http.Request) (any, error) {
+		step := r.Context().Value(CTX_STEP).(api.StepID)
+		if step.IsSpecial() {
+			return nil, api.ErrBadStepID
+		}
+
 		body := api.FullQuestion{}
 
 		if err := unmarshalNotOk(w, r, &body); err != nil {
 			return nil, err
 		}
-		
-		body.ID = chi.URLParam(r, "id")
 
-		return api.EditQuestion(&body)
+		body.ID = step.Question
+
+		return api.EditQuestion(&body, actor(r))
 	}))
 
 	return r
@@ -158,6 +323,13 @@ type reqAuth struct {
 type respAuth struct {
 	ID string `json:"id"`
 	Token string `json:"token"`
+	// Only set if the user has 2FA enabled, in which case ID & Token are empty. See api.ExchangeChallenge
+	Challenge string `json:"challenge,omitempty"`
+}
+
+type reqChallenge struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
 }
 
 func routerAuth() http.Handler {
@@ -170,7 +342,145 @@ func routerAuth() http.Handler {
 			return nil, err
 		}
 
-		id, token, err := api.Exchange(body.Username, body.Password)
+		id, token, challenge, err := api.Exchange(body.Username, body.Password, actor(r))
+
+		return &respAuth{
+			ID:        id,
+			Token:     token,
+			Challenge: challenge,
+		}, err
+	})
+
+	r.Post(`/2fa`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		body := reqChallenge{}
+
+		if err := unmarshalNotOk(w, r, &body); err != nil {
+			return nil, err
+		}
+
+		id, token, err := api.ExchangeChallenge(body.Challenge, body.Code, actor(r))
+
+		return &respAuth{
+			ID:    id,
+			Token: token,
+		}, err
+	})
+
+	// The rules for new passwords, see passwords.Policy
+	r.Get(`/password-policy`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetPasswordPolicy(), nil
+	})
+
+	r.Mount(`/me`, routerMe())
+	r.Mount(`/webauthn`, routerWebAuthn())
+	r.Mount(`/oidc/{provider}`, routerOIDC())
+
+	r.Get(`/erasures/{token}`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetErasure(chi.URLParam(r, "token"))
+	})
+
+	return r
+}
+
+type reqCode struct {
+	Code string `json:"code"`
+}
+
+type reqPasskey struct {
+	// Only used when registering
+	Name       string                 `json:"name"`
+	Credential *api.PasskeyCredential `json:"credential"`
+}
+
+// Binds an OIDC flow to the browser that started it, see api.StartOIDC
+const OIDC_BINDING_COOKIE = "oidc_binding"
+
+// userID is empty when logging in, see api.StartOIDC
+func startOIDC(w http.ResponseWriter, provider, userID string, reauth bool) (*api.OIDCStart, error) {
+	start, err := api.StartOIDC(provider, userID, reauth)
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDC_BINDING_COOKIE,
+		Value:    start.Binding,
+		Path:     "/",
+		MaxAge:   int(api.OIDCStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return start, nil
+}
+
+// /auth/oidc/{provider}
+func routerOIDC() http.Handler {
+	r := newRouter()
+
+	r.Post(`/start`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return startOIDC(w, chi.URLParam(r, "provider"), "", false)
+	})
+
+	// The provider's redirect URL passes on its query params here. Has to be called by the browser that started the flow, with its cookies.
+	// Returns the identity when linking, the reauth token when re-authenticating, otherwise the same as logging in.
+	r.Get(`/callback`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		q := r.URL.Query()
+
+		binding := ""
+		if c, err := r.Cookie(OIDC_BINDING_COOKIE); err == nil {
+			binding = c.Value
+		}
+
+		// Single use either way
+		http.SetCookie(w, &http.Cookie{
+			Name:     OIDC_BINDING_COOKIE,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		f, err := api.FinishOIDC(chi.URLParam(r, "provider"), q.Get("state"), binding, q.Get("code"), q.Get("error"), actor(r))
+		if err != nil {
+			return nil, err
+		}
+
+		if f.Linked != nil {
+			return f.Linked, nil
+		}
+		if f.Reauth != nil {
+			return f.Reauth, nil
+		}
+
+		return &respAuth{
+			ID:        f.ID,
+			Token:     f.Token,
+			Challenge: f.Challenge,
+		}, nil
+	})
+
+	return r
+}
+
+// /auth/webauthn
+func routerWebAuthn() http.Handler {
+	r := newRouter()
+
+	r.Post(`/login/start`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.StartPasskeyLogin()
+	})
+
+	r.Post(`/login/finish`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		body := reqPasskey{}
+
+		if err := unmarshalNotOk(w, r, &body); err != nil {
+			return nil, err
+		}
+
+		id, token, err := api.FinishPasskeyLogin(body.Credential, actor(r))
 
 		return &respAuth{
 			ID:    id,
@@ -178,5 +488,161 @@ func routerAuth() http.Handler {
 		}, err
 	})
 
+	r.Mount(`/register`, routerWebAuthnRegister())
+
+	return r
+}
+
+// /auth/webauthn/register
+func routerWebAuthnRegister() http.Handler {
+	r := newRouter()
+
+	r.Use(middlewareAuth)
+
+	// Re-authentication, the code is only needed if 2FA is on
+	r.Post(`/start`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		body := api.Reauth{}
+
+		if err := unmarshalNotOk(w, r, &body); err != nil {
+			return nil, err
+		}
+
+		return api.StartPasskeyRegistration(ctxUser(r), &body)
+	})
+
+	r.Post(`/finish`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		body := reqPasskey{}
+
+		if err := unmarshalNotOk(w, r, &body); err != nil {
+			return nil, err
+		}
+
+		return api.FinishPasskeyRegistration(ctxUser(r), body.Name, body.Credential, actor(r))
+	})
+
+	return r
+}
+
+// /auth/me
+func routerMe() http.Handler {
+	r := newRouter()
+
+	r.Use(middlewareAuth)
+
+	// JSON by default, ?format=zip for a ZIP that includes the media files
+	r.Mux.Get(`/export`, func(w http.ResponseWriter, r *http.Request) {
+		export, err := api.ExportUserData(ctxUser(r))
+		if err != nil {
+			wRespErr(err, w)
+			return
+		}
+
+		if r.URL.Query().Get("format") != "zip" {
+			w.Header().Set("Content-Disposition", `attachment; filename="export.json"`)
+			wResp(export, w)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="export.zip"`)
+
+		log.ErrorIfErr(export.WriteZip(w), "writing export zip")
+	})
+
+	// ?before={entryID} for older entries
+	r.Get(`/audit`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetAuditLog(ctxUser(r), r.URL.Query().Get("before"))
+	})
+
+	// For users without a password, see api.Reauth
+	r.Post(`/reauth/passkey/start`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.StartPasskeyReauth(ctxUser(r))
+	})
+
+	r.Post(`/reauth/passkey/finish`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		body := reqPasskey{}
+
+		if err := unmarshalNotOk(w, r, &body); err != nil {
+			return nil, err
+		}
+
+		return api.FinishPasskeyReauth(ctxUser(r), body.Credential, actor(r))
+	})
+
+	// Finished through /auth/oidc/{provider}/callback, like logging in
+	r.Post(`/reauth/oidc/{provider}`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return startOIDC(w, chi.URLParam(r, "provider"), ctxUser(r), true)
+	})
+
+	r.Get(`/2fa`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetTwoFactorStatus(ctxUser(r))
+	})
+
+	r.Post(`/2fa/totp`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.EnrollTOTP(ctxUser(r))
+	})
+
+	r.Post(`/2fa/totp/confirm`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		body := reqCode{}
+
+		if err := unmarshalNotOk(w, r, &body); err != nil {
+			return nil, err
+		}
+
+		return api.ConfirmTOTP(ctxUser(r), body.Code, actor(r))
+	})
+
+	r.Delete(`/2fa`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		body := api.Reauth{}
+
+		if err := unmarshalNotOk(w, r, &body); err != nil {
+			return nil, err
+		}
+
+		return api.DisableTOTP(ctxUser(r), &body, actor(r))
+	})
+
+	r.Post(`/2fa/recovery`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		body := api.Reauth{}
+
+		if err := unmarshalNotOk(w, r, &body); err != nil {
+			return nil, err
+		}
+
+		return api.RegenerateRecoveryCodes(ctxUser(r), &body, actor(r))
+	})
+
+	r.Get(`/passkeys`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetPasskeys(ctxUser(r))
+	})
+
+	r.Delete(`/passkeys/{passkeyID}`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.DeletePasskey(ctxUser(r), chi.URLParam(r, "passkeyID"), actor(r))
+	})
+
+	r.Get(`/identities`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.GetIdentities(ctxUser(r))
+	})
+
+	// Links a new identity to the user, see api.StartOIDC
+	r.Post(`/identities/{provider}`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return startOIDC(w, chi.URLParam(r, "provider"), ctxUser(r), false)
+	})
+
+	r.Delete(`/identities/{provider}/{subject}`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return api.UnlinkIdentity(ctxUser(r), chi.URLParam(r, "provider"), chi.URLParam(r, "subject"), actor(r))
+	})
+
+	// Erases the account, see api.RequestErasure
+	r.Delete(`/`, func(w http.ResponseWriter, r *http.Request) (any, error) {
+		body := api.Reauth{}
+
+		if err := unmarshalNotOk(w, r, &body); err != nil {
+			return nil, err
+		}
+
+		return api.RequestErasure(ctxUser(r), &body)
+	})
+
 	return r
 }
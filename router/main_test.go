@@ -0,0 +1,287 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/shadiestgoat/log"
+	"github.com/shadiestgoat/who/api"
+	"github.com/shadiestgoat/who/db"
+	"github.com/shadiestgoat/who/snownode"
+	"github.com/shadiestgoat/who/vault"
+)
+
+// Checks that dead names never reach public responses, through the whole router.
+// These need a throwaway database (TEST_DB_URI), the tables are created like on startup. Without it they are skipped.
+
+var dbReady = false
+
+func TestMain(m *testing.M) {
+	log.Init(log.NewLoggerPrint())
+
+	if uri := os.Getenv("TEST_DB_URI"); uri != "" {
+		os.Setenv("DB_URI", uri)
+		os.Setenv("ENCRYPTION_KEYS", "test:"+randomKey())
+		os.Setenv("ENCRYPTION_KEY_ID", "")
+		os.Setenv("ANSWER_DIGEST_KEY", randomKey())
+
+		vault.Init()
+		db.Init()
+
+		dbReady = true
+	}
+
+	os.Exit(m.Run())
+}
+
+func randomKey() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func needDB(t *testing.T) {
+	t.Helper()
+
+	if !dbReady {
+		t.Skip("TEST_DB_URI is not set")
+	}
+}
+
+type testClient struct {
+	t *testing.T
+	h http.Handler
+
+	// Sent as the Authorization header, if not empty
+	token string
+	// Sent as the PLAY_SESSION_HEADER, if not empty
+	play string
+}
+
+// Returns the status & the raw body
+func (c *testClient) do(method, path, body string) (int, []byte) {
+	c.t.Helper()
+
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = strings.NewReader(body)
+	}
+
+	req := httptest.NewRequest(method, path, reqBody)
+	if c.token != "" {
+		req.Header.Set("Authorization", c.token)
+	}
+	if c.play != "" {
+		req.Header.Set(PLAY_SESSION_HEADER, c.play)
+	}
+
+	w := httptest.NewRecorder()
+	c.h.ServeHTTP(w, req)
+
+	return w.Code, w.Body.Bytes()
+}
+
+// Same as do, but fails the test if the status isn't 200, & unmarshals the body into v
+func (c *testClient) ok(method, path, body string, v any) []byte {
+	c.t.Helper()
+
+	status, resp := c.do(method, path, body)
+	if status != 200 {
+		c.t.Fatalf("%s %s: expected 200, got %d: %s", method, path, status, resp)
+	}
+
+	if v != nil {
+		if err := json.Unmarshal(resp, v); err != nil {
+			c.t.Fatalf("%s %s: bad body: %v", method, path, err)
+		}
+	}
+
+	return resp
+}
+
+// Keys that only author responses are allowed to have (see api.RevealQuiz)
+var deadNameKeys = []string{"deadNames", "deadLastName"}
+
+func hasKey(v any, key string) bool {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, sub := range v {
+			if k == key || hasKey(sub, key) {
+				return true
+			}
+		}
+	case []any:
+		for _, sub := range v {
+			if hasKey(sub, key) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func assertNoDeadNames(t *testing.T, what string, body []byte, deadNames ...string) {
+	t.Helper()
+
+	lower := strings.ToLower(string(body))
+
+	for _, n := range deadNames {
+		if strings.Contains(lower, strings.ToLower(n)) {
+			t.Errorf("%s leaked the dead name '%s': %s", what, n, body)
+		}
+	}
+
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatalf("%s: bad body: %v", what, err)
+	}
+
+	for _, k := range deadNameKeys {
+		if hasKey(v, k) {
+			t.Errorf("%s has the '%s' key: %s", what, k, body)
+		}
+	}
+}
+
+const (
+	testDeadName     = "Deadnamey"
+	testDeadName2    = "Formerlyn"
+	testDeadLastName = "Oldsurname"
+	testChosenName   = "Lucy"
+	testNickname     = "Shadi"
+	testAnswer       = "blue"
+)
+
+func newTestUser(t *testing.T) string {
+	t.Helper()
+
+	password := randomKey()
+
+	_, token, err := api.NewUser("leak_"+snownode.Generate(), password)
+	if err != nil {
+		t.Fatalf("creating a user: %v", err)
+	}
+
+	return token
+}
+
+// Creates & publishes a quiz, returning its ID
+func newTestQuiz(t *testing.T, author *testClient, hashOnly bool) string {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]any{
+		"quiz": map[string]any{
+			"deadNames":    []string{testDeadName, testDeadName2},
+			"deadLastName": testDeadLastName,
+			"chosenNames":  []string{testChosenName},
+			"nickname":     testNickname,
+			"hashOnly":     hashOnly,
+			"pronouns":     []any{},
+		},
+		"questions": []map[string]any{
+			{"content": "What is {{their}} favourite colour?", "type": "text", "answers": []string{testAnswer}},
+			{"content": "What is {{their}} sky's colour?", "type": "text", "answers": []string{testAnswer}},
+			{"content": "What is {{their}} sea's colour?", "type": "text", "answers": []string{testAnswer}},
+		},
+	})
+
+	q := struct {
+		ID string `json:"id"`
+	}{}
+
+	author.ok("POST", "/quizzes", string(body), &q)
+	author.ok("POST", "/quizzes/"+q.ID+"/publish", "", nil)
+
+	return q.ID
+}
+
+func TestPublicRoutesHideDeadNames(t *testing.T) {
+	needDB(t)
+
+	for _, hashOnly := range []bool{false, true} {
+		name := "stored"
+		if hashOnly {
+			name = "hash only"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			testPublicRoutes(t, hashOnly)
+		})
+	}
+}
+
+func testPublicRoutes(t *testing.T, hashOnly bool) {
+	h := MainRouter()
+	deadNames := []string{testDeadName, testDeadName2, testDeadLastName}
+
+	author := &testClient{t: t, h: h, token: newTestUser(t)}
+	quizID := newTestQuiz(t, author, hashOnly)
+
+	viewer := &testClient{t: t, h: h}
+
+	preview := respPreview{}
+	assertNoDeadNames(t, "the preview", viewer.ok("GET", "/previews/"+quizID, "", &preview), deadNames...)
+
+	viewer.play = preview.Session
+	step := preview.Question1.ID
+
+	// Plays through every section: the dead name leads to section 3 at 2-sp, & the nickname completes the quiz at 3-sp
+	for i := 0; step != ""; i++ {
+		if i > 20 {
+			t.Fatalf("the quiz didn't end, stuck at '%s'", step)
+		}
+
+		path := "/quizzes/" + quizID + "/steps/" + step
+		assertNoDeadNames(t, path, viewer.ok("GET", path, "", nil), deadNames...)
+
+		answer := testAnswer
+		switch step {
+		case api.FormatStepID(2, "sp"):
+			answer = testDeadName
+		case api.FormatStepID(3, "sp"):
+			answer = testNickname
+		}
+
+		answerBody, _ := json.Marshal(api.Answer{Answer: answer})
+		resp := api.QuestionResp{}
+
+		assertNoDeadNames(t, path+"/answer", viewer.ok("POST", path+"/answer", string(answerBody), &resp), deadNames...)
+
+		if !resp.Correct {
+			t.Fatalf("%s: '%s' wasn't accepted", path, answer)
+		}
+
+		step = ""
+		if resp.Next != nil {
+			step = resp.Next.ID
+		}
+	}
+
+	card := api.Card{}
+	assertNoDeadNames(t, "the card", viewer.ok("GET", "/previews/"+quizID+"/card", "", &card), deadNames...)
+
+	if len(card.ChosenNames) != 1 || !strings.EqualFold(card.ChosenNames[0], testChosenName) {
+		t.Errorf("unexpected chosen names on the card: %v", card.ChosenNames)
+	}
+
+	assertNoDeadNames(t, "the templates", viewer.ok("GET", "/templates", "", nil), deadNames...)
+
+	// Without a token, & with someone else's
+	for _, c := range []*testClient{viewer, {t: t, h: h, token: newTestUser(t)}} {
+		status, body := c.do("GET", "/quizzes/"+quizID, "")
+		if status == 200 {
+			t.Errorf("the quiz is readable by someone else than the author: %s", body)
+		}
+
+		assertNoDeadNames(t, "the quiz of someone else", body, deadNames...)
+	}
+}
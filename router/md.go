@@ -2,6 +2,7 @@ package router
 
 import (
 	"context"
+	"net"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -11,16 +12,20 @@ import (
 
 type ctx int
 
+// The header in which viewers send their play session (see api.NewPlay)
+const PLAY_SESSION_HEADER = "Play-Session"
+
 const (
 	CTX_USER ctx = iota
 	CTX_QUESTION_AUTHOR
+	CTX_STEP
 )
 
 func middlewareAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := ""
 
-		err := db.QueryRowID(`SELECT id FROM users WHERE token = $1`, r.Header.Get("Authorization"), &id)
+		err := db.QueryRowID(`SELECT id FROM ppl WHERE token = $1`, r.Header.Get("Authorization"), &id)
 
 		if err != nil {
 			if db.NoRows(err) {
@@ -37,8 +42,34 @@ func middlewareAuth(next http.Handler) http.Handler {
 	})
 }
 
+// The user of the request, or an empty string if middlewareAuth wasn't used
+func ctxUser(r *http.Request) string {
+	user, _ := r.Context().Value(CTX_USER).(string)
+	return user
+}
+
+// The actor of the request, for the audit log (see api.Actor)
+func actor(r *http.Request) *api.Actor {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return &api.Actor{
+		User:      ctxUser(r),
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+	}
+}
+
 func middlewareQuiz(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := ctxUser(r)
+		if user == "" {
+			wRespErr(api.ErrNoAuth, w)
+			return
+		}
+
 		author := ""
 
 		err := db.QueryRowID(`SELECT author FROM quiz WHERE id = $1`, chi.URLParam(r, "id"), &author)
@@ -48,7 +79,7 @@ func middlewareQuiz(next http.Handler) http.Handler {
 			return
 		}
 
-		if author != r.Context().Value(CTX_USER).(string) {
+		if author != user {
 			wRespErr(api.ErrNoAuth, w)
 			return
 		}
@@ -61,26 +92,36 @@ func middlewareQuestion(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		author := ""
 
-		qID := chi.URLParam(r, "id")
-		qID = qID[:len(qID)-1]
+		step, err := api.ParseStepID(chi.URLParam(r, "id"))
+		if err != nil {
+			wRespErr(err, w)
+			return
+		}
 
-		err := db.QueryRowID(`SELECT quiz.author FROM questions JOIN quiz ON questions.quiz = quiz.id WHERE questions.id = $1`, qID, &author)
+		if step.IsSpecial() {
+			err = db.QueryRowID(`SELECT author FROM quiz WHERE id = $1`, step.Quiz, &author)
+		} else {
+			err = db.QueryRowID(`SELECT quiz.author FROM questions JOIN quiz ON questions.quiz = quiz.id WHERE questions.id = $1`, step.Question, &author)
+		}
 
 		if err != nil {
 			wRespErr(api.ErrDBHandle(err), w)
 			return
 		}
 
-		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), CTX_QUESTION_AUTHOR, author)))
+		ctx := context.WithValue(r.Context(), CTX_QUESTION_AUTHOR, author)
+		ctx = context.WithValue(ctx, CTX_STEP, step)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
 func middlewareQuestionAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user := r.Context().Value(CTX_USER).(string)
-		author := r.Context().Value(CTX_QUESTION_AUTHOR).(string)
+		user := ctxUser(r)
+		author, _ := r.Context().Value(CTX_QUESTION_AUTHOR).(string)
 
-		if user != author {
+		if user == "" || user != author {
 			wRespErr(api.ErrNoAuth, w)
 			return
 		}
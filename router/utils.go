@@ -54,6 +54,20 @@ func wRespErr(err error, w http.ResponseWriter) {
 	wResp(resp, w)
 }
 
+// Writes raw media instead of json
+func wRespMedia(m *api.Media, data []byte, err error, w http.ResponseWriter) {
+	if err != nil {
+		wRespErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", m.MIME)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+
+	w.Write(data)
+}
+
 func wrap(h handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		resp, err := h(w, r)
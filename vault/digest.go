@@ -0,0 +1,72 @@
+package vault
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/shadiestgoat/log"
+)
+
+// Keyed digests (HMAC-SHA256), for checking values without storing them (ie. accepted answers).
+// The key is configured with ANSWER_DIGEST_KEY (base64, at least 32 bytes). Changing it invalidates every stored digest.
+
+var ErrNoDigestKey = errors.New("no digest key configured")
+
+var digestKey []byte
+
+func initDigest() {
+	digestKey = nil
+
+	raw := strings.TrimSpace(os.Getenv("ANSWER_DIGEST_KEY"))
+	if raw == "" {
+		return
+	}
+
+	key, err := base64.StdEncoding.DecodeString(raw)
+	log.FatalIfErr(err, "decoding ANSWER_DIGEST_KEY")
+
+	if len(key) < keySize {
+		log.Fatal("ANSWER_DIGEST_KEY has to be at least %d bytes", keySize)
+	}
+
+	digestKey = key
+}
+
+func DigestEnabled() bool {
+	return len(digestKey) != 0
+}
+
+// Returns the hex digest of value
+func Digest(value string) (string, error) {
+	if !DigestEnabled() {
+		return "", ErrNoDigestKey
+	}
+
+	mac := hmac.New(sha256.New, digestKey)
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Checks if value matches one of the digests, in constant time per digest
+func MatchesDigest(value string, digests []string) bool {
+	d, err := Digest(value)
+	if err != nil {
+		return false
+	}
+
+	found := false
+
+	for _, o := range digests {
+		if hmac.Equal([]byte(d), []byte(o)) {
+			found = true
+		}
+	}
+
+	return found
+}
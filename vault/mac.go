@@ -0,0 +1,50 @@
+package vault
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"strings"
+
+	"github.com/shadiestgoat/log"
+)
+
+// Keyed MACs (HMAC-SHA256) of values that clients must not be able to compute themselves (ie. option IDs).
+// The key is configured with MAC_KEY (base64, at least 32 bytes).
+// If it isn't configured, a random key is used, which changes on every start.
+
+var macKey []byte
+
+func initMAC() {
+	macKey = nil
+
+	raw := strings.TrimSpace(os.Getenv("MAC_KEY"))
+	if raw == "" {
+		log.Warn("No MAC_KEY configured, using a random one. Option IDs will change when the server restarts!")
+
+		macKey = make([]byte, keySize)
+		_, err := rand.Read(macKey)
+		log.FatalIfErr(err, "generating a MAC key")
+
+		return
+	}
+
+	key, err := base64.StdEncoding.DecodeString(raw)
+	log.FatalIfErr(err, "decoding MAC_KEY")
+
+	if len(key) < keySize {
+		log.Fatal("MAC_KEY has to be at least %d bytes", keySize)
+	}
+
+	macKey = key
+}
+
+// Returns the raw MAC of value
+func MAC(value string) []byte {
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write([]byte(value))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,254 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/shadiestgoat/log"
+)
+
+// Envelope encryption for sensitive columns.
+//
+// Each row gets its own random data key, which encrypts the row's values (AES-256-GCM).
+// The data key is stored with the row, wrapped (encrypted) by a master key from the configuration, along with the master key's ID.
+//
+// Master keys are configured with:
+// ENCRYPTION_KEYS: comma separated {keyID}:{base64 32 byte key}. Old keys must be kept until every row is rotated.
+// ENCRYPTION_KEY_ID: the key used for new rows. Defaults to the 1st key in ENCRYPTION_KEYS.
+//
+// If no keys are configured, values are stored as plain text, with an empty key ID.
+//
+// Every value is bound to a context (e.g. {quizID}/deadname), which has to be the same when decrypting,
+// so a ciphertext can't be copied to another row or column.
+//
+// Init also loads the digest key (see digest.go) & the MAC key (see mac.go)
+
+var ErrUnknownKey = errors.New("unknown encryption key")
+var ErrBadCiphertext = errors.New("bad ciphertext")
+
+const keySize = 32
+
+var masterKeys = map[string]cipher.AEAD{}
+var currentKeyID = ""
+
+func Init() {
+	masterKeys = map[string]cipher.AEAD{}
+	currentKeyID = ""
+
+	for _, k := range strings.Split(os.Getenv("ENCRYPTION_KEYS"), ",") {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+
+		id, raw, ok := strings.Cut(k, ":")
+		if !ok || id == "" {
+			log.Fatal("Bad ENCRYPTION_KEYS format, expected {keyID}:{base64 key}")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(raw)
+		log.FatalIfErr(err, "decoding encryption key '%s'", id)
+
+		if len(key) != keySize {
+			log.Fatal("Encryption key '%s' has to be %d bytes", id, keySize)
+		}
+
+		aead, err := newAEAD(key)
+		log.FatalIfErr(err, "creating cipher for encryption key '%s'", id)
+
+		masterKeys[id] = aead
+
+		if currentKeyID == "" {
+			currentKeyID = id
+		}
+	}
+
+	if id := strings.TrimSpace(os.Getenv("ENCRYPTION_KEY_ID")); id != "" {
+		if masterKeys[id] == nil {
+			log.Fatal("ENCRYPTION_KEY_ID '%s' is not in ENCRYPTION_KEYS", id)
+		}
+
+		currentKeyID = id
+	}
+
+	if currentKeyID == "" {
+		log.Warn("No ENCRYPTION_KEYS configured, sensitive data will be stored as plain text!")
+	}
+
+	initDigest()
+	initMAC()
+}
+
+// The ID of the master key used for new envelopes. Empty if encryption is disabled.
+func CurrentKeyID() string {
+	return currentKeyID
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plain, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plain, additional), nil
+}
+
+func open(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, ErrBadCiphertext
+	}
+
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, ErrBadCiphertext
+	}
+
+	return plain, nil
+}
+
+// The data key of a single row
+type Envelope struct {
+	// Empty if the values are plain text
+	KeyID string
+	// The data key, encrypted by the master key
+	WrappedKey []byte
+
+	aead cipher.AEAD
+}
+
+// Creates an envelope with a new data key, wrapped by the current master key
+func NewEnvelope() (*Envelope, error) {
+	if currentKeyID == "" {
+		return &Envelope{}, nil
+	}
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := seal(masterKeys[currentKeyID], key, []byte(currentKeyID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		KeyID:      currentKeyID,
+		WrappedKey: wrapped,
+		aead:       aead,
+	}, nil
+}
+
+// Opens the envelope of an existing row. An empty keyID means the row is in plain text.
+func OpenEnvelope(keyID string, wrappedKey []byte) (*Envelope, error) {
+	if keyID == "" {
+		return &Envelope{}, nil
+	}
+
+	master := masterKeys[keyID]
+	if master == nil {
+		return nil, ErrUnknownKey
+	}
+
+	key, err := open(master, wrappedKey, []byte(keyID))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		KeyID:      keyID,
+		WrappedKey: wrappedKey,
+		aead:       aead,
+	}, nil
+}
+
+// Returns the base64 of the ciphertext, or the value itself if the envelope is plain text.
+// context is what the value belongs to, normally {row ID}/{column}.
+func (e *Envelope) Encrypt(value, context string) (string, error) {
+	if e.aead == nil {
+		return value, nil
+	}
+
+	data, err := seal(e.aead, []byte(value), []byte(context))
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawStdEncoding.EncodeToString(data), nil
+}
+
+// context has to be the same as the one the value was encrypted with
+func (e *Envelope) Decrypt(value, context string) (string, error) {
+	if e.aead == nil {
+		return value, nil
+	}
+
+	data, err := base64.RawStdEncoding.DecodeString(value)
+	if err != nil {
+		return "", ErrBadCiphertext
+	}
+
+	plain, err := open(e.aead, data, []byte(context))
+	if err != nil {
+		return "", err
+	}
+
+	return string(plain), nil
+}
+
+// Each value is bound to its index as well, as {context}/{index}
+func (e *Envelope) EncryptAll(values []string, context string) ([]string, error) {
+	out := make([]string, len(values))
+
+	for i, v := range values {
+		var err error
+
+		out[i], err = e.Encrypt(v, context+"/"+strconv.Itoa(i))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+func (e *Envelope) DecryptAll(values []string, context string) ([]string, error) {
+	out := make([]string, len(values))
+
+	for i, v := range values {
+		var err error
+
+		out[i], err = e.Decrypt(v, context+"/"+strconv.Itoa(i))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,56 @@
+package vault
+
+import (
+	"fmt"
+)
+
+// A string that must never end up in logs or API responses by accident (ie. a deadname).
+// It redacts itself when formatted (fmt, log), or marshalled (JSON, text). Use Reveal to get the actual value.
+type Sensitive string
+
+const REDACTED = "[redacted]"
+
+func (s Sensitive) Reveal() string {
+	return string(s)
+}
+
+func (s Sensitive) String() string {
+	return REDACTED
+}
+
+func (s Sensitive) GoString() string {
+	return REDACTED
+}
+
+// Covers every verb, including %d & %x, which don't use String
+func (s Sensitive) Format(f fmt.State, verb rune) {
+	f.Write([]byte(REDACTED))
+}
+
+func (s Sensitive) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + REDACTED + `"`), nil
+}
+
+func (s Sensitive) MarshalText() ([]byte, error) {
+	return []byte(REDACTED), nil
+}
+
+func RevealAll(s []Sensitive) []string {
+	out := make([]string, len(s))
+
+	for i, v := range s {
+		out[i] = v.Reveal()
+	}
+
+	return out
+}
+
+func WrapAll(s []string) []Sensitive {
+	out := make([]Sensitive, len(s))
+
+	for i, v := range s {
+		out[i] = Sensitive(v)
+	}
+
+	return out
+}
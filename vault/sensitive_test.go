@@ -0,0 +1,98 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/shadiestgoat/log"
+)
+
+const testSecret = "Deadnamey"
+
+type sensitiveHolder struct {
+	Name  Sensitive   `json:"name"`
+	Names []Sensitive `json:"names"`
+}
+
+func assertRedacted(t *testing.T, what, out string) {
+	t.Helper()
+
+	if strings.Contains(out, testSecret) {
+		t.Errorf("%s leaked the value: %s", what, out)
+	}
+	if !strings.Contains(out, REDACTED) {
+		t.Errorf("%s is not redacted: %s", what, out)
+	}
+}
+
+func TestSensitiveFormat(t *testing.T) {
+	s := Sensitive(testSecret)
+	holder := sensitiveHolder{Name: s, Names: []Sensitive{s}}
+
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%q", "%d", "%x", "%X", "%10s", "%-5v"} {
+		assertRedacted(t, verb, fmt.Sprintf(verb, s))
+		assertRedacted(t, verb+" of a pointer", fmt.Sprintf(verb, &s))
+	}
+
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%d"} {
+		assertRedacted(t, verb+" of a struct", fmt.Sprintf(verb, holder))
+		assertRedacted(t, verb+" of a slice", fmt.Sprintf(verb, holder.Names))
+	}
+
+	assertRedacted(t, "Sprint", fmt.Sprint(s))
+	assertRedacted(t, "Sprintln", fmt.Sprintln(s))
+	assertRedacted(t, "error wrapping", fmt.Errorf("bad name %v", s).Error())
+}
+
+func TestSensitiveJSON(t *testing.T) {
+	s := Sensitive(testSecret)
+
+	for name, v := range map[string]any{
+		"value":   s,
+		"pointer": &s,
+		"struct":  sensitiveHolder{Name: s, Names: []Sensitive{s}},
+		"slice":   []Sensitive{s, s},
+		"map key": map[Sensitive]string{s: "a"},
+	} {
+		out, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("marshalling %s: %v", name, err)
+		}
+
+		assertRedacted(t, "json of a "+name, string(out))
+	}
+}
+
+func TestSensitiveLog(t *testing.T) {
+	lock := &sync.Mutex{}
+	logged := []string{}
+
+	log.Init(func() (log.DoLog, log.Closer) {
+		return func(_ log.LogLevel, prefix, msg string) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			logged = append(logged, msg)
+		}, nil
+	})
+
+	s := Sensitive(testSecret)
+
+	log.Error("name: %v %s %d %q", s, s, s, s)
+	log.ErrorIfErr(fmt.Errorf("bad name %v", s), "checking %s", s)
+	log.Warn("names: %v", []Sensitive{s})
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(logged) != 3 {
+		t.Fatalf("expected 3 log messages, got %d", len(logged))
+	}
+
+	for _, msg := range logged {
+		assertRedacted(t, "log", msg)
+	}
+}
@@ -0,0 +1,159 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// A minimal CBOR (RFC 8949) decoder, enough for attestation objects & COSE keys.
+// Only definite lengths are supported, which is all that CTAP2 authenticators produce.
+//
+// Decoded values are: int64 (major types 0 & 1), []byte, string, []any, map[any]any (int64 or string keys), bool, nil, float64
+
+var ErrBadCBOR = errors.New("bad cbor")
+
+// The max nesting depth, COSE keys & attestation objects are nowhere near it
+const cborMaxDepth = 16
+
+// Decodes the 1st item of b, returning the rest of b
+func decodeCBOR(b []byte) (any, []byte, error) {
+	return decodeCBORItem(b, 0)
+}
+
+// Returns the argument of the head & the rest
+func cborArg(info byte, b []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), b, nil
+	case info == 24 && len(b) >= 1:
+		return uint64(b[0]), b[1:], nil
+	case info == 25 && len(b) >= 2:
+		return uint64(binary.BigEndian.Uint16(b)), b[2:], nil
+	case info == 26 && len(b) >= 4:
+		return uint64(binary.BigEndian.Uint32(b)), b[4:], nil
+	case info == 27 && len(b) >= 8:
+		return binary.BigEndian.Uint64(b), b[8:], nil
+	}
+
+	return 0, nil, ErrBadCBOR
+}
+
+func decodeCBORItem(b []byte, depth int) (any, []byte, error) {
+	if len(b) == 0 || depth > cborMaxDepth {
+		return nil, nil, ErrBadCBOR
+	}
+
+	major, info := b[0]>>5, b[0]&0x1f
+
+	// Simple values & floats have their own argument rules
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, b[1:], nil
+		case 21:
+			return true, b[1:], nil
+		case 22, 23:
+			return nil, b[1:], nil
+		case 26:
+			if len(b) < 5 {
+				return nil, nil, ErrBadCBOR
+			}
+
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b[1:]))), b[5:], nil
+		case 27:
+			if len(b) < 9 {
+				return nil, nil, ErrBadCBOR
+			}
+
+			return math.Float64frombits(binary.BigEndian.Uint64(b[1:])), b[9:], nil
+		}
+
+		return nil, nil, ErrBadCBOR
+	}
+
+	arg, rest, err := cborArg(info, b[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, ErrBadCBOR
+		}
+
+		return int64(arg), rest, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, ErrBadCBOR
+		}
+
+		return -1 - int64(arg), rest, nil
+	case 2, 3:
+		if arg > uint64(len(rest)) {
+			return nil, nil, ErrBadCBOR
+		}
+
+		if major == 2 {
+			return append([]byte{}, rest[:arg]...), rest[arg:], nil
+		}
+
+		return string(rest[:arg]), rest[arg:], nil
+	case 4:
+		// Every item is at least 1 byte
+		if arg > uint64(len(rest)) {
+			return nil, nil, ErrBadCBOR
+		}
+
+		arr := make([]any, 0, arg)
+
+		for i := uint64(0); i < arg; i++ {
+			var v any
+
+			v, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			arr = append(arr, v)
+		}
+
+		return arr, rest, nil
+	case 5:
+		if arg > uint64(len(rest))/2 {
+			return nil, nil, ErrBadCBOR
+		}
+
+		m := make(map[any]any, arg)
+
+		for i := uint64(0); i < arg; i++ {
+			var k, v any
+
+			k, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, ErrBadCBOR
+			}
+
+			v, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			m[k] = v
+		}
+
+		return m, rest, nil
+	case 6:
+		// Tags are ignored, only the tagged item matters
+		return decodeCBORItem(rest, depth+1)
+	}
+
+	return nil, nil, ErrBadCBOR
+}
@@ -0,0 +1,329 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// The server side of WebAuthn (passkeys): parsing & verifying what authenticators send back.
+// Only ES256 & RS256 credentials are supported. Attestation statements are not verified (ie. attestation "none").
+
+var (
+	ErrBadClientData = errors.New("bad client data")
+	ErrBadAuthData   = errors.New("bad authenticator data")
+	ErrBadKey        = errors.New("bad or unsupported public key")
+	ErrBadSignature  = errors.New("bad signature")
+)
+
+// Authenticator data flags
+const (
+	FLAG_USER_PRESENT  byte = 0x01
+	FLAG_USER_VERIFIED byte = 0x04
+	FLAG_ATTESTED      byte = 0x40
+	FLAG_EXTENSIONS    byte = 0x80
+)
+
+// COSE algorithms
+const (
+	ALG_ES256 = -7
+	ALG_RS256 = -257
+)
+
+type Config struct {
+	// The domain that credentials are scoped to
+	RPID   string
+	RPName string
+	// The allowed origins of the frontend
+	Origins []string
+}
+
+// nil if WebAuthn is not configured
+var Conf *Config
+
+// WEBAUTHN_ORIGINS (comma separated) defaults to FRONTEND_URL, WEBAUTHN_RP_ID defaults to the host of the 1st origin.
+// WEBAUTHN_RP_NAME defaults to "Who".
+func Init() {
+	origins := []string{}
+
+	raw := os.Getenv("WEBAUTHN_ORIGINS")
+	if strings.TrimSpace(raw) == "" {
+		raw = os.Getenv("FRONTEND_URL")
+	}
+
+	for _, o := range strings.Split(raw, ",") {
+		o = strings.TrimSuffix(strings.TrimSpace(o), "/")
+		if o != "" {
+			origins = append(origins, o)
+		}
+	}
+
+	if len(origins) == 0 {
+		Conf = nil
+		return
+	}
+
+	rpID := strings.TrimSpace(os.Getenv("WEBAUTHN_RP_ID"))
+	if rpID == "" {
+		if u, err := url.Parse(origins[0]); err == nil {
+			rpID = u.Hostname()
+		}
+	}
+
+	rpName := strings.TrimSpace(os.Getenv("WEBAUTHN_RP_NAME"))
+	if rpName == "" {
+		rpName = "Who"
+	}
+
+	Conf = &Config{
+		RPID:    rpID,
+		RPName:  rpName,
+		Origins: origins,
+	}
+}
+
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func ParseClientData(raw []byte) (*ClientData, error) {
+	cd := &ClientData{}
+
+	if err := json.Unmarshal(raw, cd); err != nil {
+		return nil, ErrBadClientData
+	}
+
+	return cd, nil
+}
+
+// Checks the client data of a ceremony. typ is "webauthn.create" or "webauthn.get".
+func (c *Config) VerifyClientData(cd *ClientData, typ, challenge string) error {
+	if cd.Type != typ || cd.Challenge != challenge || cd.CrossOrigin {
+		return ErrBadClientData
+	}
+
+	for _, o := range c.Origins {
+		if cd.Origin == o {
+			return nil
+		}
+	}
+
+	return ErrBadClientData
+}
+
+type AuthData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	// Only set if FLAG_ATTESTED is set (ie. on registration)
+	AAGUID       []byte
+	CredentialID []byte
+	// COSE_Key
+	PublicKey []byte
+}
+
+func ParseAuthData(b []byte) (*AuthData, error) {
+	if len(b) < 37 {
+		return nil, ErrBadAuthData
+	}
+
+	ad := &AuthData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+
+	rest := b[37:]
+
+	if ad.Flags&FLAG_ATTESTED != 0 {
+		if len(rest) < 18 {
+			return nil, ErrBadAuthData
+		}
+
+		ad.AAGUID = rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+
+		if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+			return nil, ErrBadAuthData
+		}
+
+		ad.CredentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrBadAuthData
+		}
+
+		ad.PublicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if ad.Flags&FLAG_EXTENSIONS != 0 {
+		var err error
+
+		_, rest, err = decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrBadAuthData
+		}
+	}
+
+	if len(rest) != 0 {
+		return nil, ErrBadAuthData
+	}
+
+	return ad, nil
+}
+
+// Parses the attestation object of a registration, returning its authenticator data
+func ParseAttestationObject(b []byte) (*AuthData, error) {
+	v, rest, err := decodeCBOR(b)
+	if err != nil || len(rest) != 0 {
+		return nil, ErrBadAuthData
+	}
+
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, ErrBadAuthData
+	}
+
+	raw, ok := m["authData"].([]byte)
+	if !ok {
+		return nil, ErrBadAuthData
+	}
+
+	return ParseAuthData(raw)
+}
+
+// Checks the RP ID hash & the user presence flag
+func (c *Config) VerifyAuthData(ad *AuthData, requireUV bool) error {
+	hash := sha256.Sum256([]byte(c.RPID))
+
+	if !bytes.Equal(ad.RPIDHash, hash[:]) || ad.Flags&FLAG_USER_PRESENT == 0 {
+		return ErrBadAuthData
+	}
+
+	if requireUV && ad.Flags&FLAG_USER_VERIFIED == 0 {
+		return ErrBadAuthData
+	}
+
+	return nil
+}
+
+// Checks the sign count of an assertion against the stored one.
+// Authenticators that don't count always send 0. Otherwise, a count that didn't go up means the passkey might have been cloned.
+func SignCountValid(stored, received uint32) bool {
+	return (stored == 0 && received == 0) || received > stored
+}
+
+func coseInt(m map[any]any, k int64) (int64, bool) {
+	v, ok := m[k].(int64)
+	return v, ok
+}
+
+func coseBytes(m map[any]any, k int64) ([]byte, bool) {
+	v, ok := m[k].([]byte)
+	return v, ok
+}
+
+// Parses a COSE_Key, returning an *ecdsa.PublicKey or *rsa.PublicKey
+func ParsePublicKey(cose []byte) (crypto.PublicKey, error) {
+	v, rest, err := decodeCBOR(cose)
+	if err != nil || len(rest) != 0 {
+		return nil, ErrBadKey
+	}
+
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, ErrBadKey
+	}
+
+	kty, _ := coseInt(m, 1)
+	alg, _ := coseInt(m, 3)
+
+	switch {
+	case kty == 2 && alg == ALG_ES256:
+		crv, _ := coseInt(m, -1)
+		x, okX := coseBytes(m, -2)
+		y, okY := coseBytes(m, -3)
+
+		if crv != 1 || !okX || !okY || len(x) != 32 || len(y) != 32 {
+			return nil, ErrBadKey
+		}
+
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, ErrBadKey
+		}
+
+		return pub, nil
+	case kty == 3 && alg == ALG_RS256:
+		n, okN := coseBytes(m, -1)
+		e, okE := coseBytes(m, -2)
+
+		if !okN || !okE || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrBadKey
+		}
+
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: exp,
+		}, nil
+	}
+
+	return nil, ErrBadKey
+}
+
+// Verifies an assertion signature, which is over authData || sha256(clientDataJSON)
+func VerifySignature(cose, authData, clientData, sig []byte) error {
+	pub, err := ParsePublicKey(cose)
+	if err != nil {
+		return err
+	}
+
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	}
+
+	return ErrBadSignature
+}
@@ -0,0 +1,313 @@
+package webauthn_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/shadiestgoat/who/webauthn"
+	"github.com/shadiestgoat/who/webauthn/webauthntest"
+)
+
+const (
+	testRPID      = "who.test"
+	testOrigin    = "https://who.test"
+	testChallenge = "Y2hhbGxlbmdl"
+)
+
+var testConf = &webauthn.Config{
+	RPID:    testRPID,
+	RPName:  "Who",
+	Origins: []string{testOrigin},
+}
+
+// Runs the registration checks of api.FinishPasskeyRegistration
+func register(a *webauthntest.Authenticator, challenge string) (*webauthn.AuthData, error) {
+	rawCD, attObj := a.Register(challenge)
+
+	cd, err := webauthn.ParseClientData(rawCD)
+	if err != nil {
+		return nil, err
+	}
+	if err := testConf.VerifyClientData(cd, "webauthn.create", testChallenge); err != nil {
+		return nil, err
+	}
+
+	ad, err := webauthn.ParseAttestationObject(attObj)
+	if err != nil {
+		return nil, err
+	}
+	if err := testConf.VerifyAuthData(ad, true); err != nil {
+		return nil, err
+	}
+
+	if _, err := webauthn.ParsePublicKey(ad.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return ad, nil
+}
+
+// Runs the assertion checks of api.FinishPasskeyLogin
+func assert(a *webauthntest.Authenticator, cose []byte, challenge string) error {
+	rawCD, authData, sig := a.Assert(challenge)
+
+	return verifyAssertion(cose, rawCD, authData, sig)
+}
+
+func verifyAssertion(cose, rawCD, authData, sig []byte) error {
+	cd, err := webauthn.ParseClientData(rawCD)
+	if err != nil {
+		return err
+	}
+	if err := testConf.VerifyClientData(cd, "webauthn.get", testChallenge); err != nil {
+		return err
+	}
+
+	ad, err := webauthn.ParseAuthData(authData)
+	if err != nil {
+		return err
+	}
+	if err := testConf.VerifyAuthData(ad, true); err != nil {
+		return err
+	}
+
+	return webauthn.VerifySignature(cose, authData, rawCD, sig)
+}
+
+func TestCeremonies(t *testing.T) {
+	a := webauthntest.New(testRPID, testOrigin)
+	a.SignCount = 3
+
+	ad, err := register(a, testChallenge)
+	if err != nil {
+		t.Fatalf("registering: %v", err)
+	}
+
+	if string(ad.CredentialID) != string(a.CredentialID) || ad.SignCount != 3 {
+		t.Errorf("unexpected authenticator data: %+v", ad)
+	}
+
+	a.SignCount = 4
+
+	if err := assert(a, ad.PublicKey, testChallenge); err != nil {
+		t.Errorf("logging in: %v", err)
+	}
+}
+
+func TestWrongOrigin(t *testing.T) {
+	a := webauthntest.New(testRPID, "https://evil.test")
+
+	if _, err := register(a, testChallenge); !errors.Is(err, webauthn.ErrBadClientData) {
+		t.Errorf("registering from another origin: expected ErrBadClientData, got %v", err)
+	}
+
+	good := webauthntest.New(testRPID, testOrigin)
+	ad, err := register(good, testChallenge)
+	if err != nil {
+		t.Fatalf("registering: %v", err)
+	}
+
+	good.Origin = "https://who.test.evil.test"
+	if err := assert(good, ad.PublicKey, testChallenge); !errors.Is(err, webauthn.ErrBadClientData) {
+		t.Errorf("logging in from another origin: expected ErrBadClientData, got %v", err)
+	}
+}
+
+func TestBadClientData(t *testing.T) {
+	a := webauthntest.New(testRPID, testOrigin)
+
+	for name, cd := range map[string]*webauthn.ClientData{
+		"another challenge": {Type: "webauthn.get", Challenge: "b3RoZXI", Origin: testOrigin},
+		"another type":      {Type: "webauthn.create", Challenge: testChallenge, Origin: testOrigin},
+		"cross origin":      {Type: "webauthn.get", Challenge: testChallenge, Origin: testOrigin, CrossOrigin: true},
+	} {
+		if err := testConf.VerifyClientData(cd, "webauthn.get", testChallenge); !errors.Is(err, webauthn.ErrBadClientData) {
+			t.Errorf("%s: expected ErrBadClientData, got %v", name, err)
+		}
+	}
+
+	if _, err := webauthn.ParseClientData([]byte("{")); !errors.Is(err, webauthn.ErrBadClientData) {
+		t.Errorf("bad json: expected ErrBadClientData, got %v", err)
+	}
+
+	// The challenge in the signed client data is what counts, not the one the client claims
+	ad, err := register(a, testChallenge)
+	if err != nil {
+		t.Fatalf("registering: %v", err)
+	}
+
+	if err := assert(a, ad.PublicKey, "b3RoZXI"); !errors.Is(err, webauthn.ErrBadClientData) {
+		t.Errorf("signed for another challenge: expected ErrBadClientData, got %v", err)
+	}
+}
+
+func TestWrongRPIDHash(t *testing.T) {
+	a := webauthntest.New("evil.test", testOrigin)
+
+	if _, err := register(a, testChallenge); !errors.Is(err, webauthn.ErrBadAuthData) {
+		t.Errorf("registering for another RP ID: expected ErrBadAuthData, got %v", err)
+	}
+
+	good := webauthntest.New(testRPID, testOrigin)
+	ad, err := register(good, testChallenge)
+	if err != nil {
+		t.Fatalf("registering: %v", err)
+	}
+
+	good.RPID = "who.test.evil.test"
+	if err := assert(good, ad.PublicKey, testChallenge); !errors.Is(err, webauthn.ErrBadAuthData) {
+		t.Errorf("logging in for another RP ID: expected ErrBadAuthData, got %v", err)
+	}
+}
+
+func TestMissingFlags(t *testing.T) {
+	for name, flags := range map[string]byte{
+		"no user verification": webauthn.FLAG_USER_PRESENT,
+		"no user presence":     webauthn.FLAG_USER_VERIFIED,
+		"no flags":             0,
+	} {
+		a := webauthntest.New(testRPID, testOrigin)
+		a.Flags = flags
+
+		if _, err := register(a, testChallenge); !errors.Is(err, webauthn.ErrBadAuthData) {
+			t.Errorf("registering with %s: expected ErrBadAuthData, got %v", name, err)
+		}
+
+		good := webauthntest.New(testRPID, testOrigin)
+		ad, err := register(good, testChallenge)
+		if err != nil {
+			t.Fatalf("registering: %v", err)
+		}
+
+		good.Flags = flags
+		if err := assert(good, ad.PublicKey, testChallenge); !errors.Is(err, webauthn.ErrBadAuthData) {
+			t.Errorf("logging in with %s: expected ErrBadAuthData, got %v", name, err)
+		}
+	}
+
+	// User presence is enough when UV isn't required
+	a := webauthntest.New(testRPID, testOrigin)
+	a.Flags = webauthn.FLAG_USER_PRESENT
+
+	ad, err := webauthn.ParseAuthData(a.AuthData(false))
+	if err != nil {
+		t.Fatalf("parsing: %v", err)
+	}
+	if err := testConf.VerifyAuthData(ad, false); err != nil {
+		t.Errorf("user presence without UV: %v", err)
+	}
+}
+
+func TestTruncatedAuthData(t *testing.T) {
+	a := webauthntest.New(testRPID, testOrigin)
+
+	for name, authData := range map[string][]byte{
+		"attested":  a.AuthData(true),
+		"assertion": a.AuthData(false),
+	} {
+		if _, err := webauthn.ParseAuthData(authData); err != nil {
+			t.Fatalf("%s: parsing the full authenticator data: %v", name, err)
+		}
+
+		for l := 0; l < len(authData); l++ {
+			if _, err := webauthn.ParseAuthData(authData[:l]); !errors.Is(err, webauthn.ErrBadAuthData) {
+				t.Errorf("%s: truncated to %d of %d bytes: expected ErrBadAuthData, got %v", name, l, len(authData), err)
+			}
+		}
+
+		if _, err := webauthn.ParseAuthData(append(authData, 0)); !errors.Is(err, webauthn.ErrBadAuthData) {
+			t.Errorf("%s: trailing bytes: expected ErrBadAuthData, got %v", name, err)
+		}
+	}
+
+	_, attObj := a.Register(testChallenge)
+	for l := 0; l < len(attObj); l++ {
+		if _, err := webauthn.ParseAttestationObject(attObj[:l]); err == nil {
+			t.Errorf("attestation object truncated to %d of %d bytes was accepted", l, len(attObj))
+		}
+	}
+}
+
+func TestBadSignature(t *testing.T) {
+	a := webauthntest.New(testRPID, testOrigin)
+
+	ad, err := register(a, testChallenge)
+	if err != nil {
+		t.Fatalf("registering: %v", err)
+	}
+
+	rawCD, authData, sig := a.Assert(testChallenge)
+
+	// Signed by another key
+	other := webauthntest.New(testRPID, testOrigin)
+	if err := verifyAssertion(ad.PublicKey, rawCD, authData, other.Sign(authData, rawCD)); !errors.Is(err, webauthn.ErrBadSignature) {
+		t.Errorf("another key: expected ErrBadSignature, got %v", err)
+	}
+
+	// A higher sign count that wasn't signed
+	a.SignCount = 100
+	if err := verifyAssertion(ad.PublicKey, rawCD, a.AuthData(false), sig); !errors.Is(err, webauthn.ErrBadSignature) {
+		t.Errorf("changed authenticator data: expected ErrBadSignature, got %v", err)
+	}
+
+	// Client data that wasn't signed
+	cd := &webauthn.ClientData{}
+	json.Unmarshal(rawCD, cd)
+	cd.Origin = testOrigin + "/"
+	changedCD, _ := json.Marshal(cd)
+	cd.Origin = testOrigin
+	reorderedCD, _ := json.Marshal(map[string]any{"origin": cd.Origin, "challenge": cd.Challenge, "type": cd.Type})
+
+	for _, c := range [][]byte{changedCD, reorderedCD} {
+		if err := webauthn.VerifySignature(ad.PublicKey, authData, c, sig); !errors.Is(err, webauthn.ErrBadSignature) {
+			t.Errorf("changed client data: expected ErrBadSignature, got %v", err)
+		}
+	}
+
+	if err := webauthn.VerifySignature(ad.PublicKey, authData, rawCD, sig[:len(sig)-1]); !errors.Is(err, webauthn.ErrBadSignature) {
+		t.Errorf("truncated signature: expected ErrBadSignature, got %v", err)
+	}
+}
+
+func TestSignCount(t *testing.T) {
+	for _, c := range []struct {
+		stored, received uint32
+		valid            bool
+	}{
+		{0, 0, true},
+		{0, 1, true},
+		{5, 6, true},
+		{5, 5, false},
+		{5, 4, false},
+		{5, 0, false},
+		{0xffffffff, 0, false},
+	} {
+		if got := webauthn.SignCountValid(c.stored, c.received); got != c.valid {
+			t.Errorf("SignCountValid(%d, %d) = %v, expected %v", c.stored, c.received, got, c.valid)
+		}
+	}
+}
+
+func TestBadPublicKey(t *testing.T) {
+	a := webauthntest.New(testRPID, testOrigin)
+	cose := a.COSEKey()
+
+	if _, err := webauthn.ParsePublicKey(cose); err != nil {
+		t.Fatalf("parsing the key: %v", err)
+	}
+
+	for l := 0; l < len(cose); l++ {
+		if _, err := webauthn.ParsePublicKey(cose[:l]); !errors.Is(err, webauthn.ErrBadKey) {
+			t.Errorf("key truncated to %d of %d bytes: expected ErrBadKey, got %v", l, len(cose), err)
+		}
+	}
+
+	// A point that's not on the curve: flip a bit of the last y byte
+	bad := append([]byte{}, cose...)
+	bad[len(bad)-1] ^= 1
+
+	if _, err := webauthn.ParsePublicKey(bad); !errors.Is(err, webauthn.ErrBadKey) {
+		t.Errorf("point off the curve: expected ErrBadKey, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/shadiestgoat/who/webauthn"
+)
+
+// A software authenticator with an ES256 key, for testing WebAuthn ceremonies without a browser.
+// It produces the same client data, authenticator data, attestation objects (attestation "none") & signatures that a real one would.
+// Every field can be changed between ceremonies, to produce bad responses (ie. another RP ID or a lower sign count).
+
+type Authenticator struct {
+	Key          *ecdsa.PrivateKey
+	CredentialID []byte
+
+	// Hashed into the authenticator data
+	RPID string
+	// Put in the client data
+	Origin string
+	// Put in the authenticator data as is, it's not increased by ceremonies
+	SignCount uint32
+	// Defaults to webauthn.FLAG_USER_PRESENT | webauthn.FLAG_USER_VERIFIED. FLAG_ATTESTED is added on registration.
+	Flags byte
+}
+
+func New(rpID, origin string) *Authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("webauthntest: generating a key: " + err.Error())
+	}
+
+	credID := make([]byte, 16)
+	if _, err := rand.Read(credID); err != nil {
+		panic("webauthntest: generating a credential ID: " + err.Error())
+	}
+
+	return &Authenticator{
+		Key:          key,
+		CredentialID: credID,
+		RPID:         rpID,
+		Origin:       origin,
+		Flags:        webauthn.FLAG_USER_PRESENT | webauthn.FLAG_USER_VERIFIED,
+	}
+}
+
+// The COSE_Key of the public key
+func (a *Authenticator) COSEKey() []byte {
+	x, y := make([]byte, 32), make([]byte, 32)
+	a.Key.X.FillBytes(x)
+	a.Key.Y.FillBytes(y)
+
+	return cborMap(
+		cborInt(1), cborInt(2),
+		cborInt(3), cborInt(webauthn.ALG_ES256),
+		cborInt(-1), cborInt(1),
+		cborInt(-2), cborBytes(x),
+		cborInt(-3), cborBytes(y),
+	)
+}
+
+// typ is "webauthn.create" or "webauthn.get"
+func (a *Authenticator) ClientData(typ, challenge string) []byte {
+	b, _ := json.Marshal(&webauthn.ClientData{
+		Type:      typ,
+		Challenge: challenge,
+		Origin:    a.Origin,
+	})
+
+	return b
+}
+
+// With attested, the credential ID & public key are included (ie. for registration)
+func (a *Authenticator) AuthData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+
+	flags := a.Flags
+	if attested {
+		flags |= webauthn.FLAG_ATTESTED
+	}
+
+	b := append([]byte{}, rpIDHash[:]...)
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint32(b, a.SignCount)
+
+	if attested {
+		// AAGUID
+		b = append(b, make([]byte, 16)...)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(a.CredentialID)))
+		b = append(b, a.CredentialID...)
+		b = append(b, a.COSEKey()...)
+	}
+
+	return b
+}
+
+// Returns the client data & the attestation object of navigator.credentials.create
+func (a *Authenticator) Register(challenge string) (clientData, attestationObject []byte) {
+	return a.ClientData("webauthn.create", challenge), cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(a.AuthData(true)),
+	)
+}
+
+// Returns the client data, authenticator data & signature of navigator.credentials.get
+func (a *Authenticator) Assert(challenge string) (clientData, authData, sig []byte) {
+	clientData = a.ClientData("webauthn.get", challenge)
+	authData = a.AuthData(false)
+
+	return clientData, authData, a.Sign(authData, clientData)
+}
+
+// Signs authData || sha256(clientData), like an assertion
+func (a *Authenticator) Sign(authData, clientData []byte) []byte {
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, a.Key, digest[:])
+	if err != nil {
+		panic("webauthntest: signing: " + err.Error())
+	}
+
+	return sig
+}
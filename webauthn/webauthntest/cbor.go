@@ -0,0 +1,47 @@
+package webauthntest
+
+import "encoding/binary"
+
+// A minimal CBOR encoder, the counterpart of the decoder in the webauthn package
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+}
+
+func cborInt(i int64) []byte {
+	if i < 0 {
+		return cborHead(1, uint64(-1-i))
+	}
+
+	return cborHead(0, uint64(i))
+}
+
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, uint64(len(b))), b...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHead(3, uint64(len(s))), s...)
+}
+
+// kv are encoded keys & values, alternating
+func cborMap(kv ...[]byte) []byte {
+	b := cborHead(5, uint64(len(kv)/2))
+
+	for _, item := range kv {
+		b = append(b, item...)
+	}
+
+	return b
+}